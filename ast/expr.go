@@ -28,6 +28,13 @@ var (
 	_ Expr = (*BinaryExpr)(nil)
 	_ Expr = (*UnaryExpr)(nil)
 	_ Expr = (*GroupingExpr)(nil)
+	_ Expr = (*RangeExpr)(nil)
+	_ Expr = (*ListExpr)(nil)
+	_ Expr = (*MapExpr)(nil)
+	_ Expr = (*MatchExpr)(nil)
+	_ Expr = (*CallExpr)(nil)
+	_ Expr = (*MemberExpr)(nil)
+	_ Expr = (*PropagateExpr)(nil)
 
 	_ Expr = (*Block)(nil)
 )
@@ -159,10 +166,12 @@ func (Nil) String() string {
 
 func (Nil) exprNode() {}
 
-// UnaryExpr represents a node of unary expression.
+// UnaryExpr represents a node of unary expression. Loc is the position of
+// the operator.
 type UnaryExpr struct {
-	X  Expr
-	Op token.Kind
+	Loc token.Location
+	X   Expr
+	Op  token.Kind
 }
 
 func (ue *UnaryExpr) Accept(v Visitor) any {
@@ -175,8 +184,10 @@ func (ue *UnaryExpr) String() string {
 
 func (*UnaryExpr) exprNode() {}
 
-// BinaryExpr represents a node of binary expression.
+// BinaryExpr represents a node of binary expression. Loc is the position of
+// the operator.
 type BinaryExpr struct {
+	Loc      token.Location
 	Lhs, Rhs Expr
 	Op       token.Kind
 }
@@ -205,8 +216,115 @@ func (ge *GroupingExpr) String() string {
 
 func (*GroupingExpr) exprNode() {}
 
+// RangeExpr represents `Start..End` or `Start..=End`, optionally followed by
+// `step Step`. Step is nil when omitted. Loc is the position of the operator.
+type RangeExpr struct {
+	Loc        token.Location
+	Start, End Expr
+	Step       Expr
+	Inclusive  bool
+}
+
+func (re *RangeExpr) Accept(v Visitor) any {
+	return v.VisitRangeExpr(re)
+}
+
+func (re *RangeExpr) String() string {
+	op := ".."
+	if re.Inclusive {
+		op = "..="
+	}
+	s := re.Start.String() + op + re.End.String()
+	if re.Step != nil {
+		s += " step " + re.Step.String()
+	}
+	return s
+}
+
+func (*RangeExpr) exprNode() {}
+
+// ListExpr represents a list literal such as `[1, 2, 3]`.
+type ListExpr struct {
+	Elems []Expr
+}
+
+func (le *ListExpr) Accept(v Visitor) any {
+	return v.VisitListExpr(le)
+}
+
+func (le *ListExpr) String() string {
+	return "[" + ExprList(le.Elems).String() + "]"
+}
+
+func (*ListExpr) exprNode() {}
+
+// MapExpr represents a map literal such as `{name: "x", 1: 'y'}`. A bare
+// identifier key stands for the string of the same name.
+type MapExpr struct {
+	Keys   []Expr
+	Values []Expr
+}
+
+func (me *MapExpr) Accept(v Visitor) any {
+	return v.VisitMapExpr(me)
+}
+
+func (me *MapExpr) String() string {
+	ss := make([]string, 0, len(me.Keys))
+	for j := range me.Keys {
+		ss = append(ss, me.Keys[j].String()+": "+me.Values[j].String())
+	}
+	return "{" + strings.Join(ss, ", ") + "}"
+}
+
+func (*MapExpr) exprNode() {}
+
+// MatchExpr represents `match Value { Arms }`. Like IfElseStmt, it is a
+// statement as well.
+type MatchExpr struct {
+	Value Expr
+	Arms  []*MatchArm
+}
+
+// MatchArm represents `Pattern if Guard => Body`. Guard is nil if absent.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expr
+	Body    Expr
+}
+
+func (ma *MatchArm) String() string {
+	s := ma.Pattern.String()
+	if ma.Guard != nil {
+		s += " if " + ma.Guard.String()
+	}
+	return s + " => " + ma.Body.String()
+}
+
+func (me *MatchExpr) Accept(v Visitor) any {
+	return v.VisitMatchExpr(me)
+}
+
+func (me *MatchExpr) String() string {
+	ss := make([]string, 0, len(me.Arms))
+	for _, arm := range me.Arms {
+		ss = append(ss, arm.String())
+	}
+	return "match " + me.Value.String() + " {" + strings.Join(ss, ", ") + "}"
+}
+
+func (*MatchExpr) exprNode() {}
+func (*MatchExpr) stmtNode() {}
+
 type Variable struct {
+	Loc   token.Location
 	Ident string
+	// Depth and Slot locate the binding Ident refers to, and are set by the
+	// resolver: the binding is the Slot-th of the scope Depth levels out from
+	// the one of the variable. Depth is -1 for builtins, whose Slot is the
+	// one resolver.BuiltinSlot gives their name.
+	Depth int
+	Slot  int
 }
 
 func (ve *Variable) Accept(v Visitor) any {
@@ -221,6 +339,13 @@ func (*Variable) exprNode() {}
 
 type Block struct {
 	Statements []Stmt
+	// Value is the trailing expression that gives the block its value, as in
+	// `{ let x = 1; x + 1 }`. It is nil if there is none.
+	Value Expr
+	// Slots is the number of slots of the scope of the block, as set by the
+	// resolver. A block that declares nothing has no scope of its own, and
+	// Slots 0.
+	Slots int
 }
 
 func (blk *Block) Accept(v Visitor) any {
@@ -232,6 +357,9 @@ func (blk *Block) String() string {
 	for _, stmt := range blk.Statements {
 		ss = append(ss, stmt.String())
 	}
+	if blk.Value != nil {
+		ss = append(ss, blk.Value.String())
+	}
 	return "{" + strings.Join(ss, "; ") + "}"
 }
 
@@ -240,8 +368,16 @@ func (*Block) exprNode() {}
 func (*Block) stmtNode() {}
 
 type CallExpr struct {
-	Callee string
+	Loc    token.Location
+	Callee Expr
 	Args   []Expr
+	// ArgNames holds the names of named arguments, as in `f(b: 2)`, with ""
+	// for positional ones. It is nil if all the arguments are positional.
+	ArgNames []string
+	// Tail reports whether the call is in tail position in a function, as in
+	// `return f(x);`, so that the callee may take over the frame of the
+	// caller. The resolver sets it.
+	Tail bool
 }
 
 func (call *CallExpr) Accept(v Visitor) any {
@@ -250,16 +386,66 @@ func (call *CallExpr) Accept(v Visitor) any {
 
 func (call *CallExpr) String() string {
 	ss := make([]string, 0, len(call.Args))
-	for _, a := range call.Args {
-		ss = append(ss, a.String())
+	for j, a := range call.Args {
+		if call.ArgNames != nil && call.ArgNames[j] != "" {
+			ss = append(ss, call.ArgNames[j]+": "+a.String())
+		} else {
+			ss = append(ss, a.String())
+		}
 	}
-	return call.Callee + "(" + strings.Join(ss, ", ") + ")"
+	return CalleeName(call.Callee) + "(" + strings.Join(ss, ", ") + ")"
 }
 
 func (call *CallExpr) exprNode() {}
 
+// CalleeName returns the name by which callee is called, as used in messages.
+func CalleeName(callee Expr) string {
+	switch c := callee.(type) {
+	case *Variable:
+		return c.Ident
+	case *MemberExpr:
+		return CalleeName(c.X) + "." + c.Name
+	default:
+		return c.String()
+	}
+}
+
+// MemberExpr represents `X.Name`, which accesses a binding of a module or a
+// key of a map. Loc is the position of the dot.
+type MemberExpr struct {
+	Loc  token.Location
+	X    Expr
+	Name string
+}
+
+func (me *MemberExpr) Accept(v Visitor) any {
+	return v.VisitMemberExpr(me)
+}
+
+func (me *MemberExpr) String() string {
+	return me.X.String() + "." + me.Name
+}
+
+func (*MemberExpr) exprNode() {}
+
+// PropagateExpr represents `X?`, which unwraps ok(v) to v and returns err(e)
+// from the enclosing function.
+type PropagateExpr struct {
+	X Expr
+}
+
+func (pe *PropagateExpr) Accept(v Visitor) any {
+	return v.VisitPropagateExpr(pe)
+}
+
+func (pe *PropagateExpr) String() string {
+	return pe.X.String() + "?"
+}
+
+func (*PropagateExpr) exprNode() {}
+
 type Lambda struct {
-	Params []string
+	Params []*Param
 	Body   Stmt
 }
 
@@ -268,7 +454,7 @@ func (lmb *Lambda) Accept(v Visitor) any {
 }
 
 func (lmb *Lambda) String() string {
-	return "fn (" + strings.Join(lmb.Params, ", ") + ") " + lmb.Body.String()
+	return "fn (" + ParamList(lmb.Params).String() + ") " + lmb.Body.String()
 }
 
 func (lmb *Lambda) exprNode() {}
@@ -0,0 +1,162 @@
+package ast
+
+import "strings"
+
+// Pattern represents the shape a value is tested against, and the variables
+// bound from it, on the left-hand side of a match arm.
+type Pattern interface {
+	String() string
+	// patternNode is a marker interface.
+	patternNode()
+}
+
+var (
+	_ Pattern = (*WildcardPattern)(nil)
+	_ Pattern = (*BindingPattern)(nil)
+	_ Pattern = (*LiteralPattern)(nil)
+	_ Pattern = (*RangePattern)(nil)
+	_ Pattern = (*ListPattern)(nil)
+	_ Pattern = (*RecordPattern)(nil)
+)
+
+// WildcardPattern represents `_`, which matches anything and binds nothing.
+type WildcardPattern struct{}
+
+func (*WildcardPattern) String() string {
+	return "_"
+}
+
+func (*WildcardPattern) patternNode() {}
+
+// BindingPattern matches anything and binds it to Ident.
+type BindingPattern struct {
+	Ident string
+	// Slot is the slot of Ident in its scope, as set by the resolver.
+	Slot int
+}
+
+func (bp *BindingPattern) String() string {
+	return bp.Ident
+}
+
+func (*BindingPattern) patternNode() {}
+
+// LiteralPattern matches values equal to Value, which is a literal.
+type LiteralPattern struct {
+	Value Expr
+}
+
+func (lp *LiteralPattern) String() string {
+	return lp.Value.String()
+}
+
+func (*LiteralPattern) patternNode() {}
+
+// RangePattern matches numbers and characters between the literals Start and
+// End.
+type RangePattern struct {
+	Start, End Expr
+	Inclusive  bool
+}
+
+func (rp *RangePattern) String() string {
+	if rp.Inclusive {
+		return rp.Start.String() + "..=" + rp.End.String()
+	}
+	return rp.Start.String() + ".." + rp.End.String()
+}
+
+func (*RangePattern) patternNode() {}
+
+// ListPattern matches lists element by element, as in `[a, b, ...rest]`.
+// Without a rest element the list must have exactly len(Elems) elements.
+type ListPattern struct {
+	Elems []Pattern
+	// HasRest reports whether the pattern ends with `...` or `...Rest`.
+	HasRest bool
+	// Rest is the variable bound to the remaining elements, or "" if they are
+	// ignored. RestSlot is its slot.
+	Rest     string
+	RestSlot int
+}
+
+func (lp *ListPattern) String() string {
+	ss := make([]string, 0, len(lp.Elems)+1)
+	for _, e := range lp.Elems {
+		ss = append(ss, e.String())
+	}
+	if lp.HasRest {
+		ss = append(ss, "..."+lp.Rest)
+	}
+	return "[" + strings.Join(ss, ", ") + "]"
+}
+
+func (*ListPattern) patternNode() {}
+
+// RecordPattern matches maps having all of Keys, as in `{x, y: 0}`. Other keys
+// are ignored.
+type RecordPattern struct {
+	Keys   []string
+	Values []Pattern
+}
+
+func (rp *RecordPattern) String() string {
+	ss := make([]string, 0, len(rp.Keys))
+	for j, k := range rp.Keys {
+		if b, ok := rp.Values[j].(*BindingPattern); ok && b.Ident == k {
+			ss = append(ss, k)
+		} else {
+			ss = append(ss, k+": "+rp.Values[j].String())
+		}
+	}
+	return "{" + strings.Join(ss, ", ") + "}"
+}
+
+func (*RecordPattern) patternNode() {}
+
+// Names returns the variables bound by p, in order of appearance.
+func Names(p Pattern) []string {
+	var names []string
+	eachBinding(p, func(name string, _ *int) {
+		names = append(names, name)
+	})
+	return names
+}
+
+// Slots returns the slots of the variables bound by p, in the order of Names.
+func Slots(p Pattern) []int {
+	var slots []int
+	eachBinding(p, func(_ string, slot *int) {
+		slots = append(slots, *slot)
+	})
+	return slots
+}
+
+// SetSlots sets the slots of the variables bound by p, in the order of Names.
+func SetSlots(p Pattern, slots []int) {
+	j := 0
+	eachBinding(p, func(_ string, slot *int) {
+		*slot = slots[j]
+		j++
+	})
+}
+
+// eachBinding calls f with the name and a pointer to the slot of every
+// variable bound by p, in order of appearance.
+func eachBinding(p Pattern, f func(name string, slot *int)) {
+	switch p := p.(type) {
+	case *BindingPattern:
+		f(p.Ident, &p.Slot)
+	case *ListPattern:
+		for _, e := range p.Elems {
+			eachBinding(e, f)
+		}
+		if p.Rest != "" {
+			f(p.Rest, &p.RestSlot)
+		}
+	case *RecordPattern:
+		for _, v := range p.Values {
+			eachBinding(v, f)
+		}
+	}
+}
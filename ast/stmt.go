@@ -2,7 +2,10 @@ package ast
 
 import (
 	"fmt"
+	"path"
 	"strings"
+
+	"naive/token"
 )
 
 type Stmt interface {
@@ -15,18 +18,34 @@ var (
 	_ Stmt = (*LetStmt)(nil)
 	_ Stmt = (*IfElseStmt)(nil)
 	_ Stmt = (*WhileStmt)(nil)
+	_ Stmt = (*ForStmt)(nil)
+	_ Stmt = (*BreakStmt)(nil)
+	_ Stmt = (*ContinueStmt)(nil)
 	_ Stmt = (*FnStmt)(nil)
 	_ Stmt = (*ReturnStmt)(nil)
+	_ Stmt = (*ThrowStmt)(nil)
+	_ Stmt = (*TryStmt)(nil)
 	_ Stmt = (*AssignStmt)(nil)
+	_ Stmt = (*ImportStmt)(nil)
 	_ Stmt = (*ExprStmt)(nil)
 	_ Stmt = (*EmptyStmt)(nil)
 
 	_ Stmt = (*Block)(nil)
+	_ Stmt = (*MatchExpr)(nil)
 )
 
+// LetStmt represents `let Ident = Init;`, or `const Ident = Init;` if Const
+// is set.
 type LetStmt struct {
+	Loc   token.Location
+	Const bool
 	Ident string
-	Init  Expr
+	// Slot is the slot of Ident in its scope, as set by the resolver.
+	Slot int
+	// Pattern destructures Init, as in `let [a, b] = xs;`. It is nil if the
+	// statement binds the single variable Ident.
+	Pattern Pattern
+	Init    Expr
 }
 
 func (ds *LetStmt) Accept(v Visitor) any {
@@ -34,14 +53,40 @@ func (ds *LetStmt) Accept(v Visitor) any {
 }
 
 func (ds *LetStmt) String() string {
-	return fmt.Sprintf("LET ident=%s init=%s", ds.Ident, ds.Init.String())
+	kw := "LET"
+	if ds.Const {
+		kw = "CONST"
+	}
+	if ds.Pattern != nil {
+		return fmt.Sprintf("%s pattern=%s init=%s", kw, ds.Pattern, ds.Init.String())
+	}
+	return fmt.Sprintf("%s ident=%s init=%s", kw, ds.Ident, ds.Init.String())
+}
+
+// Names returns the variables bound by the statement.
+func (ds *LetStmt) Names() []string {
+	if ds.Pattern != nil {
+		return Names(ds.Pattern)
+	}
+	return []string{ds.Ident}
+}
+
+// Slots returns the slots of the variables bound by the statement, in the
+// order of Names.
+func (ds *LetStmt) Slots() []int {
+	if ds.Pattern != nil {
+		return Slots(ds.Pattern)
+	}
+	return []int{ds.Slot}
 }
 
 func (*LetStmt) stmtNode() {}
 
 type FnStmt struct {
+	Loc    token.Location
 	Ident  string
-	Params []string
+	Slot   int
+	Params []*Param
 	Body   Stmt
 }
 
@@ -50,11 +95,51 @@ func (fs *FnStmt) Accept(v Visitor) any {
 }
 
 func (fs *FnStmt) String() string {
-	return fmt.Sprintf("fn %s (%s) %s", fs.Ident, strings.Join(fs.Params, ", "), fs.Body)
+	return fmt.Sprintf("fn %s (%s) %s", fs.Ident, ParamList(fs.Params), fs.Body)
 }
 
 func (*FnStmt) stmtNode() {}
 
+// Param is a parameter of a named function or lambda.
+type Param struct {
+	Name string
+	// Slot is the slot of Name in the scope of the parameters.
+	Slot int
+	// Pattern destructures the argument, as in `fn f([a, b])`. It is nil if
+	// the argument is bound to Name.
+	Pattern Pattern
+	// Default is the value of the parameter when no argument is passed to it,
+	// as in `fn f(a, b = 10)`. It is nil if the argument is required.
+	Default Expr
+	// Variadic reports whether the parameter collects the remaining
+	// positional arguments into a list, as in `fn f(a, ...rest)`.
+	Variadic bool
+}
+
+func (pm *Param) String() string {
+	s := pm.Name
+	if pm.Pattern != nil {
+		s = pm.Pattern.String()
+	}
+	if pm.Variadic {
+		s = "..." + s
+	}
+	if pm.Default != nil {
+		s += " = " + pm.Default.String()
+	}
+	return s
+}
+
+type ParamList []*Param
+
+func (pl ParamList) String() string {
+	ss := make([]string, 0, len(pl))
+	for _, pm := range pl {
+		ss = append(ss, pm.String())
+	}
+	return strings.Join(ss, ", ")
+}
+
 type ReturnStmt struct {
 	RetVal Expr
 }
@@ -69,8 +154,59 @@ func (rs *ReturnStmt) String() string {
 
 func (*ReturnStmt) stmtNode() {}
 
+type ThrowStmt struct {
+	Loc   token.Location
+	Value Expr
+}
+
+func (ts *ThrowStmt) Accept(v Visitor) any {
+	return v.VisitThrowStmt(ts)
+}
+
+func (ts *ThrowStmt) String() string {
+	return fmt.Sprintf("throw %s;", ts.Value)
+}
+
+func (*ThrowStmt) stmtNode() {}
+
+// TryStmt represents `try Body catch Var Catch finally Finally`. Either of
+// Catch and Finally may be nil, but not both. Var is empty if the caught
+// error is not bound. Otherwise it is bound in a scope of its own enclosing
+// Catch, where it has slot 0.
+type TryStmt struct {
+	Body    Stmt
+	Var     string
+	Catch   Stmt
+	Finally Stmt
+}
+
+func (ts *TryStmt) Accept(v Visitor) any {
+	return v.VisitTryStmt(ts)
+}
+
+func (ts *TryStmt) String() string {
+	s := "try " + ts.Body.String()
+	if ts.Catch != nil {
+		s += " catch "
+		if ts.Var != "" {
+			s += ts.Var + " "
+		}
+		s += ts.Catch.String()
+	}
+	if ts.Finally != nil {
+		s += " finally " + ts.Finally.String()
+	}
+	return s
+}
+
+func (*TryStmt) stmtNode() {}
+
 type AssignStmt struct {
+	Loc   token.Location
 	Ident string
+	// Depth and Slot locate the binding assigned to, as in Variable.
+	Depth int
+	Slot  int
 	Expr  Expr
 }
 
@@ -84,6 +220,71 @@ func (as *AssignStmt) String() string {
 
 func (*AssignStmt) stmtNode() {}
 
+// ImportStmt represents `import "path/to/mod" as Alias;`. Alias is empty if
+// omitted. With Names, as in `import {a, b as c} from "path/to/mod";`, it
+// binds the listed members of the module instead of the module itself.
+type ImportStmt struct {
+	Loc   token.Location
+	Path  string
+	Alias string
+	// Slot is the slot the module is bound to if there are no Names.
+	Slot  int
+	Names []*ImportName
+}
+
+// ImportName is a member listed in an import statement.
+type ImportName struct {
+	Loc   token.Location
+	Name  string
+	Alias string
+	Slot  int
+}
+
+// Binding returns the name the member is bound to.
+func (in *ImportName) Binding() string {
+	if in.Alias != "" {
+		return in.Alias
+	}
+	return in.Name
+}
+
+func (in *ImportName) String() string {
+	if in.Alias != "" {
+		return in.Name + " as " + in.Alias
+	}
+	return in.Name
+}
+
+// Name returns the name the module is bound to: its alias, or else the last
+// element of its path without extension.
+func (is *ImportStmt) Name() string {
+	if is.Alias != "" {
+		return is.Alias
+	}
+	base := path.Base(is.Path)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+func (is *ImportStmt) Accept(v Visitor) any {
+	return v.VisitImportStmt(is)
+}
+
+func (is *ImportStmt) String() string {
+	if len(is.Names) > 0 {
+		ss := make([]string, 0, len(is.Names))
+		for _, n := range is.Names {
+			ss = append(ss, n.String())
+		}
+		return fmt.Sprintf("import {%s} from %q;", strings.Join(ss, ", "), is.Path)
+	}
+	if is.Alias != "" {
+		return fmt.Sprintf("import %q as %s;", is.Path, is.Alias)
+	}
+	return fmt.Sprintf("import %q;", is.Path)
+}
+
+func (*ImportStmt) stmtNode() {}
+
 type IfElseStmt struct {
 	Cond Expr
 	Then Stmt
@@ -102,8 +303,11 @@ func (ie *IfElseStmt) stmtNode() {}
 func (ie *IfElseStmt) exprNode() {}
 
 type WhileStmt struct {
-	Cond Expr
-	Body Stmt
+	// Label is the optional name given by `label: while ...`, used by
+	// labelled break and continue statements.
+	Label string
+	Cond  Expr
+	Body  Stmt
 }
 
 func (ws *WhileStmt) Accept(v Visitor) any {
@@ -111,11 +315,72 @@ func (ws *WhileStmt) Accept(v Visitor) any {
 }
 
 func (ws *WhileStmt) String() string {
-	return fmt.Sprintf("while %s %s", ws.Cond, ws.Body)
+	return labelPrefix(ws.Label) + fmt.Sprintf("while %s %s", ws.Cond, ws.Body)
 }
 
 func (ws *WhileStmt) stmtNode() {}
 
+// ForStmt represents `for Var in Iter Body`. Every iteration binds Var in a
+// scope of its own, where it has slot 0.
+type ForStmt struct {
+	Label string
+	Var   string
+	Iter  Expr
+	Body  Stmt
+}
+
+func (fs *ForStmt) Accept(v Visitor) any {
+	return v.VisitForStmt(fs)
+}
+
+func (fs *ForStmt) String() string {
+	return labelPrefix(fs.Label) + fmt.Sprintf("for %s in %s %s", fs.Var, fs.Iter, fs.Body)
+}
+
+func (*ForStmt) stmtNode() {}
+
+type BreakStmt struct {
+	Label string
+}
+
+func (bs *BreakStmt) Accept(v Visitor) any {
+	return v.VisitBreakStmt(bs)
+}
+
+func (bs *BreakStmt) String() string {
+	return "break" + labelSuffix(bs.Label) + ";"
+}
+
+func (*BreakStmt) stmtNode() {}
+
+type ContinueStmt struct {
+	Label string
+}
+
+func (cs *ContinueStmt) Accept(v Visitor) any {
+	return v.VisitContinueStmt(cs)
+}
+
+func (cs *ContinueStmt) String() string {
+	return "continue" + labelSuffix(cs.Label) + ";"
+}
+
+func (*ContinueStmt) stmtNode() {}
+
+func labelPrefix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return label + ": "
+}
+
+func labelSuffix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return " " + label
+}
+
 type ExprStmt struct {
 	Expr Expr
 }
@@ -15,15 +15,27 @@ type Visitor interface {
 	VisitBinaryExpr(expr *BinaryExpr) any
 	VisitUnaryExpr(expr *UnaryExpr) any
 	VisitGroupingExpr(expr *GroupingExpr) any
+	VisitRangeExpr(expr *RangeExpr) any
+	VisitListExpr(expr *ListExpr) any
+	VisitMapExpr(expr *MapExpr) any
+	VisitMatchExpr(expr *MatchExpr) any
 	VisitCallExpr(expr *CallExpr) any
+	VisitMemberExpr(expr *MemberExpr) any
+	VisitPropagateExpr(expr *PropagateExpr) any
 	VisitLambda(expr *Lambda) any
 
 	VisitLetStmt(stmt *LetStmt) any
 	VisitAssignStmt(stmt *AssignStmt) any
+	VisitImportStmt(stmt *ImportStmt) any
 	VisitIfElseStmt(stmt *IfElseStmt) any
 	VisitWhileStmt(stmt *WhileStmt) any
+	VisitForStmt(stmt *ForStmt) any
+	VisitBreakStmt(stmt *BreakStmt) any
+	VisitContinueStmt(stmt *ContinueStmt) any
 	VisitFnStmt(stmt *FnStmt) any
 	VisitReturnStmt(stmt *ReturnStmt) any
+	VisitThrowStmt(stmt *ThrowStmt) any
+	VisitTryStmt(stmt *TryStmt) any
 	VisitExprStmt(stmt *ExprStmt) any
 	VisitEmptyStmt(stmt *EmptyStmt) any
 
@@ -0,0 +1,834 @@
+// Package compiler lowers resolved Naive programs to bytecode, which package
+// vm runs.
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+
+	"naive/ast"
+	"naive/token"
+)
+
+var _ ast.Visitor = (*Compiler)(nil)
+
+// Compiler compiles the statements of a file. It follows the scopes of the
+// resolver: the top-level bindings of the file are globals, whose slots are
+// the ones the resolver gave them, and every other scope gets a range of the
+// locals of its function. A function refers to the locals of enclosing
+// functions through upvalues.
+//
+// Expressions leave their value on the operand stack. So do blocks, if-else
+// and match statements, which are expressions as well. Other statements leave
+// the stack as they found it.
+type Compiler struct {
+	file   string
+	fn     *function
+	scopes []*scope
+}
+
+// function holds the state of the compilation of a function.
+type function struct {
+	proto  *Proto
+	parent *function
+	// locals is the number of locals in use, and depth the height of the
+	// operand stack.
+	locals int
+	depth  int
+	loops  []*loop
+	// tries holds the try statements whose body or catch block encloses the
+	// code being compiled, outermost first.
+	tries  []*try
+	consts map[any]int
+}
+
+// scope is a scope of the resolver.
+type scope struct {
+	// fn is the function of the scope, nil for the top-level scope of the
+	// file, whose bindings are globals.
+	fn *function
+	// slots[j] is the local of slot j.
+	slots []int
+	base  int
+	// captured reports whether a function refers to a local of the scope,
+	// which must then be closed when the scope ends.
+	captured bool
+}
+
+type loop struct {
+	label string
+	// locals and depth are those of the function at the start of the body.
+	locals, depth int
+	tries         int
+	breaks, conts []int
+}
+
+// try is an error handler of a try statement. finally is the statement whose
+// finally block the handler runs, or nil if the handler runs a catch block.
+type try struct {
+	finally *ast.TryStmt
+	scopes  int
+}
+
+// Compile compiles stmts, the resolved top-level statements of file, into a
+// Proto named Main.
+func Compile(file string, stmts []ast.Stmt) *Proto {
+	c := &Compiler{file: file}
+	c.fn = c.newFunction(Main)
+	c.scopes = []*scope{{}}
+	c.statements(stmts)
+	c.emit(OpNil)
+	c.emit(OpReturn)
+	return c.fn.proto
+}
+
+func (c *Compiler) newFunction(name string) *function {
+	return &function{
+		proto:  &Proto{Name: name, File: c.file},
+		parent: c.fn,
+		consts: make(map[any]int),
+	}
+}
+
+// emit appends an instruction and returns its position.
+func (c *Compiler) emit(op Op, operands ...int) int {
+	p := c.fn.proto
+	pc := len(p.Code)
+	p.Code = append(p.Code, byte(op))
+	for _, x := range operands {
+		if x < 0 || x > 0xffff {
+			panic(fmt.Sprintf("%s: too large to compile", p.Name))
+		}
+		p.Code = append(p.Code, byte(x>>8), byte(x))
+	}
+	c.fn.depth += effect(op, operands)
+	if c.fn.depth > p.MaxStack {
+		p.MaxStack = c.fn.depth
+	}
+	return pc
+}
+
+// mark records loc as the position of the next instruction.
+func (c *Compiler) mark(loc token.Location) {
+	if loc.Line == 0 {
+		return
+	}
+	p := c.fn.proto
+	pc := len(p.Code)
+	if n := len(p.Lines); n > 0 && p.Lines[n-1].PC == pc {
+		p.Lines = p.Lines[:n-1]
+	}
+	p.Lines = append(p.Lines, Line{PC: pc, Line: loc.Line, Column: loc.Column})
+}
+
+// here returns the position of the next instruction.
+func (c *Compiler) here() int {
+	return len(c.fn.proto.Code)
+}
+
+// patch makes the k-th operand of the instruction at pc the position of the
+// next instruction.
+func (c *Compiler) patch(pc, k int) {
+	to := c.here()
+	code := c.fn.proto.Code
+	code[pc+1+2*k], code[pc+2+2*k] = byte(to>>8), byte(to)
+}
+
+// constant returns the index of v in the constants, adding it if needed.
+func (c *Compiler) constant(v any) int {
+	var key any
+	switch v := v.(type) {
+	case string, rune:
+		key = v
+	case *big.Int:
+		key = [2]string{"int", v.String()}
+	case *big.Float:
+		key = [2]string{"float", v.Text('g', -1)}
+	}
+	if key != nil {
+		if k, ok := c.fn.consts[key]; ok {
+			return k
+		}
+	}
+	p := c.fn.proto
+	p.Consts = append(p.Consts, v)
+	if key != nil {
+		c.fn.consts[key] = len(p.Consts) - 1
+	}
+	return len(p.Consts) - 1
+}
+
+// local allocates a local of the current function.
+func (c *Compiler) local() int {
+	j := c.fn.locals
+	c.fn.locals++
+	if c.fn.locals > c.fn.proto.NumLocals {
+		c.fn.proto.NumLocals = c.fn.locals
+	}
+	return j
+}
+
+// begin begins a scope of n slots, which become undefined if clear is set.
+func (c *Compiler) begin(n int, clear bool) *scope {
+	sc := &scope{fn: c.fn, base: c.fn.locals}
+	for j := 0; j < n; j++ {
+		sc.slots = append(sc.slots, c.local())
+	}
+	if clear && n > 0 {
+		c.emit(OpClear, sc.base, n)
+	}
+	c.scopes = append(c.scopes, sc)
+	return sc
+}
+
+// end ends the innermost scope.
+func (c *Compiler) end() {
+	sc := c.scopes[len(c.scopes)-1]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	if sc.captured {
+		c.emit(OpClose, sc.base)
+	}
+	c.fn.locals = sc.base
+}
+
+// declarations returns the number of slots the declarations of stmts take
+// in their scope.
+func declarations(stmts []ast.Stmt) int {
+	n := 0
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStmt:
+			n += len(s.Names())
+		case *ast.FnStmt:
+			n++
+		case *ast.ImportStmt:
+			if len(s.Names) == 0 {
+				n++
+			}
+			n += len(s.Names)
+		}
+	}
+	return n
+}
+
+func (c *Compiler) statements(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		c.statement(stmt)
+	}
+}
+
+// statement compiles stmt, dropping its value if it has one.
+func (c *Compiler) statement(stmt ast.Stmt) {
+	stmt.Accept(c)
+	switch stmt.(type) {
+	case *ast.Block, *ast.IfElseStmt, *ast.MatchExpr:
+		c.emit(OpPop)
+	}
+}
+
+// value compiles n, a statement or expression, leaving its value.
+func (c *Compiler) value(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.EmptyStmt:
+		c.emit(OpNil)
+	case ast.Expr:
+		n.Accept(c)
+	case ast.Stmt:
+		c.statement(n)
+		c.emit(OpNil)
+	}
+}
+
+// variable returns where the binding at depth and slot is: the global j, the
+// upvalue j or the local j of the current function.
+func (c *Compiler) variable(depth, slot int, name string) (global, upval bool, j int) {
+	sc := c.scopes[len(c.scopes)-1-depth]
+	if sc.fn == nil {
+		return true, false, slot
+	}
+	if sc.fn == c.fn {
+		return false, false, sc.slots[slot]
+	}
+	sc.captured = true
+	return false, true, c.upvalue(c.fn, sc.fn, sc.slots[slot], name)
+}
+
+// upvalue returns the upvalue of fn for the local j of owner, which encloses
+// fn.
+func (c *Compiler) upvalue(fn, owner *function, j int, name string) int {
+	uv := Upval{Local: true, Index: j, Name: name}
+	if fn.parent != owner {
+		uv = Upval{Index: c.upvalue(fn.parent, owner, j, name), Name: name}
+	}
+	for k, x := range fn.proto.Upvals {
+		if x == uv {
+			return k
+		}
+	}
+	fn.proto.Upvals = append(fn.proto.Upvals, uv)
+	return len(fn.proto.Upvals) - 1
+}
+
+// define binds slot of the current scope to the value on the stack.
+func (c *Compiler) define(slot int, name string) {
+	sc := c.scopes[len(c.scopes)-1]
+	if sc.fn == nil {
+		c.emit(OpDefineGlobal, slot, c.constant(name))
+	} else {
+		c.emit(OpSetLocal, sc.slots[slot])
+	}
+}
+
+// destructure binds the variables of pat, in the current scope, to the value
+// on the stack. arg is 0 for a let statement and j+1 for the j-th parameter.
+func (c *Compiler) destructure(pat ast.Pattern, arg int) {
+	sc := c.scopes[len(c.scopes)-1]
+	if sc.fn == nil {
+		c.emit(OpDestructureGlobal, c.constant(pat))
+	} else {
+		c.emit(OpDestructure, c.constant(pat), c.constant(sc.slots), arg)
+	}
+}
+
+// unwind leaves the try statements from the innermost one to the to-th,
+// running their finally blocks.
+func (c *Compiler) unwind(to int) {
+	fn := c.fn
+	tries, scopes := fn.tries, c.scopes
+	for k := len(tries) - 1; k >= to; k-- {
+		c.emit(OpEndTry)
+		if t := tries[k]; t.finally != nil {
+			fn.tries, c.scopes = tries[:k], scopes[:t.scopes]
+			c.statement(t.finally.Finally)
+		}
+	}
+	fn.tries, c.scopes = tries, scopes
+}
+
+// function compiles a function and leaves a closure of it.
+func (c *Compiler) function(name string, params []*ast.Param, body ast.Stmt) {
+	fn := c.newFunction(name)
+	c.fn = fn
+	p := fn.proto
+
+	// The arguments are the first locals, and the variables bound by patterns
+	// follow.
+	sc := &scope{fn: fn}
+	for range params {
+		c.local()
+	}
+	setSlot := func(slot, j int) {
+		for len(sc.slots) <= slot {
+			sc.slots = append(sc.slots, 0)
+		}
+		sc.slots[slot] = j
+	}
+	for j, pm := range params {
+		param := Param{Variadic: pm.Variadic, HasDefault: pm.Default != nil, Text: pm.String()}
+		if pm.Pattern == nil {
+			param.Name = pm.Name
+			setSlot(pm.Slot, j)
+		} else {
+			for _, slot := range ast.Slots(pm.Pattern) {
+				setSlot(slot, c.local())
+			}
+		}
+		p.Params = append(p.Params, param)
+	}
+	c.scopes = append(c.scopes, sc)
+	checks := p.ChecksArgs()
+
+	for j, pm := range params {
+		if pm.Default != nil {
+			skip := c.emit(OpDefault, j, 0)
+			pm.Default.Accept(c)
+			c.emit(OpSetLocal, j)
+			c.patch(skip, 1)
+		} else if checks {
+			msg := fmt.Sprintf("function %s: missing argument for parameter %s", name, pm)
+			c.emit(OpRequire, j, c.constant(msg))
+		}
+		if pm.Pattern != nil {
+			c.emit(OpGetLocal, j)
+			c.destructure(pm.Pattern, j+1)
+		}
+	}
+	c.value(body)
+	c.emit(OpReturn)
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.fn = fn.parent
+	c.emit(OpClosure, c.constant(p))
+}
+
+func (c *Compiler) VisitIntegerValue(expr ast.IntegerValue) any {
+	c.emit(OpConst, c.constant(expr.Value))
+	return nil
+}
+
+func (c *Compiler) VisitFloatValue(expr ast.FloatValue) any {
+	c.emit(OpConst, c.constant(expr.Value))
+	return nil
+}
+
+func (c *Compiler) VisitStringValue(expr ast.StringValue) any {
+	c.emit(OpConst, c.constant(expr.Value))
+	return nil
+}
+
+func (c *Compiler) VisitCharValue(expr ast.CharValue) any {
+	c.emit(OpConst, c.constant(expr.Value))
+	return nil
+}
+
+func (c *Compiler) VisitTrue(ast.True) any {
+	c.emit(OpTrue)
+	return nil
+}
+
+func (c *Compiler) VisitFalse(ast.False) any {
+	c.emit(OpFalse)
+	return nil
+}
+
+func (c *Compiler) VisitNil(ast.Nil) any {
+	c.emit(OpNil)
+	return nil
+}
+
+func (c *Compiler) VisitVariable(expr *ast.Variable) any {
+	if expr.Depth < 0 {
+		c.emit(OpGetBuiltin, c.constant(expr.Ident))
+		return nil
+	}
+	global, upval, j := c.variable(expr.Depth, expr.Slot, expr.Ident)
+	switch {
+	case global:
+		c.mark(expr.Loc)
+		c.emit(OpGetGlobal, j, c.constant(expr.Ident))
+	case upval:
+		c.mark(expr.Loc)
+		c.emit(OpGetUpval, j)
+	default:
+		c.emit(OpGetLocal, j)
+	}
+	return nil
+}
+
+var binaryOps = map[token.Kind]Op{
+	token.KindAdd: OpAdd,
+	token.KindSub: OpSub,
+	token.KindMul: OpMul,
+	token.KindDiv: OpDiv,
+	token.KindMod: OpMod,
+	token.KindEq:  OpEq,
+	token.KindNe:  OpNe,
+	token.KindGt:  OpGt,
+	token.KindGe:  OpGe,
+	token.KindLt:  OpLt,
+	token.KindLe:  OpLe,
+	token.KindAnd: OpAnd,
+	token.KindOr:  OpOr,
+}
+
+func (c *Compiler) VisitBinaryExpr(expr *ast.BinaryExpr) any {
+	expr.Lhs.Accept(c)
+	expr.Rhs.Accept(c)
+	op, ok := binaryOps[expr.Op]
+	if !ok {
+		panic("unreachable")
+	}
+	c.mark(expr.Loc)
+	c.emit(op)
+	return nil
+}
+
+func (c *Compiler) VisitUnaryExpr(expr *ast.UnaryExpr) any {
+	expr.X.Accept(c)
+	c.mark(expr.Loc)
+	switch expr.Op {
+	case token.KindSub:
+		c.emit(OpNeg)
+	case token.KindNot:
+		c.emit(OpNot)
+	default:
+		panic("unreachable")
+	}
+	return nil
+}
+
+func (c *Compiler) VisitGroupingExpr(expr *ast.GroupingExpr) any {
+	return expr.Expr.Accept(c)
+}
+
+func (c *Compiler) VisitRangeExpr(expr *ast.RangeExpr) any {
+	expr.Start.Accept(c)
+	expr.End.Accept(c)
+	inclusive := 0
+	if expr.Inclusive {
+		inclusive = 1
+	}
+	c.mark(expr.Loc)
+	c.emit(OpRange, inclusive)
+	if expr.Step != nil {
+		expr.Step.Accept(c)
+		c.mark(expr.Loc)
+		c.emit(OpRangeStep)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitListExpr(expr *ast.ListExpr) any {
+	for _, e := range expr.Elems {
+		e.Accept(c)
+	}
+	c.emit(OpList, len(expr.Elems))
+	return nil
+}
+
+func (c *Compiler) VisitMapExpr(expr *ast.MapExpr) any {
+	c.emit(OpMap)
+	for j := range expr.Keys {
+		expr.Keys[j].Accept(c)
+		expr.Values[j].Accept(c)
+		c.emit(OpMapSet)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitMatchExpr(expr *ast.MatchExpr) any {
+	expr.Value.Accept(c)
+	v := c.local()
+	c.emit(OpSetLocal, v)
+	depth := c.fn.depth
+	var ends []int
+	for _, arm := range expr.Arms {
+		sc := c.begin(len(ast.Names(arm.Pattern)), true)
+		c.emit(OpGetLocal, v)
+		fail := c.emit(OpMatch, c.constant(arm.Pattern), c.constant(sc.slots), 0)
+		guard := -1
+		if arm.Guard != nil {
+			arm.Guard.Accept(c)
+			guard = c.emit(OpJumpIfFalse, 0)
+		}
+		arm.Body.Accept(c)
+		c.end()
+		ends = append(ends, c.emit(OpJump, 0))
+		c.fn.depth = depth
+		c.patch(fail, 2)
+		if guard >= 0 {
+			c.patch(guard, 0)
+		}
+		if sc.captured {
+			c.emit(OpClose, sc.base)
+		}
+	}
+	c.emit(OpGetLocal, v)
+	c.emit(OpNoMatch)
+	for _, pc := range ends {
+		c.patch(pc, 0)
+	}
+	c.fn.depth = depth + 1
+	c.fn.locals--
+	return nil
+}
+
+func (c *Compiler) VisitCallExpr(expr *ast.CallExpr) any {
+	for _, a := range expr.Args {
+		a.Accept(c)
+	}
+	expr.Callee.Accept(c)
+	c.mark(expr.Loc)
+	n := len(expr.Args)
+	switch {
+	case expr.ArgNames == nil && !expr.Tail:
+		c.emit(OpCall, n)
+	case expr.ArgNames == nil:
+		c.emit(OpTailCall, n)
+	default:
+		op := OpCallNamed
+		if expr.Tail {
+			op = OpTailCallNamed
+		}
+		c.emit(op, n, c.constant(expr.ArgNames), c.constant(ast.CalleeName(expr.Callee)))
+	}
+	return nil
+}
+
+func (c *Compiler) VisitMemberExpr(expr *ast.MemberExpr) any {
+	expr.X.Accept(c)
+	c.mark(expr.Loc)
+	c.emit(OpMember, c.constant(expr.Name))
+	return nil
+}
+
+func (c *Compiler) VisitPropagateExpr(expr *ast.PropagateExpr) any {
+	expr.X.Accept(c)
+	ok := c.emit(OpPropagate, 0)
+	c.unwind(0)
+	c.emit(OpReturn)
+	c.fn.depth++
+	c.patch(ok, 0)
+	return nil
+}
+
+func (c *Compiler) VisitLambda(expr *ast.Lambda) any {
+	c.function("<anonymous>", expr.Params, expr.Body)
+	return nil
+}
+
+func (c *Compiler) VisitLetStmt(stmt *ast.LetStmt) any {
+	stmt.Init.Accept(c)
+	c.mark(stmt.Loc)
+	if stmt.Pattern == nil {
+		c.define(stmt.Slot, stmt.Ident)
+	} else {
+		c.destructure(stmt.Pattern, 0)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitAssignStmt(stmt *ast.AssignStmt) any {
+	stmt.Expr.Accept(c)
+	c.mark(stmt.Loc)
+	global, upval, j := c.variable(stmt.Depth, stmt.Slot, stmt.Ident)
+	switch {
+	case global:
+		c.emit(OpSetGlobal, j, c.constant(stmt.Ident))
+	case upval:
+		c.emit(OpSetUpval, j)
+	default:
+		c.emit(OpSetLocal, j)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitImportStmt(stmt *ast.ImportStmt) any {
+	c.mark(stmt.Loc)
+	c.emit(OpImport, c.constant(stmt.Path))
+	if len(stmt.Names) == 0 {
+		c.define(stmt.Slot, stmt.Name())
+		return nil
+	}
+	// Check every name before binding any, so that a failed import binds
+	// nothing.
+	for _, n := range stmt.Names {
+		c.mark(n.Loc)
+		c.emit(OpImportCheck, c.constant(n.Name))
+	}
+	for _, n := range stmt.Names {
+		c.emit(OpImportName, c.constant(n.Name))
+		c.define(n.Slot, n.Binding())
+	}
+	c.emit(OpPop)
+	return nil
+}
+
+func (c *Compiler) VisitIfElseStmt(stmt *ast.IfElseStmt) any {
+	stmt.Cond.Accept(c)
+	otherwise := c.emit(OpJumpIfFalse, 0)
+	c.value(stmt.Then)
+	end := c.emit(OpJump, 0)
+	c.fn.depth--
+	c.patch(otherwise, 0)
+	c.value(stmt.Else)
+	c.patch(end, 0)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt *ast.WhileStmt) any {
+	start := c.here()
+	stmt.Cond.Accept(c)
+	exit := c.emit(OpJumpIfFalse, 0)
+	l := c.beginLoop(stmt.Label)
+	c.statement(stmt.Body)
+	for _, pc := range l.conts {
+		c.patch(pc, 0)
+	}
+	c.emit(OpJump, start)
+	c.patch(exit, 0)
+	c.endLoop()
+	return nil
+}
+
+func (c *Compiler) VisitForStmt(stmt *ast.ForStmt) any {
+	stmt.Iter.Accept(c)
+	c.emit(OpIter)
+	it := c.local()
+	c.emit(OpSetLocal, it)
+	l := c.beginLoop(stmt.Label)
+	start := c.emit(OpForIter, it, 0)
+	// Every iteration binds the variable in a scope of its own.
+	sc := c.begin(1, false)
+	c.emit(OpSetLocal, sc.slots[0])
+	c.statement(stmt.Body)
+	for _, pc := range l.conts {
+		c.patch(pc, 0)
+	}
+	c.end()
+	c.emit(OpJump, start)
+	c.patch(start, 1)
+	c.endLoop()
+	c.fn.locals--
+	return nil
+}
+
+func (c *Compiler) beginLoop(label string) *loop {
+	fn := c.fn
+	l := &loop{label: label, locals: fn.locals, depth: fn.depth, tries: len(fn.tries)}
+	fn.loops = append(fn.loops, l)
+	return l
+}
+
+// endLoop ends the innermost loop, whose exit is the next instruction.
+func (c *Compiler) endLoop() {
+	fn := c.fn
+	l := fn.loops[len(fn.loops)-1]
+	fn.loops = fn.loops[:len(fn.loops)-1]
+	for _, pc := range l.breaks {
+		c.patch(pc, 0)
+	}
+}
+
+// jump compiles a break or continue to the loop labelled label.
+func (c *Compiler) jump(label string, isBreak bool) {
+	fn := c.fn
+	var l *loop
+	for k := len(fn.loops) - 1; k >= 0; k-- {
+		if label == "" || fn.loops[k].label == label {
+			l = fn.loops[k]
+			break
+		}
+	}
+	depth := fn.depth
+	for fn.depth > l.depth {
+		c.emit(OpPop)
+	}
+	c.unwind(l.tries)
+	if fn.locals > l.locals {
+		c.emit(OpClose, l.locals)
+	}
+	pc := c.emit(OpJump, 0)
+	if isBreak {
+		l.breaks = append(l.breaks, pc)
+	} else {
+		l.conts = append(l.conts, pc)
+	}
+	fn.depth = depth
+}
+
+func (c *Compiler) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	c.jump(stmt.Label, true)
+	return nil
+}
+
+func (c *Compiler) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	c.jump(stmt.Label, false)
+	return nil
+}
+
+func (c *Compiler) VisitFnStmt(stmt *ast.FnStmt) any {
+	c.mark(stmt.Loc)
+	c.function(stmt.Ident, stmt.Params, stmt.Body)
+	c.define(stmt.Slot, stmt.Ident)
+	return nil
+}
+
+func (c *Compiler) VisitReturnStmt(stmt *ast.ReturnStmt) any {
+	stmt.RetVal.Accept(c)
+	c.unwind(0)
+	c.emit(OpReturn)
+	return nil
+}
+
+func (c *Compiler) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	stmt.Value.Accept(c)
+	c.mark(stmt.Loc)
+	c.emit(OpThrow)
+	return nil
+}
+
+// VisitTryStmt compiles the finally block of stmt at every exit from the rest:
+// after it, at the breaks, continues and returns leaving it, and in a handler
+// that throws again the errors it does not catch.
+func (c *Compiler) VisitTryStmt(stmt *ast.TryStmt) any {
+	if stmt.Finally == nil {
+		c.tryCatch(stmt)
+		return nil
+	}
+	fn := c.fn
+	handler := c.emit(OpTry, 0, fn.locals)
+	fn.tries = append(fn.tries, &try{finally: stmt, scopes: len(c.scopes)})
+	c.tryCatch(stmt)
+	fn.tries = fn.tries[:len(fn.tries)-1]
+	c.emit(OpEndTry)
+	c.statement(stmt.Finally)
+	end := c.emit(OpJump, 0)
+
+	c.patch(handler, 0)
+	fn.depth++
+	e := c.local()
+	c.emit(OpSetLocal, e)
+	c.statement(stmt.Finally)
+	c.emit(OpGetLocal, e)
+	c.emit(OpThrow)
+	fn.locals--
+	c.patch(end, 0)
+	return nil
+}
+
+// tryCatch compiles the body of stmt, and its catch block if any.
+func (c *Compiler) tryCatch(stmt *ast.TryStmt) {
+	if stmt.Catch == nil {
+		c.statement(stmt.Body)
+		return
+	}
+	fn := c.fn
+	handler := c.emit(OpTry, 0, fn.locals)
+	fn.tries = append(fn.tries, &try{scopes: len(c.scopes)})
+	c.statement(stmt.Body)
+	fn.tries = fn.tries[:len(fn.tries)-1]
+	c.emit(OpEndTry)
+	end := c.emit(OpJump, 0)
+
+	c.patch(handler, 0)
+	fn.depth++
+	if stmt.Var != "" {
+		sc := c.begin(1, false)
+		c.emit(OpSetLocal, sc.slots[0])
+	} else {
+		c.begin(0, false)
+		c.emit(OpPop)
+	}
+	c.statement(stmt.Catch)
+	c.end()
+	c.patch(end, 0)
+}
+
+func (c *Compiler) VisitExprStmt(stmt *ast.ExprStmt) any {
+	stmt.Expr.Accept(c)
+	c.emit(OpPop)
+	return nil
+}
+
+func (c *Compiler) VisitEmptyStmt(*ast.EmptyStmt) any {
+	return nil
+}
+
+func (c *Compiler) VisitBlock(blk *ast.Block) any {
+	// Blocks that declare nothing have no scope, as in the resolver.
+	n := declarations(blk.Statements)
+	if n > 0 {
+		c.begin(n, true)
+	}
+	c.statements(blk.Statements)
+	if blk.Value != nil {
+		blk.Value.Accept(c)
+	} else {
+		c.emit(OpNil)
+	}
+	if n > 0 {
+		c.end()
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a listing of p and of the functions defined in it to w.
+// For each function it lists the constants, then the instructions with their
+// positions in the source, their operands and what these refer to. Jump
+// targets are marked with ">>".
+func Disassemble(w io.Writer, p *Proto) {
+	protos := []*Proto{p}
+	for len(protos) > 0 {
+		p, protos = protos[0], protos[1:]
+		disassemble(w, p)
+		for _, c := range p.Consts {
+			if q, ok := c.(*Proto); ok {
+				protos = append(protos, q)
+			}
+		}
+	}
+}
+
+func disassemble(w io.Writer, p *Proto) {
+	fmt.Fprintf(w, "== %s (%s) ==\n", p.Name, p.File)
+	if len(p.Params) > 0 {
+		params := make([]string, len(p.Params))
+		for j, pm := range p.Params {
+			params[j] = pm.Text
+		}
+		fmt.Fprintf(w, "params: %s\n", strings.Join(params, ", "))
+	}
+	fmt.Fprintf(w, "locals: %d, stack: %d\n", p.NumLocals, p.MaxStack)
+	if len(p.Upvals) > 0 {
+		fmt.Fprintln(w, "upvalues:")
+		for j, uv := range p.Upvals {
+			where := "upvalue"
+			if uv.Local {
+				where = "local"
+			}
+			fmt.Fprintf(w, "  %4d  %s (%s %d)\n", j, uv.Name, where, uv.Index)
+		}
+	}
+	if len(p.Consts) > 0 {
+		fmt.Fprintln(w, "constants:")
+		for k, c := range p.Consts {
+			fmt.Fprintf(w, "  %4d  %s\n", k, constant(c))
+		}
+	}
+
+	targets := make(map[int]bool)
+	for pc := 0; pc < len(p.Code); pc = next(p, pc) {
+		if t, ok := target(p, pc); ok {
+			targets[t] = true
+		}
+	}
+	fmt.Fprintln(w, "code:")
+	for pc := 0; pc < len(p.Code); pc = next(p, pc) {
+		pos := ""
+		if loc := p.Loc(pc); loc.Line != 0 {
+			pos = fmt.Sprintf("%d:%d", loc.Line, loc.Column)
+		}
+		mark := ""
+		if targets[pc] {
+			mark = ">>"
+		}
+		fmt.Fprintf(w, "%8s %2s %5d  %s\n", pos, mark, pc, strings.TrimRight(instruction(p, pc), " "))
+	}
+	fmt.Fprintln(w)
+}
+
+// next returns the position of the instruction after the one at pc.
+func next(p *Proto, pc int) int {
+	op := Op(p.Code[pc])
+	if int(op) >= len(Infos) {
+		return pc + 1
+	}
+	return pc + 1 + 2*Infos[op].Operands
+}
+
+// target returns the position the instruction at pc may jump to, if any.
+func target(p *Proto, pc int) (int, bool) {
+	switch Op(p.Code[pc]) {
+	case OpJump, OpJumpIfFalse, OpPropagate, OpTry:
+		return p.Operand(pc, 0), true
+	case OpDefault, OpForIter:
+		return p.Operand(pc, 1), true
+	case OpMatch:
+		return p.Operand(pc, 2), true
+	default:
+		return 0, false
+	}
+}
+
+// instruction returns the text of the instruction at pc: its Op, operands
+// and a comment on them.
+func instruction(p *Proto, pc int) string {
+	op := Op(p.Code[pc])
+	if int(op) >= len(Infos) {
+		return fmt.Sprintf("UNKNOWN %d", op)
+	}
+	operands := make([]string, Infos[op].Operands)
+	for k := range operands {
+		operands[k] = strconv.Itoa(p.Operand(pc, k))
+	}
+	c := func(k int) string {
+		return constant(p.Consts[p.Operand(pc, k)])
+	}
+
+	var comment string
+	switch op {
+	case OpConst, OpGetBuiltin, OpMember, OpImport, OpImportCheck, OpImportName, OpClosure:
+		comment = c(0)
+	case OpGetGlobal, OpSetGlobal, OpDefineGlobal:
+		comment = c(1)
+	case OpGetUpval, OpSetUpval:
+		comment = p.Upvals[p.Operand(pc, 0)].Name
+	case OpCallNamed, OpTailCallNamed:
+		comment = c(2) + " with " + c(1)
+	case OpRequire:
+		comment = c(1)
+	case OpDestructure, OpMatch:
+		comment = c(0) + " into " + c(1)
+	case OpDestructureGlobal:
+		comment = c(0)
+	}
+	if t, ok := target(p, pc); ok {
+		if comment != "" {
+			comment += ", "
+		}
+		comment += "-> " + strconv.Itoa(t)
+	}
+	if comment != "" {
+		comment = "; " + comment
+	}
+	return fmt.Sprintf("%-18s %-12s %s", op, strings.Join(operands, " "), comment)
+}
+
+// constant returns the text of a constant of a Proto.
+func constant(c any) string {
+	switch c := c.(type) {
+	case *big.Int:
+		return c.String()
+	case *big.Float:
+		return c.Text('g', -1)
+	case string:
+		return strconv.Quote(c)
+	case rune:
+		return strconv.QuoteRune(c)
+	case *Proto:
+		return "<fn " + c.Name + ">"
+	case []string:
+		return fmt.Sprintf("names %q", c)
+	case []int:
+		return fmt.Sprintf("locals %v", c)
+	default:
+		return fmt.Sprint(c)
+	}
+}
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/parser"
+	"naive/resolver"
+	"naive/token"
+)
+
+func compile(src string) *Proto {
+	p := parser.New(token.NewFile("main.nv"), []byte(src))
+	p.Parse()
+	resolver.New(func(name string) bool { return name == "println" }).Resolve(p.Statements)
+	return Compile("main.nv", p.Statements)
+}
+
+func TestDisassemble(t *testing.T) {
+	Convey("Disassemble lists every function", t, func() {
+		var b strings.Builder
+		Disassemble(&b, compile(`fn add(x, y = 1) { fn() -> x + y }
+let n = 2;
+while n > 0 { n = n - 1; }
+println(add(n)());`))
+		out := b.String()
+
+		Convey("with its header and constants", func() {
+			So(out, ShouldContainSubstring, "== <main> (main.nv) ==\n")
+			So(out, ShouldContainSubstring, "== add (main.nv) ==\nparams: x, y = 1\n")
+			So(out, ShouldContainSubstring, "== <anonymous> (main.nv) ==\n")
+			So(out, ShouldContainSubstring, "constants:\n     0  <fn add>\n     1  \"add\"\n     2  2\n")
+			So(out, ShouldContainSubstring, "upvalues:\n     0  x (local 0)\n     1  y (local 1)\n")
+		})
+
+		Convey("with the operands, jump targets and positions of instructions", func() {
+			So(out, ShouldContainSubstring, "     1:1        0  CLOSURE            0            ; <fn add>\n")
+			So(out, ShouldContainSubstring, "     3:7 >>    16  GET_GLOBAL         1 3          ; \"n\"\n")
+			So(out, ShouldContainSubstring, "JUMP_IF_FALSE      ")
+			So(out, ShouldContainSubstring, "    3:21       36  SUB\n")
+			So(out, ShouldContainSubstring, "    3:15       37  SET_GLOBAL         1 3          ; \"n\"\n")
+			So(out, ShouldContainSubstring, "JUMP               16           ; -> 16\n")
+			So(out, ShouldContainSubstring, "DEFAULT            1 16         ; -> 16\n")
+		})
+	})
+}
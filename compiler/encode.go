@@ -0,0 +1,526 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"naive/ast"
+)
+
+// Unit is a compiled file, as stored in a file of compiled code.
+type Unit struct {
+	// Checksum is the SHA-256 checksum of the source of the file, which tells
+	// whether the unit is up to date.
+	Checksum [sha256.Size]byte
+	// Exports holds the names of the public top-level bindings of the file,
+	// for when it is imported as a module.
+	Exports []string
+	// Rewritten reports whether the statements of the file were rewritten
+	// before being compiled, as by package optimize.
+	Rewritten bool
+	// Warnings holds the warnings about the file reported when it was
+	// compiled, to report again whenever the unit is run, and Redeclarations
+	// whether they include those about redeclared names.
+	Warnings       []string
+	Redeclarations bool
+	Main           *Proto
+}
+
+// Magic starts every file of compiled code, and Version is the version of the
+// format written by Encode. Decode rejects other versions.
+const (
+	Magic   = "NVBC"
+	Version = 3
+)
+
+// Checksum returns the checksum of src to store in a Unit.
+func Checksum(src []byte) [sha256.Size]byte {
+	return sha256.Sum256(src)
+}
+
+// The file format is Magic and Version, followed by the fields of the Unit and
+// the SHA-256 checksum of those fields. Integers are varints, strings and lists
+// start with their lengths, and each constant and pattern starts with a tag
+// giving its kind. Protos leave out File, which Decode sets.
+const (
+	tagInt byte = iota
+	tagFloat
+	tagString
+	tagRune
+	tagProto
+	tagPattern
+	tagNames
+	tagSlots
+)
+
+const (
+	tagWildcard byte = iota
+	tagBinding
+	tagLiteral
+	tagRange
+	tagList
+	tagRecord
+)
+
+const (
+	litInt byte = iota
+	litFloat
+	litChar
+	litString
+	litTrue
+	litFalse
+	litNil
+)
+
+// Encode writes u to w.
+func Encode(w io.Writer, u *Unit) error {
+	var b bytes.Buffer
+	e := &encoder{w: bufio.NewWriter(&b)}
+	e.w.Write(u.Checksum[:])
+	e.strings(u.Exports)
+	e.bool(u.Rewritten)
+	e.strings(u.Warnings)
+	e.bool(u.Redeclarations)
+	e.proto(u.Main)
+	if e.err != nil {
+		return e.err
+	}
+	e.w.Flush()
+	sum := sha256.Sum256(b.Bytes())
+
+	e = &encoder{w: bufio.NewWriter(w)}
+	e.w.WriteString(Magic)
+	e.int(Version)
+	e.w.Write(b.Bytes())
+	e.w.Write(sum[:])
+	return e.w.Flush()
+}
+
+// Decode reads a Unit written by Encode from r, the compiled code of file. It
+// checks the code it reads, and fails if the code could not have been
+// written by Encode.
+func Decode(r io.Reader, file string) (u *Unit, err error) {
+	d := &decoder{r: bufio.NewReader(r), file: file}
+	defer func() {
+		if r := recover(); r != nil {
+			de, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			u, err = nil, de.err
+		}
+	}()
+
+	magic := make([]byte, len(Magic))
+	d.read(magic)
+	if string(magic) != Magic {
+		return nil, errors.New("not compiled Naive code")
+	}
+	if v := d.int(); v != Version {
+		return nil, fmt.Errorf("compiled code of version %d, want %d", v, Version)
+	}
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sha256.Size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := data[:len(data)-sha256.Size]
+	if sha256.Sum256(payload) != *(*[sha256.Size]byte)(data[len(payload):]) {
+		return nil, errors.New("corrupt compiled code: checksum mismatch")
+	}
+
+	d.r = bufio.NewReader(bytes.NewReader(payload))
+	u = &Unit{}
+	d.read(u.Checksum[:])
+	u.Exports = d.strings()
+	u.Rewritten = d.bool()
+	u.Warnings = d.strings()
+	u.Redeclarations = d.bool()
+	u.Main = d.proto()
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return nil, errors.New("corrupt compiled code: data after the code")
+	}
+	d.verify(u.Main, nil)
+	return u, nil
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) int(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.w.Write(b[:binary.PutVarint(b[:], int64(n))])
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.w.WriteByte(1)
+	} else {
+		e.w.WriteByte(0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int(len(b))
+	e.w.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.int(len(s))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.int(len(ss))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) proto(p *Proto) {
+	e.string(p.Name)
+	e.int(len(p.Params))
+	for _, pm := range p.Params {
+		e.string(pm.Name)
+		e.bool(pm.Variadic)
+		e.bool(pm.HasDefault)
+		e.string(pm.Text)
+	}
+	e.int(p.NumLocals)
+	e.int(p.MaxStack)
+	e.bytes(p.Code)
+	e.int(len(p.Consts))
+	for _, c := range p.Consts {
+		e.constant(c)
+	}
+	e.int(len(p.Upvals))
+	for _, uv := range p.Upvals {
+		e.bool(uv.Local)
+		e.int(uv.Index)
+		e.string(uv.Name)
+	}
+	e.int(len(p.Lines))
+	for _, l := range p.Lines {
+		e.int(l.PC)
+		e.int(l.Line)
+		e.int(l.Column)
+	}
+}
+
+func (e *encoder) constant(c any) {
+	switch c := c.(type) {
+	case *big.Int:
+		e.w.WriteByte(tagInt)
+		e.bigInt(c)
+	case *big.Float:
+		e.w.WriteByte(tagFloat)
+		e.bigFloat(c)
+	case string:
+		e.w.WriteByte(tagString)
+		e.string(c)
+	case rune:
+		e.w.WriteByte(tagRune)
+		e.int(int(c))
+	case *Proto:
+		e.w.WriteByte(tagProto)
+		e.proto(c)
+	case ast.Pattern:
+		e.w.WriteByte(tagPattern)
+		e.pattern(c)
+	case []string:
+		e.w.WriteByte(tagNames)
+		e.strings(c)
+	case []int:
+		e.w.WriteByte(tagSlots)
+		e.int(len(c))
+		for _, n := range c {
+			e.int(n)
+		}
+	default:
+		e.fail(fmt.Errorf("cannot encode constant %v", c))
+	}
+}
+
+func (e *encoder) bigInt(n *big.Int) {
+	b, err := n.GobEncode()
+	if err != nil {
+		e.fail(err)
+	}
+	e.bytes(b)
+}
+
+func (e *encoder) bigFloat(f *big.Float) {
+	b, err := f.GobEncode()
+	if err != nil {
+		e.fail(err)
+	}
+	e.bytes(b)
+}
+
+func (e *encoder) pattern(pat ast.Pattern) {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		e.w.WriteByte(tagWildcard)
+	case *ast.BindingPattern:
+		e.w.WriteByte(tagBinding)
+		e.string(pat.Ident)
+		e.int(pat.Slot)
+	case *ast.LiteralPattern:
+		e.w.WriteByte(tagLiteral)
+		e.literal(pat.Value)
+	case *ast.RangePattern:
+		e.w.WriteByte(tagRange)
+		e.literal(pat.Start)
+		e.literal(pat.End)
+		e.bool(pat.Inclusive)
+	case *ast.ListPattern:
+		e.w.WriteByte(tagList)
+		e.int(len(pat.Elems))
+		for _, elem := range pat.Elems {
+			e.pattern(elem)
+		}
+		e.bool(pat.HasRest)
+		e.string(pat.Rest)
+		e.int(pat.RestSlot)
+	case *ast.RecordPattern:
+		e.w.WriteByte(tagRecord)
+		e.strings(pat.Keys)
+		for _, v := range pat.Values {
+			e.pattern(v)
+		}
+	default:
+		e.fail(fmt.Errorf("cannot encode pattern %s", pat))
+	}
+}
+
+// literal writes x, a literal of a pattern.
+func (e *encoder) literal(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.IntegerValue:
+		e.w.WriteByte(litInt)
+		e.bigInt(x.Value)
+	case *ast.FloatValue:
+		e.w.WriteByte(litFloat)
+		e.bigFloat(x.Value)
+	case ast.CharValue:
+		e.w.WriteByte(litChar)
+		e.int(int(x.Value))
+	case ast.StringValue:
+		e.w.WriteByte(litString)
+		e.string(x.Value)
+	case ast.True:
+		e.w.WriteByte(litTrue)
+	case ast.False:
+		e.w.WriteByte(litFalse)
+	case ast.Nil:
+		e.w.WriteByte(litNil)
+	default:
+		e.fail(fmt.Errorf("cannot encode literal %s", x))
+	}
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+type decoder struct {
+	r    *bufio.Reader
+	file string
+}
+
+// decodeError carries the errors of a decoder up to Decode.
+type decodeError struct {
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	panic(decodeError{err})
+}
+
+func (d *decoder) read(b []byte) {
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail(err)
+	}
+}
+
+func (d *decoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return b
+}
+
+func (d *decoder) int() int {
+	n, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return int(n)
+}
+
+// len reads the length of a string or list.
+func (d *decoder) len() int {
+	n := d.int()
+	if n < 0 || n > 1<<24 {
+		d.fail(fmt.Errorf("bad length %d", n))
+	}
+	return n
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) bytes() []byte {
+	b := make([]byte, d.len())
+	d.read(b)
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	ss := make([]string, d.len())
+	for j := range ss {
+		ss[j] = d.string()
+	}
+	return ss
+}
+
+func (d *decoder) proto() *Proto {
+	p := &Proto{Name: d.string(), File: d.file}
+	p.Params = make([]Param, d.len())
+	for j := range p.Params {
+		p.Params[j] = Param{Name: d.string(), Variadic: d.bool(), HasDefault: d.bool(), Text: d.string()}
+	}
+	p.NumLocals = d.int()
+	p.MaxStack = d.int()
+	p.Code = d.bytes()
+	p.Consts = make([]any, d.len())
+	for k := range p.Consts {
+		p.Consts[k] = d.constant()
+	}
+	p.Upvals = make([]Upval, d.len())
+	for j := range p.Upvals {
+		p.Upvals[j] = Upval{Local: d.bool(), Index: d.int(), Name: d.string()}
+	}
+	p.Lines = make([]Line, d.len())
+	for j := range p.Lines {
+		p.Lines[j] = Line{PC: d.int(), Line: d.int(), Column: d.int()}
+	}
+	return p
+}
+
+func (d *decoder) constant() any {
+	switch tag := d.byte(); tag {
+	case tagInt:
+		return d.bigInt()
+	case tagFloat:
+		return d.bigFloat()
+	case tagString:
+		return d.string()
+	case tagRune:
+		return rune(d.int())
+	case tagProto:
+		return d.proto()
+	case tagPattern:
+		return d.pattern()
+	case tagNames:
+		return d.strings()
+	case tagSlots:
+		slots := make([]int, d.len())
+		for j := range slots {
+			slots[j] = d.int()
+		}
+		return slots
+	default:
+		d.fail(fmt.Errorf("bad constant tag %d", tag))
+		return nil
+	}
+}
+
+func (d *decoder) bigInt() *big.Int {
+	n := new(big.Int)
+	if err := n.GobDecode(d.bytes()); err != nil {
+		d.fail(err)
+	}
+	return n
+}
+
+func (d *decoder) bigFloat() *big.Float {
+	f := new(big.Float)
+	if err := f.GobDecode(d.bytes()); err != nil {
+		d.fail(err)
+	}
+	return f
+}
+
+func (d *decoder) pattern() ast.Pattern {
+	switch tag := d.byte(); tag {
+	case tagWildcard:
+		return &ast.WildcardPattern{}
+	case tagBinding:
+		return &ast.BindingPattern{Ident: d.string(), Slot: d.int()}
+	case tagLiteral:
+		return &ast.LiteralPattern{Value: d.literal()}
+	case tagRange:
+		return &ast.RangePattern{Start: d.literal(), End: d.literal(), Inclusive: d.bool()}
+	case tagList:
+		lp := &ast.ListPattern{Elems: make([]ast.Pattern, d.len())}
+		for j := range lp.Elems {
+			lp.Elems[j] = d.pattern()
+		}
+		lp.HasRest, lp.Rest, lp.RestSlot = d.bool(), d.string(), d.int()
+		return lp
+	case tagRecord:
+		rp := &ast.RecordPattern{Keys: d.strings()}
+		rp.Values = make([]ast.Pattern, len(rp.Keys))
+		for j := range rp.Values {
+			rp.Values[j] = d.pattern()
+		}
+		return rp
+	default:
+		d.fail(fmt.Errorf("bad pattern tag %d", tag))
+		return nil
+	}
+}
+
+func (d *decoder) literal() ast.Expr {
+	switch tag := d.byte(); tag {
+	case litInt:
+		return &ast.IntegerValue{Value: d.bigInt()}
+	case litFloat:
+		return &ast.FloatValue{Value: d.bigFloat()}
+	case litChar:
+		return ast.CharValue{Value: rune(d.int())}
+	case litString:
+		return ast.StringValue{Value: d.string()}
+	case litTrue:
+		return ast.True{}
+	case litFalse:
+		return ast.False{}
+	case litNil:
+		return ast.Nil{}
+	default:
+		d.fail(fmt.Errorf("bad literal tag %d", tag))
+		return nil
+	}
+}
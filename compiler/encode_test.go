@@ -0,0 +1,173 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncode(t *testing.T) {
+	Convey("Decode reads back what Encode writes", t, func() {
+		src := `fn f(x, y = 1.5, ...rest) {
+    let g = fn() -> x;
+    match [x, rest] {
+        [0, _] => 'z',
+        [-3..=3, [...r]] => "small",
+        [{a, b: [c]}, ...] => a,
+        [true, nil] => f(x: 1),
+        _ => 123456789012345678901234567890,
+    }
+}
+println(f(1));`
+		u := &Unit{
+			Checksum:       Checksum([]byte(src)),
+			Exports:        []string{"f"},
+			Rewritten:      true,
+			Warnings:       []string{"main.nv:1:1: a warning"},
+			Redeclarations: true,
+			Main:           compile(src),
+		}
+		var b bytes.Buffer
+		So(Encode(&b, u), ShouldBeNil)
+		So(b.String(), ShouldStartWith, Magic)
+
+		v, err := Decode(bytes.NewReader(b.Bytes()), "main.nv")
+		So(err, ShouldBeNil)
+		So(v.Checksum, ShouldEqual, u.Checksum)
+		So(v.Exports, ShouldResemble, u.Exports)
+		So(v.Rewritten, ShouldBeTrue)
+		So(v.Warnings, ShouldResemble, u.Warnings)
+		So(v.Redeclarations, ShouldBeTrue)
+		var want, got strings.Builder
+		Disassemble(&want, u.Main)
+		Disassemble(&got, v.Main)
+		So(got.String(), ShouldEqual, want.String())
+	})
+
+	Convey("Decode rejects", t, func() {
+		var b bytes.Buffer
+		So(Encode(&b, &Unit{Main: compile("1;")}), ShouldBeNil)
+		data := b.Bytes()
+
+		Convey("other files", func() {
+			_, err := Decode(strings.NewReader("#!/bin/sh\n"), "main.nv")
+			So(err, ShouldBeError, "not compiled Naive code")
+		})
+
+		Convey("other versions", func() {
+			old := append([]byte(nil), data...)
+			old[len(Magic)] = 0
+			_, err := Decode(bytes.NewReader(old), "main.nv")
+			So(err, ShouldBeError, "compiled code of version 0, want 3")
+		})
+
+		Convey("truncated files", func() {
+			_, err := Decode(bytes.NewReader(data[:len(data)-1]), "main.nv")
+			So(err, ShouldBeError, "corrupt compiled code: checksum mismatch")
+			_, err = Decode(bytes.NewReader(data[:len(Magic)+10]), "main.nv")
+			So(err, ShouldBeError, "unexpected EOF")
+		})
+
+		Convey("corrupted files", func() {
+			for k := len(Magic) + 1; k < len(data); k++ {
+				bad := append([]byte(nil), data...)
+				bad[k] ^= 0x55
+				_, err := Decode(bytes.NewReader(bad), "main.nv")
+				So(err, ShouldBeError, "corrupt compiled code: checksum mismatch")
+			}
+		})
+	})
+
+	Convey("Decode rejects code that Compile cannot have written", t, func() {
+		// main returns a Proto of the code of a file, with one constant.
+		main := func(code ...byte) *Proto {
+			return &Proto{Name: Main, NumLocals: 1, MaxStack: 2, Code: code, Consts: []any{"x"}}
+		}
+		testCases := []struct {
+			name string
+			p    *Proto
+			err  string
+		}{
+			{
+				name: "unknown instructions",
+				p:    main(byte(OpNil), 200),
+				err:  "unknown instruction 200 at 1",
+			},
+			{
+				name: "truncated instructions",
+				p:    main(byte(OpNil), byte(OpConst), 0),
+				err:  "truncated instruction CONST at 1",
+			},
+			{
+				name: "constants out of range",
+				p:    main(byte(OpConst), 0, 1, byte(OpReturn)),
+				err:  "CONST at 0: bad constant 1",
+			},
+			{
+				name: "constants of the wrong kind",
+				p:    main(byte(OpClosure), 0, 0, byte(OpReturn)),
+				err:  "CLOSURE at 0: bad constant 0",
+			},
+			{
+				name: "locals out of range",
+				p:    main(byte(OpGetLocal), 0, 1, byte(OpReturn)),
+				err:  "GET_LOCAL at 0: no local 1",
+			},
+			{
+				name: "upvalues out of range",
+				p:    main(byte(OpGetUpval), 0, 0, byte(OpReturn)),
+				err:  "GET_UPVAL at 0: no upvalue 0",
+			},
+			{
+				name: "jumps into instructions",
+				p:    main(byte(OpJump), 0, 4, byte(OpConst), 0, 0, byte(OpReturn)),
+				err:  "jump into an instruction from 0",
+			},
+			{
+				name: "jumps past the end",
+				p:    main(byte(OpJump), 0, 9, byte(OpNil), byte(OpReturn)),
+				err:  "code runs past its end from 0",
+			},
+			{
+				name: "code running past the end",
+				p:    main(byte(OpNil), byte(OpPop)),
+				err:  "code runs past its end from 1",
+			},
+			{
+				name: "operand stack underflows",
+				p:    main(byte(OpNil), byte(OpAdd), byte(OpReturn)),
+				err:  "operand stack underflow at 1",
+			},
+			{
+				name: "operand stack overflows",
+				p:    main(byte(OpNil), byte(OpNil), byte(OpNil), byte(OpReturn)),
+				err:  "operand stack overflow at 2",
+			},
+			{
+				name: "stack heights that differ",
+				p:    main(byte(OpTrue), byte(OpJumpIfFalse), 0, 5, byte(OpNil), byte(OpNil), byte(OpReturn)),
+				err:  "stack heights 0 and 1 meet at 5",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				var b bytes.Buffer
+				So(Encode(&b, &Unit{Main: tc.p}), ShouldBeNil)
+				_, err := Decode(bytes.NewReader(b.Bytes()), "main.nv")
+				So(err, ShouldBeError, "corrupt compiled code: function <main>: "+tc.err)
+			})
+		}
+
+		Convey("functions that do not check out", func() {
+			f := &Proto{Name: "f", Code: []byte{byte(OpGetLocal), 0, 0, byte(OpReturn)}}
+			p := main(byte(OpClosure), 0, 0, byte(OpReturn))
+			p.Consts = []any{f}
+			var b bytes.Buffer
+			So(Encode(&b, &Unit{Main: p}), ShouldBeNil)
+			_, err := Decode(bytes.NewReader(b.Bytes()), "main.nv")
+			So(err, ShouldBeError, "corrupt compiled code: function f: GET_LOCAL at 0: no local 0")
+		})
+	})
+}
@@ -0,0 +1,202 @@
+package compiler
+
+import "naive/token"
+
+// Op is an instruction of the bytecode. An instruction is one byte for the Op
+// followed by its operands, which are two bytes each, big-endian.
+//
+// The comments below give the operands of every Op and its effect on the
+// operand stack, whose top is on the right.
+type Op byte
+
+const (
+	OpConst Op = iota // k: -> Consts[k]
+	OpNil             // -> nil
+	OpTrue            // -> true
+	OpFalse           // -> false
+	OpPop             // x ->
+
+	OpGetLocal     // j: -> local j
+	OpSetLocal     // j: x -> ; local j = x
+	OpGetUpval     // j: -> upvalue j
+	OpSetUpval     // j: x -> ; upvalue j = x, which must be defined
+	OpGetGlobal    // j, name: -> global j
+	OpSetGlobal    // j, name: x -> ; global j = x, which must be defined
+	OpDefineGlobal // j, name: x -> ; global j = x
+	OpGetBuiltin   // name: -> the builtin called Consts[name]
+	OpClear        // j, n: locals j to j+n-1 become undefined
+	OpClose        // j: closes the upvalues of locals j and above
+
+	OpAdd // x y -> x + y
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEq
+	OpNe
+	OpGt
+	OpGe
+	OpLt
+	OpLe
+	OpAnd
+	OpOr
+	OpNeg // x -> -x
+	OpNot // x -> !x
+
+	OpList      // n: x1 ... xn -> [x1, ..., xn]
+	OpMap       // -> {}
+	OpMapSet    // m k v -> m ; m[k] = v
+	OpRange     // inclusive: start end -> start..end
+	OpRangeStep // r step -> r ; sets the step of r
+	OpMember    // name: x -> x.name
+
+	OpJump        // target: jumps to target
+	OpJumpIfFalse // target: x -> ; jumps to target if x is falsy
+	OpPropagate   // target: r -> v ; unwraps ok(v) and jumps to target, or leaves err(e)
+
+	OpCall          // n: a1 ... an f -> f(a1, ..., an)
+	OpCallNamed     // n, names, callee: a1 ... an f -> f(a1, ..., an) with names Consts[names]
+	OpTailCall      // n: as OpCall, but the callee takes over the frame
+	OpTailCallNamed // n, names, callee: as OpCallNamed, but the callee takes over the frame
+	OpReturn        // x -> ; returns x
+	OpClosure       // proto: -> a closure of Consts[proto]
+
+	OpDefault           // j, target: jumps to target if the argument of parameter j is given
+	OpRequire           // j, message: raises Consts[message] if parameter j has no argument
+	OpDestructure       // pattern, slots, arg: x -> ; binds the variables of a pattern to locals
+	OpDestructureGlobal // pattern: x -> ; binds the variables of a pattern to globals
+	OpMatch             // pattern, slots, target: x -> ; binds as OpDestructure, or jumps to target
+	OpNoMatch           // x -> ; raises that no match arm matches x
+
+	OpIter    // x -> an iterator over x
+	OpForIter // j, target: -> v ; the next value of the iterator in local j, or jumps to target
+
+	OpTry    // target, j: handles errors by jumping to target, closing locals j and above
+	OpEndTry // removes the latest handler
+	OpThrow  // x -> ; throws x
+
+	OpImport      // path: -> the module at Consts[path]
+	OpImportCheck // name: m -> m ; raises if m has no public member name
+	OpImportName  // name: m -> m m.name
+)
+
+// Info describes an Op.
+type Info struct {
+	Name string
+	// Operands is the number of operands.
+	Operands int
+}
+
+// Infos describes every Op.
+var Infos = [...]Info{
+	OpConst:             {"CONST", 1},
+	OpNil:               {"NIL", 0},
+	OpTrue:              {"TRUE", 0},
+	OpFalse:             {"FALSE", 0},
+	OpPop:               {"POP", 0},
+	OpGetLocal:          {"GET_LOCAL", 1},
+	OpSetLocal:          {"SET_LOCAL", 1},
+	OpGetUpval:          {"GET_UPVAL", 1},
+	OpSetUpval:          {"SET_UPVAL", 1},
+	OpGetGlobal:         {"GET_GLOBAL", 2},
+	OpSetGlobal:         {"SET_GLOBAL", 2},
+	OpDefineGlobal:      {"DEFINE_GLOBAL", 2},
+	OpGetBuiltin:        {"GET_BUILTIN", 1},
+	OpClear:             {"CLEAR", 2},
+	OpClose:             {"CLOSE", 1},
+	OpAdd:               {"ADD", 0},
+	OpSub:               {"SUB", 0},
+	OpMul:               {"MUL", 0},
+	OpDiv:               {"DIV", 0},
+	OpMod:               {"MOD", 0},
+	OpEq:                {"EQ", 0},
+	OpNe:                {"NE", 0},
+	OpGt:                {"GT", 0},
+	OpGe:                {"GE", 0},
+	OpLt:                {"LT", 0},
+	OpLe:                {"LE", 0},
+	OpAnd:               {"AND", 0},
+	OpOr:                {"OR", 0},
+	OpNeg:               {"NEG", 0},
+	OpNot:               {"NOT", 0},
+	OpList:              {"LIST", 1},
+	OpMap:               {"MAP", 0},
+	OpMapSet:            {"MAP_SET", 0},
+	OpRange:             {"RANGE", 1},
+	OpRangeStep:         {"RANGE_STEP", 0},
+	OpMember:            {"MEMBER", 1},
+	OpJump:              {"JUMP", 1},
+	OpJumpIfFalse:       {"JUMP_IF_FALSE", 1},
+	OpPropagate:         {"PROPAGATE", 1},
+	OpCall:              {"CALL", 1},
+	OpCallNamed:         {"CALL_NAMED", 3},
+	OpTailCall:          {"TAIL_CALL", 1},
+	OpTailCallNamed:     {"TAIL_CALL_NAMED", 3},
+	OpReturn:            {"RETURN", 0},
+	OpClosure:           {"CLOSURE", 1},
+	OpDefault:           {"DEFAULT", 2},
+	OpRequire:           {"REQUIRE", 2},
+	OpDestructure:       {"DESTRUCTURE", 3},
+	OpDestructureGlobal: {"DESTRUCTURE_GLOBAL", 1},
+	OpMatch:             {"MATCH", 3},
+	OpNoMatch:           {"NO_MATCH", 0},
+	OpIter:              {"ITER", 0},
+	OpForIter:           {"FOR_ITER", 2},
+	OpTry:               {"TRY", 2},
+	OpEndTry:            {"END_TRY", 0},
+	OpThrow:             {"THROW", 0},
+	OpImport:            {"IMPORT", 1},
+	OpImportCheck:       {"IMPORT_CHECK", 1},
+	OpImportName:        {"IMPORT_NAME", 1},
+}
+
+func (op Op) String() string {
+	if int(op) < len(Infos) {
+		return Infos[op].Name
+	}
+	return "UNKNOWN"
+}
+
+// Operators maps the Ops of binary and unary operators to the kinds of the
+// operators.
+var Operators = map[Op]token.Kind{
+	OpAdd: token.KindAdd,
+	OpSub: token.KindSub,
+	OpMul: token.KindMul,
+	OpDiv: token.KindDiv,
+	OpMod: token.KindMod,
+	OpEq:  token.KindEq,
+	OpNe:  token.KindNe,
+	OpGt:  token.KindGt,
+	OpGe:  token.KindGe,
+	OpLt:  token.KindLt,
+	OpLe:  token.KindLe,
+	OpAnd: token.KindAnd,
+	OpOr:  token.KindOr,
+	OpNeg: token.KindSub,
+	OpNot: token.KindNot,
+}
+
+// effect returns the change in the height of the operand stack made by op
+// with operands args, when it does not jump.
+func effect(op Op, args []int) int {
+	switch op {
+	case OpConst, OpNil, OpTrue, OpFalse, OpGetLocal, OpGetUpval, OpGetGlobal,
+		OpGetBuiltin, OpMap, OpClosure, OpForIter, OpImport, OpImportName:
+		return 1
+	case OpPop, OpSetLocal, OpSetUpval, OpSetGlobal, OpDefineGlobal, OpJumpIfFalse,
+		OpReturn, OpDestructure, OpDestructureGlobal, OpMatch, OpNoMatch, OpThrow,
+		OpRange, OpRangeStep:
+		return -1
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpEq, OpNe, OpGt, OpGe, OpLt, OpLe, OpAnd, OpOr:
+		return -1
+	case OpMapSet:
+		return -2
+	case OpList:
+		return 1 - args[0]
+	case OpCall, OpCallNamed, OpTailCall, OpTailCallNamed:
+		return -args[0]
+	default:
+		return 0
+	}
+}
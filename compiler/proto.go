@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"sort"
+
+	"naive/token"
+)
+
+// Main is the name of the Proto of a file, which runs its top-level
+// statements.
+const Main = "<main>"
+
+// Proto is a compiled function: its code and what the code refers to.
+type Proto struct {
+	// Name is the name of the function, "<anonymous>" for a lambda, or Main.
+	Name string
+	// File is the file the function is defined in.
+	File   string
+	Params []Param
+	// NumLocals is the number of local slots of a call, the arguments
+	// first. MaxStack is the maximum height of its operand stack.
+	NumLocals int
+	MaxStack  int
+
+	Code []byte
+	// Consts holds the constants of the code: *big.Int, *big.Float, string,
+	// rune, *Proto, ast.Pattern, []string for the names of arguments and
+	// []int for the locals bound by a pattern.
+	Consts []any
+	// Upvals describes the variables of enclosing functions the function
+	// refers to.
+	Upvals []Upval
+	// Lines maps the positions of the instructions that may need one, such as
+	// calls, to the source, in increasing order of PC.
+	Lines []Line
+
+	keywords []string
+}
+
+// Param describes a parameter of a function.
+type Param struct {
+	// Name is the name of the parameter, or "" if it is a pattern.
+	Name       string
+	Variadic   bool
+	HasDefault bool
+	// Text is the parameter as written, for messages.
+	Text string
+}
+
+// Upval describes a variable of an enclosing function. It is the local Index
+// of the function immediately enclosing if Local is set, and its upvalue
+// Index otherwise.
+type Upval struct {
+	Local bool
+	Index int
+	Name  string
+}
+
+// Line is an entry of Proto.Lines.
+type Line struct {
+	PC     int
+	Line   int
+	Column int
+}
+
+// Variadic reports whether the last parameter of p collects the remaining
+// positional arguments.
+func (p *Proto) Variadic() bool {
+	return len(p.Params) > 0 && p.Params[len(p.Params)-1].Variadic
+}
+
+// ChecksArgs reports whether the code of p checks that its parameters get
+// arguments, which it does if a parameter has a default value or a pattern,
+// so that the checks happen in order with evaluating the defaults and
+// destructuring the arguments. Otherwise the caller checks.
+func (p *Proto) ChecksArgs() bool {
+	for _, pm := range p.Params {
+		if pm.HasDefault || pm.Name == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Keywords returns the names the parameters of p may be passed by, with ""
+// for those that may not be.
+func (p *Proto) Keywords() []string {
+	if p.keywords == nil {
+		p.keywords = make([]string, len(p.Params))
+		for j, pm := range p.Params {
+			if !pm.Variadic {
+				p.keywords[j] = pm.Name
+			}
+		}
+	}
+	return p.keywords
+}
+
+// Loc returns the position of the instruction at pc, or the zero Location if
+// it has none.
+func (p *Proto) Loc(pc int) token.Location {
+	j := sort.Search(len(p.Lines), func(j int) bool {
+		return p.Lines[j].PC >= pc
+	})
+	if j == len(p.Lines) || p.Lines[j].PC != pc {
+		return token.Location{}
+	}
+	return token.Location{FileName: p.File, Line: p.Lines[j].Line, Column: p.Lines[j].Column}
+}
+
+// Operand returns the k-th operand of the instruction at pc.
+func (p *Proto) Operand(pc, k int) int {
+	return int(p.Code[pc+1+2*k])<<8 | int(p.Code[pc+2+2*k])
+}
@@ -0,0 +1,255 @@
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+
+	"naive/ast"
+)
+
+// maxIndex bounds the locals, the globals and the operand stack of a
+// function, as their indices are operands.
+const maxIndex = 1 << 16
+
+// verify checks that p, a function read from a file of compiled code, can be
+// run as Compile would have compiled it: that its instructions are known,
+// their operands refer to locals, upvalues and constants of the right kinds,
+// its jumps land on instructions, and its operand stack stays between empty
+// and MaxStack. enclosing is the function p is defined in, or nil for the
+// code of a file.
+func (d *decoder) verify(p, enclosing *Proto) {
+	fail := func(format string, args ...any) {
+		d.fail(fmt.Errorf("corrupt compiled code: function %s: %s", p.Name, fmt.Sprintf(format, args...)))
+	}
+	if p.NumLocals < len(p.Params) || p.NumLocals > maxIndex || p.MaxStack < 0 || p.MaxStack > maxIndex {
+		fail("bad frame of %d locals and %d stack slots", p.NumLocals, p.MaxStack)
+	}
+	for _, uv := range p.Upvals {
+		switch {
+		case enclosing == nil:
+			fail("upvalue %s outside of functions", uv.Name)
+		case uv.Index < 0,
+			uv.Local && uv.Index >= enclosing.NumLocals,
+			!uv.Local && uv.Index >= len(enclosing.Upvals):
+			fail("bad upvalue %s", uv.Name)
+		}
+	}
+
+	// Find where the instructions start, and check their operands.
+	starts := make([]bool, len(p.Code))
+	for pc := 0; pc < len(p.Code); {
+		op := Op(p.Code[pc])
+		if int(op) >= len(Infos) {
+			fail("unknown instruction %d at %d", op, pc)
+		}
+		next := pc + 1 + 2*Infos[op].Operands
+		if next > len(p.Code) {
+			fail("truncated instruction %s at %d", op, pc)
+		}
+		starts[pc] = true
+		if err := p.checkOperands(pc); err != "" {
+			fail("%s at %d: %s", op, pc, err)
+		}
+		pc = next
+	}
+	for _, c := range p.Consts {
+		if f, ok := c.(*Proto); ok {
+			d.verify(f, p)
+		}
+	}
+
+	// Follow every path through the code, with the height of the operand
+	// stack before each instruction.
+	heights := make([]int, len(p.Code))
+	for pc := range heights {
+		heights[pc] = -1
+	}
+	var work []int
+	reach := func(from, pc, h int) {
+		switch {
+		case pc >= len(p.Code):
+			fail("code runs past its end from %d", from)
+		case !starts[pc]:
+			fail("jump into an instruction from %d", from)
+		case h > p.MaxStack:
+			fail("operand stack overflow at %d", from)
+		case heights[pc] < 0:
+			heights[pc] = h
+			work = append(work, pc)
+		case heights[pc] != h:
+			fail("stack heights %d and %d meet at %d", heights[pc], h, pc)
+		}
+	}
+	reach(0, 0, 0)
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		op, h := Op(p.Code[pc]), heights[pc]
+		args := make([]int, Infos[op].Operands)
+		for k := range args {
+			args[k] = p.Operand(pc, k)
+		}
+		if h < pops(op, args) {
+			fail("operand stack underflow at %d", pc)
+		}
+		next := pc + 1 + 2*len(args)
+		switch op {
+		case OpReturn, OpThrow, OpNoMatch:
+		case OpJump:
+			reach(pc, args[0], h)
+		case OpJumpIfFalse, OpPropagate:
+			reach(pc, args[0], h+effect(op, args))
+			reach(pc, next, h+effect(op, args))
+		case OpDefault, OpForIter:
+			reach(pc, args[1], h)
+			reach(pc, next, h+effect(op, args))
+		case OpMatch:
+			reach(pc, args[2], h-1)
+			reach(pc, next, h-1)
+		case OpTry:
+			reach(pc, args[0], h+1)
+			reach(pc, next, h)
+		default:
+			reach(pc, next, h+effect(op, args))
+		}
+	}
+}
+
+// pops returns the number of values op with operands args takes from the
+// operand stack.
+func pops(op Op, args []int) int {
+	switch op {
+	case OpPop, OpSetLocal, OpSetUpval, OpSetGlobal, OpDefineGlobal, OpNeg, OpNot, OpMember,
+		OpJumpIfFalse, OpPropagate, OpReturn, OpDestructure, OpDestructureGlobal, OpMatch,
+		OpNoMatch, OpIter, OpThrow, OpImportCheck, OpImportName:
+		return 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpEq, OpNe, OpGt, OpGe, OpLt, OpLe, OpAnd, OpOr,
+		OpRange, OpRangeStep:
+		return 2
+	case OpMapSet:
+		return 3
+	case OpList:
+		return args[0]
+	case OpCall, OpCallNamed, OpTailCall, OpTailCallNamed:
+		return args[0] + 1
+	default:
+		return 0
+	}
+}
+
+// checkOperands returns what is wrong with the operands of the instruction at
+// pc, or "" if nothing is.
+func (p *Proto) checkOperands(pc int) string {
+	arg := func(k int) int {
+		return p.Operand(pc, k)
+	}
+	local := func(j int) string {
+		if j >= p.NumLocals {
+			return fmt.Sprintf("no local %d", j)
+		}
+		return ""
+	}
+	constant := func(k int, ok func(c any) bool) string {
+		if k >= len(p.Consts) || !ok(p.Consts[k]) {
+			return fmt.Sprintf("bad constant %d", k)
+		}
+		return ""
+	}
+	name := func(k int) string {
+		return constant(k, func(c any) bool {
+			_, ok := c.(string)
+			return ok
+		})
+	}
+	pattern := func(k int, slots int) string {
+		return constant(k, func(c any) bool {
+			pat, ok := c.(ast.Pattern)
+			return ok && patternSlots(pat, slots)
+		})
+	}
+
+	switch op := Op(p.Code[pc]); op {
+	case OpConst:
+		return constant(arg(0), func(c any) bool {
+			switch c.(type) {
+			case *big.Int, *big.Float, string, rune:
+				return true
+			}
+			return false
+		})
+	case OpGetLocal, OpSetLocal, OpClose:
+		return local(arg(0))
+	case OpClear:
+		return local(arg(0) + arg(1) - 1)
+	case OpGetUpval, OpSetUpval:
+		if arg(0) >= len(p.Upvals) {
+			return fmt.Sprintf("no upvalue %d", arg(0))
+		}
+	case OpGetGlobal, OpSetGlobal, OpDefineGlobal:
+		return name(arg(1))
+	case OpGetBuiltin, OpMember, OpImport, OpImportCheck, OpImportName:
+		return name(arg(0))
+	case OpClosure:
+		return constant(arg(0), func(c any) bool {
+			_, ok := c.(*Proto)
+			return ok
+		})
+	case OpCallNamed, OpTailCallNamed:
+		if msg := constant(arg(1), func(c any) bool {
+			names, ok := c.([]string)
+			return ok && len(names) == arg(0)
+		}); msg != "" {
+			return msg
+		}
+		return name(arg(2))
+	case OpDefault, OpForIter:
+		return local(arg(0))
+	case OpRequire:
+		if msg := local(arg(0)); msg != "" {
+			return msg
+		}
+		return name(arg(1))
+	case OpDestructure, OpMatch:
+		k := arg(1)
+		if msg := constant(k, func(c any) bool {
+			slots, ok := c.([]int)
+			for _, j := range slots {
+				ok = ok && j >= 0 && j < p.NumLocals
+			}
+			return ok
+		}); msg != "" {
+			return msg
+		}
+		return pattern(arg(0), len(p.Consts[k].([]int)))
+	case OpDestructureGlobal:
+		return pattern(arg(0), maxIndex)
+	case OpTry:
+		if arg(1) > p.NumLocals {
+			return fmt.Sprintf("no local %d", arg(1))
+		}
+	}
+	return ""
+}
+
+// patternSlots reports whether the slots of the variables pat binds are
+// below n.
+func patternSlots(pat ast.Pattern, n int) bool {
+	switch pat := pat.(type) {
+	case *ast.BindingPattern:
+		return pat.Slot >= 0 && pat.Slot < n
+	case *ast.ListPattern:
+		for _, elem := range pat.Elems {
+			if !patternSlots(elem, n) {
+				return false
+			}
+		}
+		return pat.Rest == "" || pat.RestSlot >= 0 && pat.RestSlot < n
+	case *ast.RecordPattern:
+		for _, v := range pat.Values {
+			if !patternSlots(v, n) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package interpreter
+
+import (
+	"testing"
+
+	"naive/parser"
+	"naive/token"
+)
+
+func benchmarkScript(b *testing.B, src string) {
+	for n := 0; n < b.N; n++ {
+		interp := Default()
+		interp.P = parser.New(token.NewFile("bench.nv"), []byte(src))
+		interp.Interpret()
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	b.Run("return", func(b *testing.B) {
+		benchmarkScript(b, `fn fib(n) {
+    if n < 2 { return n; }
+    return fib(n - 1) + fib(n - 2);
+}
+fib(20);`)
+	})
+	b.Run("value", func(b *testing.B) {
+		benchmarkScript(b, `fn fib(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } }
+fib(20);`)
+	})
+}
+
+func BenchmarkLoop(b *testing.B) {
+	b.Run("while", func(b *testing.B) {
+		benchmarkScript(b, `let i = 0;
+let sum = 0;
+while i < 10000 {
+    let sq = i * i;
+    sum = sum + sq % 7;
+    i = i + 1;
+}`)
+	})
+	b.Run("for", func(b *testing.B) {
+		benchmarkScript(b, `let sum = 0;
+for i in 0..100 {
+    for j in 0..100 {
+        if (i + j) % 3 == 0 { sum = sum + 1; }
+    }
+}`)
+	})
+}
+
+func BenchmarkCalls(b *testing.B) {
+	b.Run("functions", func(b *testing.B) {
+		benchmarkScript(b, `fn add(a, b) { a + b }
+fn twice(f, x) { f(f(x)) }
+let inc = fn(x) -> add(x, 1);
+let n = 0;
+for i in 0..2000 { n = twice(inc, n); }`)
+	})
+	b.Run("builtins", func(b *testing.B) {
+		benchmarkScript(b, `let n = 0;
+for i in 0..5000 {
+    n = unwrap_or(ok(n + 1), 0);
+    if err?(ok(n)) { n = 0; }
+}`)
+	})
+}
@@ -0,0 +1,126 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"naive/token"
+)
+
+// Capabilities is a set of powers over the world outside the code run, which
+// builtins need to be called. Code run without any is limited to pure
+// computation.
+type Capabilities uint
+
+const (
+	// CapStdio allows reading Stdin and writing Stdout.
+	CapStdio Capabilities = 1 << iota
+	// CapFSRead allows reading files.
+	CapFSRead
+	// CapFSWrite allows creating, writing and removing files.
+	CapFSWrite
+	// CapEnv allows reading the environment variables.
+	CapEnv
+	// CapClock allows reading the time.
+	CapClock
+	// CapRandom allows drawing random numbers.
+	CapRandom
+
+	// CapNone allows only pure computation.
+	CapNone Capabilities = 0
+	// CapAll allows everything.
+	CapAll = CapStdio | CapFSRead | CapFSWrite | CapEnv | CapClock | CapRandom
+)
+
+var capNames = []string{"stdio", "fs-read", "fs-write", "env", "clock", "random"}
+
+// String returns the names of the capabilities of c separated by commas.
+func (c Capabilities) String() string {
+	var names []string
+	for k, name := range capNames {
+		if c&(1<<k) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseCapabilities returns the capabilities named in s, separated by commas,
+// as String writes them. "all" stands for CapAll.
+func ParseCapabilities(s string) (Capabilities, error) {
+	var c Capabilities
+	for _, name := range strings.Split(s, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "", "none":
+			continue
+		case "all":
+			c |= CapAll
+			continue
+		}
+		k := 0
+		for k < len(capNames) && capNames[k] != name {
+			k++
+		}
+		if k == len(capNames) {
+			return 0, fmt.Errorf("unknown capability %q", name)
+		}
+		c |= 1 << k
+	}
+	return c, nil
+}
+
+// Privileged is implemented by the builtins that need capabilities.
+type Privileged interface {
+	Callable
+	// Needs returns the capabilities the builtin needs.
+	Needs() Capabilities
+}
+
+// denied stands for a builtin that needs capabilities the code run lacks.
+type denied struct {
+	name    string
+	missing Capabilities
+}
+
+func (d denied) Call(args []any, i *Interpreter) any {
+	what := "capability"
+	if d.missing&(d.missing-1) != 0 {
+		what = "capabilities"
+	}
+	panic(fmt.Sprintf("permission denied: function %s needs the %s %s", d.name, d.missing, what))
+}
+
+// AllowImport raises a permission error unless caps allow the import of the
+// module path at loc. Imports read files, whether they exist or not, so they
+// need CapFSRead.
+func AllowImport(loc token.Location, path string, caps Capabilities) {
+	if caps&CapFSRead == 0 {
+		panic(fmt.Sprintf("%s: permission denied: import of module %s needs the %s capability", loc, path, CapFSRead))
+	}
+}
+
+// restrict returns v, the builtin called name, as it may be called with caps.
+func restrict(name string, v any, caps Capabilities) any {
+	p, ok := v.(Privileged)
+	if !ok {
+		return v
+	}
+	if missing := p.Needs() &^ caps; missing != 0 {
+		return denied{name: name, missing: missing}
+	}
+	return v
+}
+
+// BuiltinsWith returns the builtin functions by their names, as Builtins
+// does, but those that need more than caps raise a permission error when
+// called.
+func BuiltinsWith(caps Capabilities) map[string]any {
+	builtins := Builtins()
+	for name, v := range builtins {
+		builtins[name] = restrict(name, v, caps)
+	}
+	return builtins
+}
@@ -0,0 +1,122 @@
+package interpreter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// privileged is a builtin that needs the capabilities it holds.
+type privileged Capabilities
+
+func (b privileged) Needs() Capabilities {
+	return Capabilities(b)
+}
+
+func (privileged) Call(args []any, i *Interpreter) any {
+	return "called"
+}
+
+func TestCapabilities(t *testing.T) {
+	Convey("String and ParseCapabilities name capabilities alike", t, func() {
+		testCases := []struct {
+			caps Capabilities
+			name string
+		}{
+			{caps: CapNone, name: "none"},
+			{caps: CapStdio, name: "stdio"},
+			{caps: CapFSRead | CapEnv, name: "fs-read,env"},
+			{caps: CapAll, name: "stdio,fs-read,fs-write,env,clock,random"},
+		}
+		for _, tc := range testCases {
+			So(tc.caps.String(), ShouldEqual, tc.name)
+			c, err := ParseCapabilities(tc.name)
+			So(err, ShouldBeNil)
+			So(c, ShouldEqual, tc.caps)
+		}
+
+		c, err := ParseCapabilities("all")
+		So(err, ShouldBeNil)
+		So(c, ShouldEqual, CapAll)
+		_, err = ParseCapabilities("stdio,network")
+		So(err, ShouldBeError, `unknown capability "network"`)
+	})
+}
+
+func TestInterpreter_capabilities(t *testing.T) {
+	run := func(src string, caps Capabilities) (out string, err any) {
+		var sb strings.Builder
+		interp := NewWithCapabilities("main.nv", []byte(src), caps)
+		interp.Stdin, interp.Stdout = strings.NewReader("line\n"), &sb
+		defer func() {
+			out, err = sb.String(), recover()
+		}()
+		interp.Interpret()
+		return
+	}
+
+	Convey("code without capabilities computes", t, func() {
+		_, err := run(`let s = format("{} {}", 1 + 2, [true]); s;`, CapNone)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("builtins denied raise permission errors", t, func() {
+		for _, src := range []string{`print("x");`, `println("x");`, "getline();"} {
+			out, err := run(src, CapClock)
+			So(out, ShouldBeEmpty)
+			So(err, ShouldEqual, "permission denied: function "+src[:strings.Index(src, "(")]+" needs the stdio capability")
+		}
+	})
+
+	Convey("permission errors may be caught", t, func() {
+		out, err := run(`try { println("x"); } catch e { 1; }`, CapNone)
+		So(out, ShouldBeEmpty)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("builtins allowed run", t, func() {
+		out, err := run(`println(getline());`, CapStdio)
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "line\n")
+	})
+
+	Convey("imports need the fs-read capability", t, func() {
+		dir := t.TempDir()
+		So(os.WriteFile(filepath.Join(dir, "lib.nv"), []byte(`let secret = "s3cret";`), 0644), ShouldBeNil)
+		main := filepath.Join(dir, "main.nv")
+		testCases := []struct {
+			src    string
+			module string
+		}{
+			{src: "import lib; lib.secret;", module: "lib"},
+			{src: "import {secret} from lib;", module: "lib"},
+			{src: "import missing;", module: "missing"},
+		}
+		for _, tc := range testCases {
+			interp := NewWithCapabilities(main, []byte(tc.src), CapStdio)
+			So(func() { interp.Interpret() }, ShouldPanicWith,
+				main+":1:1: permission denied: import of module "+tc.module+" needs the fs-read capability")
+		}
+
+		interp := NewWithCapabilities(main, []byte("import lib; lib.secret;"), CapFSRead)
+		So(interp.Eval(), ShouldEqual, "s3cret")
+	})
+
+	Convey("SetBuiltin restricts Privileged builtins", t, func() {
+		interp := NewWithCapabilities("main.nv", nil, CapStdio)
+		So(interp.Capabilities(), ShouldEqual, CapStdio)
+		interp.SetBuiltin("now", privileged(CapClock))
+		interp.SetBuiltin("env", privileged(CapEnv|CapFSRead))
+		interp.SetBuiltin("echo", privileged(CapStdio))
+
+		interp.SetSource("main.nv", []byte("echo();"))
+		So(interp.Eval(), ShouldEqual, "called")
+		interp.SetSource("main.nv", []byte("now();"))
+		So(func() { interp.Eval() }, ShouldPanicWith, "permission denied: function now needs the clock capability")
+		interp.SetSource("main.nv", []byte("env();"))
+		So(func() { interp.Eval() }, ShouldPanicWith, "permission denied: function env needs the fs-read,env capabilities")
+	})
+}
@@ -0,0 +1,203 @@
+package interpreter
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// output runs src and returns what it prints.
+func output(src string) string {
+	var out strings.Builder
+	interp := New("main.nv", []byte(src))
+	interp.Stdout = &out
+	interp.Interpret()
+	return out.String()
+}
+
+func TestInterpreter_closures(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "counters",
+			src: `fn counter() {
+    let n = 0;
+    fn() { n = n + 1; n }
+}
+let c1 = counter();
+let c2 = counter();
+println(c1(), c1(), c2(), c1(), c2());`,
+			want: "1 2 1 3 2\n",
+		},
+		{
+			name: "shared state",
+			src: `fn account(balance) {
+    let deposit = fn(x) { balance = balance + x; balance };
+    let withdraw = fn(x) { balance = balance - x; balance };
+    [deposit, withdraw]
+}
+let [deposit, withdraw] = account(10);
+deposit(5);
+println(withdraw(3));`,
+			want: "12\n",
+		},
+		{
+			name: "adders",
+			src: `fn adder(n) { fn(x) { x + n } }
+let add2 = adder(2);
+let add10 = adder(10);
+println(add2(1), add10(1), adder(3)(4));`,
+			want: "3 11 7\n",
+		},
+		{
+			name: "curried adders",
+			src: `let add = fn(a) { fn(b) { fn(c) { a + b + c } } };
+let add1 = add(1);
+println(add1(10)(100), add1(20)(200));`,
+			want: "111 221\n",
+		},
+		{
+			name: "memoizer",
+			src: `fn memo(f) {
+    let lookup = fn(n) { nil };
+    fn(n) {
+        let hit = lookup(n);
+        if hit /= nil { hit } else {
+            let v = f(n);
+            let prev = lookup;
+            lookup = fn(m) { if m == n { v } else { prev(m) } };
+            v
+        }
+    }
+}
+let calls = 0;
+let square = memo(fn(n) { calls = calls + 1; n * n });
+println(square(4), square(5), square(4), square(5), calls);`,
+			want: "16 25 16 25 2\n",
+		},
+		{
+			name: "memoized recursion",
+			src: `let calls = 0;
+let fib = nil;
+let known = fn(n) { nil };
+fib = fn(n) {
+    let hit = known(n);
+    if hit /= nil { return hit; }
+    calls = calls + 1;
+    let v = if n < 2 { n } else { fib(n - 1) + fib(n - 2) };
+    let prev = known;
+    known = fn(m) { if m == n { v } else { prev(m) } };
+    v
+};
+println(fib(30), calls);`,
+			want: "832040 31\n",
+		},
+		{
+			name: "for loops bind a variable per iteration",
+			src: `let get = fn(k) { nil };
+for k in 0..3 {
+    let prev = get;
+    get = fn(j) { if j == k { fn(x) { x + k } } else { prev(j) } };
+}
+println(get(0)(10), get(1)(10), get(2)(10));`,
+			want: "10 11 12\n",
+		},
+		{
+			name: "loop bodies are a scope per iteration",
+			src: `let fs = fn(k) { nil };
+let j = 0;
+while j < 3 {
+    let m = j * 10;
+    let prev = fs;
+    fs = fn(k) { if k == m { k + 1 } else { prev(k) } };
+    j = j + 1;
+}
+println(fs(0), fs(10), fs(20), fs(5));`,
+			want: "1 11 21 nil\n",
+		},
+		{
+			name: "closures see later assignments",
+			src: `let x = 1;
+let get = fn() { x };
+x = 2;
+println(get());`,
+			want: "2\n",
+		},
+		{
+			name: "closures keep shadowed bindings",
+			src: `let x = 1;
+let get = fn() { x };
+let x = "two";
+println(get(), x);`,
+			want: "1 two\n",
+		},
+		{
+			name: "functions see the declarations of their whole scope",
+			src: `fn f(a) {
+    let before = fn() { a };
+    let a = a + 1;
+    [before(), a]
+}
+println(f(1));`,
+			want: "[2, 2]\n",
+		},
+		{
+			name: "blocks shadow outer bindings",
+			src: `let x = 1;
+let inner = {
+    let x = 2;
+    fn() { x }
+};
+println(inner(), x);`,
+			want: "2 1\n",
+		},
+		{
+			name: "recursive local functions",
+			src: `fn outer(n) {
+    fn fact(n) { if n <= 1 { 1 } else { n * fact(n - 1) } }
+    let fib = fn(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } };
+    [fact(n), fib(n)]
+}
+let g = "not a function";
+let g = fn(n) { if n == 0 { "done" } else { g(n - 1) } };
+println(outer(10), g(3));`,
+			want: "[3628800, 55] done\n",
+		},
+		{
+			name: "mutually recursive local functions",
+			src: `{
+    fn even(n) { if n == 0 { true } else { odd(n - 1) } }
+    fn odd(n) { if n == 0 { false } else { even(n - 1) } }
+    println(even(10), odd(7), even(3));
+}`,
+			want: "true true false\n",
+		},
+		{
+			name: "functions referring to later declarations",
+			src: `let f = fn() { z * 2 };
+let z = 21;
+println(f());`,
+			want: "42\n",
+		},
+	}
+
+	Convey("closures", t, func() {
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				So(output(tc.src), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("calling a function before a name it refers to is defined", t, func() {
+		So(func() {
+			output(`let f = fn() { z };
+f();
+let z = 1;`)
+		}, ShouldPanicWith, "z is used before its definition")
+	})
+}
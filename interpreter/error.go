@@ -0,0 +1,251 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"naive/ast"
+	"naive/token"
+)
+
+// Error is the runtime representation of error values, which are raised by
+// throw statements and by the interpreter itself, and caught by try-catch
+// statements.
+type Error struct {
+	Message string
+	// Cause is the error that led to this one, or nil.
+	Cause any
+	// Value is the value given to throw if it is not an error value itself.
+	Value any
+	// Stack holds the calls in progress when the error was raised, innermost
+	// first.
+	Stack []Frame
+	// Elided is the number of outermost calls left out of Stack.
+	Elided int
+	// Err is the Go error this one stands for, if it was returned by a Go
+	// function.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) String() string {
+	return "<error " + e.Message + ">"
+}
+
+// Trace returns the message of e followed by its stack trace and those of its
+// causes.
+func (e *Error) Trace() string {
+	var sb strings.Builder
+	for c := any(e); c != nil; {
+		err, ok := c.(*Error)
+		if !ok {
+			sb.WriteString("caused by: " + display(c) + "\n")
+			break
+		}
+		if c != any(e) {
+			sb.WriteString("caused by: ")
+		}
+		sb.WriteString("error: " + err.Message + "\n")
+		for _, f := range err.Stack {
+			sb.WriteString("\tat " + f.String() + "\n")
+		}
+		if err.Elided > 0 {
+			sb.WriteString(fmt.Sprintf("\t... %d more\n", err.Elided))
+		}
+		c = err.Cause
+	}
+	return sb.String()
+}
+
+func (e *Error) member(name string) (any, bool) {
+	switch name {
+	case "message":
+		return e.Message, true
+	case "cause":
+		return e.Cause, true
+	case "value":
+		return e.Value, true
+	case "stack":
+		l := &List{Elems: make([]any, 0, len(e.Stack))}
+		for _, f := range e.Stack {
+			l.Elems = append(l.Elems, f.String())
+		}
+		return l, true
+	}
+	return nil, false
+}
+
+// Frame is an entry of a stack trace: a function and the position reached
+// in it. Loc is the zero Location if the position is unknown.
+type Frame struct {
+	Func string
+	Loc  token.Location
+}
+
+func (f Frame) String() string {
+	if f.Loc.Line == 0 {
+		return f.Func
+	}
+	return f.Func + " (" + f.Loc.String() + ")"
+}
+
+// stack returns the stack trace of an error raised at loc in the innermost
+// function being called.
+func (i *Interpreter) stack(loc token.Location) []Frame {
+	frames := make([]Frame, 0, len(i.frames)+1)
+	for k := len(i.frames) - 1; k >= 0; k-- {
+		frames = append(frames, Frame{Func: i.frames[k].Func, Loc: loc})
+		loc = i.frames[k].Loc
+	}
+	return append(frames, Frame{Func: "<main>", Loc: loc})
+}
+
+// toError turns r, a value recovered from a panic, into an error value. It
+// returns nil if r does not stand for an error that Naive code may catch, such
+// as a Go runtime error.
+func (i *Interpreter) toError(r any) *Error {
+	switch e := r.(type) {
+	case *Error:
+		return e
+	case string:
+		return &Error{Message: e, Stack: i.stack(i.at)}
+	default:
+		return nil
+	}
+}
+
+// Throw returns the completion of throwing e from a builtin called by i. e
+// records the stack unless it has one already.
+func Throw(e *Error, i *Interpreter) *Completion {
+	// Engines other than the interpreter record the stack themselves. They
+	// call builtins with an interpreter that runs no code, and has no P.
+	if e.Stack == nil && i != nil && i.P != nil {
+		e.Stack = i.stack(i.at)
+	}
+	return &Completion{Kind: token.KindThrow, Value: e}
+}
+
+func (i *Interpreter) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	v := stmt.Value.Accept(i)
+	if abrupt(v) {
+		return v
+	}
+	e := Thrown(v)
+	// A caught error thrown again keeps the trace of where it was raised.
+	if e.Stack == nil {
+		e.Stack = i.stack(stmt.Loc)
+	}
+	return &Completion{Kind: token.KindThrow, Value: e}
+}
+
+// VisitTryStmt runs the finally block of stmt however the rest completes,
+// unless a limit on the code is exceeded. If the finally block breaks,
+// continues, returns or throws, that replaces the completion of the rest, even
+// an error.
+func (i *Interpreter) VisitTryStmt(stmt *ast.TryStmt) (ans any) {
+	if stmt.Finally == nil {
+		return i.tryCatch(stmt)
+	}
+	env, depth := i.env, len(i.frames)
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, ok := r.(*LimitError); ok {
+			// Code out of limits runs no further, finally blocks included.
+			panic(r)
+		}
+		if e := i.toError(r); e != nil {
+			r = e
+		}
+		i.env, i.frames = env, i.frames[:depth]
+		if c, ok := stmt.Finally.Accept(i).(*Completion); ok {
+			ans = c
+			return
+		}
+		panic(r)
+	}()
+	ans = i.tryCatch(stmt)
+	finished = true
+	if c, ok := stmt.Finally.Accept(i).(*Completion); ok {
+		return c
+	}
+	return ans
+}
+
+// tryCatch runs the body of stmt, and its catch block if the body throws.
+func (i *Interpreter) tryCatch(stmt *ast.TryStmt) any {
+	if stmt.Catch == nil {
+		return stmt.Body.Accept(i)
+	}
+	ans, caught := i.try(stmt.Body)
+	if caught == nil {
+		return ans
+	}
+	outer := i.env
+	i.env = newEnv(outer, 1)
+	if stmt.Var != "" {
+		i.env.Define(0, stmt.Var, caught)
+	}
+	ans = stmt.Catch.Accept(i)
+	i.env = outer
+	return ans
+}
+
+// try runs body and returns the error it throws or raises, if any.
+func (i *Interpreter) try(body ast.Stmt) (ans any, caught *Error) {
+	return i.Try(func() any { return body.Accept(i) })
+}
+
+// Try calls f, which runs Naive code with i, and returns the error the code
+// throws or raises, if any, as a try statement catches it. i is then left as
+// it was before the call. Panics that are not errors of Naive code, such as Go
+// runtime errors, are passed on.
+func (i *Interpreter) Try(f func() any) (ans any, caught *Error) {
+	env, depth, args := i.env, len(i.frames), len(i.args)
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		caught = i.toError(r)
+		i.env, i.frames, i.args = env, i.frames[:depth], i.args[:args]
+		if caught == nil {
+			panic(r)
+		}
+	}()
+	ans = f()
+	if c, ok := ans.(*Completion); ok && c.Kind == token.KindThrow {
+		return nil, c.Value.(*Error)
+	}
+	return ans, nil
+}
+
+type BuiltinError struct{}
+
+func (BuiltinError) Call(args []any, i *Interpreter) any {
+	if len(args) < 1 || len(args) > 2 {
+		panic(fmt.Sprintf("function error takes 1 or 2 arguments, but %d are provided", len(args)))
+	}
+	msg, ok := args[0].(string)
+	if !ok {
+		panic("type mismatch: 1st argument of function error shall be of type 'String'")
+	}
+	e := &Error{Message: msg}
+	if len(args) == 2 {
+		e.Cause = args[1]
+	}
+	return e
+}
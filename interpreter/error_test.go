@@ -0,0 +1,187 @@
+package interpreter
+
+import (
+	"math/big"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/token"
+)
+
+func TestInterpreter_VisitTryStmt(t *testing.T) {
+	run := func(src string) *Interpreter {
+		interp := New("main.nv", []byte(src))
+		interp.Interpret()
+		return interp
+	}
+	lookup := func(interp *Interpreter, name string) any {
+		v, _ := interp.env.Lookup(name)
+		return v
+	}
+
+	Convey("thrown errors", t, func() {
+		interp := run(`fn check(x) {
+    if x > 2 { throw error("too big", x); }
+    x
+}
+fn twice(x) { check(x) * 2 }
+let msg = nil;
+let cause = nil;
+let stack = nil;
+try {
+    twice(5);
+} catch e {
+    msg = e.message;
+    cause = e.cause;
+    stack = e.stack;
+}`)
+		So(lookup(interp, "msg"), ShouldEqual, "too big")
+		So(lookup(interp, "cause"), ShouldResemble, big.NewInt(5))
+		So(lookup(interp, "stack").(*List).Elems, ShouldResemble, []any{
+			"check (main.nv:2:16)",
+			"twice (main.nv:5:15)",
+			"<main> (main.nv:10:5)",
+		})
+	})
+
+	Convey("interpreter errors", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "1 / 0;", want: "division by zero"},
+			{src: "let m = {}; m.x;", want: `map has no key "x"`},
+			{src: `1 + "a";`, want: `type mismatch: "a" is not an integer`},
+			{src: "fn f(a) {} f(1, 2);", want: "function f takes 1 positional arguments but 2 are provided"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				interp := run("let msg = nil; try { " + tc.src + " } catch e { msg = e.message; }")
+				So(lookup(interp, "msg"), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("thrown values", t, func() {
+		interp := run(`let v = nil; try { throw 41; } catch e { v = e.value + 1; }`)
+		So(lookup(interp, "v"), ShouldResemble, big.NewInt(42))
+	})
+
+	Convey("finally", t, func() {
+		interp := run(`let log = "";
+fn f() {
+    try { return 1; } finally { log = format("{}{} ", log, "f"); }
+}
+let r = f();
+for x in 0..3 {
+    try { if x == 1 { break; } } finally { log = format("{}{} ", log, x); }
+}
+try {
+    try { throw "inner"; } finally { log = format("{}{} ", log, "inner"); }
+} catch e {
+    log = format("{}{} ", log, e.message);
+}`)
+		So(lookup(interp, "r"), ShouldResemble, big.NewInt(1))
+		So(lookup(interp, "log"), ShouldEqual, "f 0 1 inner inner ")
+	})
+
+	Convey("finally blocks that complete abruptly", t, func() {
+		interp := run(`fn f(x) {
+    try { 10 / x; throw "thrown"; } finally { return "returned"; }
+}
+let a = f(0);
+let b = f(1);
+let c = nil;
+try {
+    try { throw "first"; } finally { throw "second"; }
+} catch e {
+    c = e.message;
+}`)
+		So(lookup(interp, "a"), ShouldEqual, "returned")
+		So(lookup(interp, "b"), ShouldEqual, "returned")
+		So(lookup(interp, "c"), ShouldEqual, "second")
+	})
+
+	Convey("rethrown errors keep their stack", t, func() {
+		var r any
+		func() {
+			defer func() {
+				r = recover()
+			}()
+			run(`fn f() { throw error("boom"); }
+try { f(); } catch e { throw e; }`)
+		}()
+		So(r, ShouldResemble, &Error{
+			Message: "boom",
+			Stack: []Frame{
+				{Func: "f", Loc: loc("main.nv", 1, 10)},
+				{Func: "<main>", Loc: loc("main.nv", 2, 7)},
+			},
+		})
+	})
+}
+
+func TestInterpreter_raisedErrors(t *testing.T) {
+	Convey("errors raised by operations are at the operations", t, func() {
+		testCases := []struct {
+			name  string
+			src   string
+			stack []Frame
+		}{
+			{
+				name:  "type mismatches",
+				src:   "fn f(a, b) { a + b }\nf(1, \"s\");",
+				stack: []Frame{{Func: "f", Loc: loc("main.nv", 1, 16)}, {Func: "<main>", Loc: loc("main.nv", 2, 1)}},
+			},
+			{
+				name:  "missing arguments",
+				src:   "fn f(a, b) { a + b }\nlet x = -1;\nf(x);",
+				stack: []Frame{{Func: "f"}, {Func: "<main>", Loc: loc("main.nv", 3, 1)}},
+			},
+			{
+				name:  "destructuring",
+				src:   "let n = 1 + 2;\nlet [a, b] = [n];",
+				stack: []Frame{{Func: "<main>", Loc: loc("main.nv", 2, 1)}},
+			},
+			{
+				name:  "variables used before their definition",
+				src:   "fn g() { y }\ng();\nlet y = 1;",
+				stack: []Frame{{Func: "g", Loc: loc("main.nv", 1, 10)}, {Func: "<main>", Loc: loc("main.nv", 2, 1)}},
+			},
+			{
+				name:  "members",
+				src:   "let m = {a: 1};\nm.a + m.b;",
+				stack: []Frame{{Func: "<main>", Loc: loc("main.nv", 2, 8)}},
+			},
+			{
+				name:  "builtins",
+				src:   "let s = 1 + 1;\nformat(s);",
+				stack: []Frame{{Func: "<main>", Loc: loc("main.nv", 2, 1)}},
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				interp := New("main.nv", []byte(tc.src))
+				_, caught := interp.Try(func() any { return interp.Eval() })
+				So(caught, ShouldNotBeNil)
+				So(caught.Stack, ShouldResemble, tc.stack)
+			})
+		}
+	})
+
+	Convey("try statements catch errors raised with their positions", t, func() {
+		interp := New("main.nv", []byte(`let stack = nil;
+try {
+    [1] - 1;
+} catch e {
+    stack = e.stack;
+}
+stack;`))
+		So(interp.Eval(), ShouldResemble, &List{Elems: []any{"<main> (main.nv:3:9)"}})
+	})
+}
+
+func loc(file string, line, column int) token.Location {
+	return token.Location{FileName: file, Line: line, Column: column}
+}
@@ -3,10 +3,12 @@ package interpreter
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"naive/ast"
+	"naive/token"
 )
 
 type Callable interface {
@@ -16,56 +18,144 @@ type Callable interface {
 // Func is the runtime representation of named function
 type Func struct {
 	Name   string
-	Params []string
+	Params []*ast.Param
 	Body   ast.Stmt
 	Env    *Env
 }
 
-func (f *Func) Call(args []any, i *Interpreter) (ans any) {
-	if len(args) != len(f.Params) {
-		panic(fmt.Sprintf(
-			"function %s takes %d positional arguments but %d are provided",
-			f.Name, len(f.Params), len(args)))
+func (f *Func) Call(args []any, i *Interpreter) any {
+	return f.CallNamed(args, nil, i)
+}
+
+// CallNamed calls f with args, where names[j] is the name of the parameter
+// args[j] is passed to, or "" if args[j] is positional. names is nil if all
+// the arguments are positional. If f throws an error, the result is a
+// Completion that throws it.
+func (f *Func) CallNamed(args []any, names []string, i *Interpreter) any {
+	return i.call(f, args, names, token.Location{})
+}
+
+// DefaultMaxCallDepth is the default of Interpreter.MaxCallDepth.
+const DefaultMaxCallDepth = 10000
+
+// StackPerCall bounds the Go stack a call in progress takes: about 1.3 KB for
+// a plain recursive call, up to 3.2 KB for a body of deeply nested
+// expressions. Go doubles a stack as it grows and refuses stacks over 2 GB,
+// so MaxStack is the largest stack it runs, and MaxStackDepth the largest
+// MaxCallDepth that stack holds, once debug.SetMaxStack allows it.
+const (
+	StackPerCall  = 4 << 10
+	MaxStack      = 1 << 30
+	MaxStackDepth = MaxStack / StackPerCall
+)
+
+// overflowFrames is the number of frames a stack overflow error keeps, from
+// the innermost one.
+const overflowFrames = 16
+
+// tailCall is the value of a call in tail position. The function being called
+// returns it, and the call it makes is run in its place.
+type tailCall struct {
+	f     *Func
+	args  []any
+	names []string
+	loc   token.Location
+}
+
+// call calls f as CallNamed does, from loc.
+func (i *Interpreter) call(f *Func, args []any, names []string, loc token.Location) any {
+	if len(i.frames) >= i.MaxCallDepth {
+		return &Completion{Kind: token.KindThrow, Value: StackOverflow(i.MaxCallDepth, i.stack(loc))}
 	}
+	// The frame is left in place if the call raises an error, so that the
+	// error can record it. Whoever catches the error pops it.
+	i.frames = append(i.frames, Frame{Func: f.Name, Loc: loc})
+	for {
+		i.at = token.Location{}
+		ans := f.enter(args, names, i)
+		tc, ok := ans.(*tailCall)
+		if !ok {
+			i.frames = i.frames[:len(i.frames)-1]
+			i.at = loc
+			return ans
+		}
+		f, args, names = tc.f, tc.args, tc.names
+		i.frames[len(i.frames)-1] = Frame{Func: f.Name, Loc: tc.loc}
+	}
+}
+
+// enter runs the body of f with args bound to its parameters.
+func (f *Func) enter(args []any, names []string, i *Interpreter) any {
+	vals, given := f.arrange(args, names)
 
 	old := i.env
-	i.env = newLocalEnv(f.Env)
-	for j := range args {
-		k, v := f.Params[j], args[j]
-		i.env.Define(k, v)
-	}
-	defer func() {
-		i.env = old
-	}()
-
-	defer func() {
-		r0 := recover()
-		if r0 == nil {
-			return
+	i.env = newEnv(f.Env, len(f.Params))
+
+	// Defaults are evaluated in the scope of the call, after the parameters
+	// before them are bound, so `fn f(a, b = a * 2)` works.
+	for j, pm := range f.Params {
+		v := vals[j]
+		if given != nil && !given[j] {
+			if pm.Default == nil {
+				panic(fmt.Sprintf("function %s: missing argument for parameter %s", f.Name, pm))
+			}
+			if v = pm.Default.Accept(i); abrupt(v) {
+				i.env = old
+				return v
+			}
 		}
-		switch r := r0.(type) {
-		case *Return:
-			ans = r.RetVal
-		default:
-			panic(r0)
+		if pm.Pattern == nil {
+			i.env.Define(pm.Slot, pm.Name, v)
+		} else if !i.bind(pm.Pattern, v) {
+			panic(fmt.Sprintf("function %s: cannot destructure argument %d %s with %s: %s",
+				f.Name, j+1, repr(v), pm.Pattern, Mismatch(pm.Pattern, v)))
 		}
-	}()
-	f.Body.Accept(i)
+	}
 
-	return
+	ans := f.Body.Accept(i)
+	i.env = old
+	if c, ok := ans.(*Completion); ok && c.Kind == token.KindReturn {
+		return c.Value
+	}
+	return ans
+}
+
+// arrange assigns args to the parameters of f, as Arrange does. given is nil
+// if every parameter gets an argument.
+func (f *Func) arrange(args []any, names []string) (vals []any, given []bool) {
+	n := len(f.Params)
+	variadic := n > 0 && f.Params[n-1].Variadic
+	if names == nil && len(args) == n && !variadic {
+		return args, nil
+	}
+	keywords := make([]string, n)
+	for j, pm := range f.Params {
+		if pm.Pattern == nil && !pm.Variadic {
+			keywords[j] = pm.Name
+		}
+	}
+	return Arrange(f.Name, keywords, variadic, args, names)
 }
 
 type BuiltinPrint struct{}
 
+func (BuiltinPrint) Needs() Capabilities {
+	return CapStdio
+}
+
 func (BuiltinPrint) Call(args []any, i *Interpreter) any {
-	fmt.Print(args...)
+	fmt.Fprint(i.stdout(), displayAll(args, false))
 	return nil
 }
 
 type BuiltinPrintLn struct{}
 
+func (BuiltinPrintLn) Needs() Capabilities {
+	return CapStdio
+}
+
 func (BuiltinPrintLn) Call(args []any, i *Interpreter) any {
-	fmt.Println(args...)
+	fmt.Fprintln(i.stdout(), displayAll(args, true))
 	return nil
 }
 
@@ -80,16 +170,71 @@ func (BuiltinFormat) Call(args []any, i *Interpreter) any {
 		panic("type mismatch: 1st argument of function format shall be of type 'String'")
 	}
 	f = strings.ReplaceAll(f, "{}", "%v")
-	return fmt.Sprintf(f, args[1:]...)
+	vals := make([]any, 0, len(args)-1)
+	for _, a := range args[1:] {
+		vals = append(vals, display(a))
+	}
+	return fmt.Sprintf(f, vals...)
 }
 
 type BuiltinGetLine struct{}
 
+func (BuiltinGetLine) Needs() Capabilities {
+	return CapStdio
+}
+
 func (BuiltinGetLine) Call(args []any, i *Interpreter) any {
-	s := bufio.NewScanner(os.Stdin)
-	s.Split(bufio.ScanLines)
-	if s.Scan() {
-		return s.Text()
+	line, _ := ReadLine(i.stdin())
+	return line
+}
+
+// stdin buffers the standard input of the process for everything that reads
+// it, so that none reads ahead what the others are to read.
+var stdin = bufio.NewReader(os.Stdin)
+
+// Stdin returns the buffer of the standard input of the process that
+// interpreters read it through.
+func Stdin() *bufio.Reader {
+	return stdin
+}
+
+// ReadLine reads a line from r, without its line ending, and reports whether
+// there was one before the end of input.
+func ReadLine(r *bufio.Reader) (string, bool) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), true
+}
+
+// stdin returns the buffer Stdin is read through. A nil interpreter uses the
+// standard files of the process.
+func (i *Interpreter) stdin() *bufio.Reader {
+	if i == nil || i.Stdin == nil || i.Stdin == io.Reader(os.Stdin) {
+		return stdin
+	}
+	if i.in == nil {
+		if r, ok := i.Stdin.(*bufio.Reader); ok {
+			i.in = r
+		} else {
+			i.in = bufio.NewReader(i.Stdin)
+		}
+	}
+	return i.in
+}
+
+func (i *Interpreter) stdout() io.Writer {
+	if i == nil || i.Stdout == nil {
+		return os.Stdout
+	}
+	return i.Stdout
+}
+
+func (i *Interpreter) stderr() io.Writer {
+	if i == nil || i.Stderr == nil {
+		return os.Stderr
 	}
-	return ""
+	return i.Stderr
 }
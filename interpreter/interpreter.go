@@ -1,97 +1,345 @@
 package interpreter
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"math/big"
+	"os"
+	"path/filepath"
 
 	"naive/ast"
 	"naive/parser"
+	"naive/resolver"
 	"naive/token"
 )
 
 var _ ast.Visitor = (*Interpreter)(nil)
 
+// Env holds the bindings of a scope in slots, which the resolver assigns to
+// the declarations of the scope in order.
+//
+// A function captures the Env it is defined in by reference, so it sees later
+// assignments to the bindings of enclosing scopes. Calls, blocks that declare
+// names and every iteration of a loop run in a new Env, so a function defined
+// in a loop body keeps the bindings of its iteration.
 type Env struct {
-	bindings  map[string]any
+	slots     []binding
 	enclosing *Env
+	// consts holds the slots of the bindings that cannot be assigned to. It
+	// is nil if there are none.
+	consts map[int]bool
+	// small holds the slots of scopes with few of them, which then take no
+	// allocation of their own.
+	small [2]binding
 }
 
-func newGlobalEnv() *Env {
-	return newLocalEnv(nil)
+type binding struct {
+	value any
+	// name is only needed to look up bindings by name.
+	name string
 }
 
+// undefined is the value of the slots whose declarations have not run yet.
+type undefinedValue struct{}
+
+var undefined any = undefinedValue{}
+
 func newLocalEnv(enclosing *Env) *Env {
-	return &Env{
-		bindings:  make(map[string]any),
-		enclosing: enclosing,
+	return newEnv(enclosing, 0)
+}
+
+// newEnv returns an Env enclosed by enclosing with room for n slots, all
+// undefined.
+func newEnv(enclosing *Env, n int) *Env {
+	e := &Env{enclosing: enclosing}
+	if n <= len(e.small) {
+		e.slots = e.small[:n]
+	} else {
+		e.slots = make([]binding, n)
+	}
+	for j := range e.slots {
+		e.slots[j].value = undefined
+	}
+	return e
+}
+
+// Define binds slot of e to v.
+func (e *Env) Define(slot int, name string, v any) {
+	for len(e.slots) <= slot {
+		e.slots = append(e.slots, binding{value: undefined})
+	}
+	e.slots[slot] = binding{v, name}
+	delete(e.consts, slot)
+}
+
+// markConst makes the binding in slot of e a constant.
+func (e *Env) markConst(slot int) {
+	if e.consts == nil {
+		e.consts = make(map[int]bool)
 	}
+	e.consts[slot] = true
 }
 
-func (e *Env) Define(k string, v any) (shadow bool) {
-	_, shadow = e.bindings[k]
-	e.bindings[k] = v
-	return
+// depth returns the number of scopes enclosing e.
+func (e *Env) depth() int {
+	n := 0
+	for ; e.enclosing != nil; e = e.enclosing {
+		n++
+	}
+	return n
+}
+
+func (e *Env) ancestor(depth int) *Env {
+	for ; depth > 0; depth-- {
+		e = e.enclosing
+	}
+	return e
+}
+
+// Get returns the value in slot of the scope depth levels out from e. It
+// returns undefined if the declaration of the binding has not run yet.
+func (e *Env) Get(depth, slot int) any {
+	e = e.ancestor(depth)
+	if slot >= len(e.slots) {
+		return undefined
+	}
+	return e.slots[slot].value
+}
+
+// Set assigns v to a binding defined before, as Get finds it.
+func (e *Env) Set(depth, slot int, v any) (done bool) {
+	e = e.ancestor(depth)
+	if slot >= len(e.slots) || e.slots[slot].value == undefined {
+		return false
+	}
+	if e.consts[slot] {
+		panic("cannot assign to constant " + e.slots[slot].name)
+	}
+	e.slots[slot].value = v
+	return true
 }
 
+// Lookup returns the value of the latest binding called k in e or the
+// scopes enclosing it.
 func (e *Env) Lookup(k string) (v any, present bool) {
-	curr := e
-	for curr != nil {
-		v, present = curr.bindings[k]
-		if present {
+	for curr := e; curr != nil; curr = curr.enclosing {
+		if v, present = curr.lookup(k); present {
 			return v, present
 		}
-		curr = curr.enclosing
 	}
 	return nil, false
 }
 
-func (e *Env) Assign(k string, v any) (done bool) {
-	curr := e
-	for curr != nil {
-		_, present := curr.bindings[k]
-		if present {
-			curr.bindings[k] = v
-			return true
+func (e *Env) lookup(k string) (v any, present bool) {
+	for j := len(e.slots) - 1; j >= 0; j-- {
+		if b := e.slots[j]; b.name == k && b.value != undefined {
+			return b.value, true
 		}
-		curr = curr.enclosing
 	}
-	return false
+	return nil, false
 }
 
 type Interpreter struct {
 	P *parser.Parser
 
-	env *Env
+	// SearchPath lists the directories searched for a module that is not
+	// found relative to the importing file. It defaults to the list in the
+	// NAIVE_PATH environment variable.
+	SearchPath []string
+
+	// Redeclarations enables warnings about names declared more than once in
+	// the same scope.
+	Redeclarations bool
+
+	// MaxCallDepth is the number of calls that may be in progress at once.
+	// A call beyond it throws a stack overflow error. Tail calls do not add
+	// to the depth.
+	MaxCallDepth int
+
+	// Limits limit the code run. Eval resets their usage.
+	Limits
+
+	// Stdin, Stdout and Stderr are the files of the code run: getline reads
+	// lines from Stdin, print and println write to Stdout, and warnings go
+	// to Stderr. They default to the standard files of the process. Stdin
+	// must not change once code reads it.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Rewrite, if not nil, rewrites the statements of every file run before
+	// they are resolved, as optimize.Optimize does.
+	Rewrite func(stmts []ast.Stmt) []ast.Stmt
+
+	// in buffers Stdin.
+	in       *bufio.Reader
+	env      *Env
+	caps     Capabilities
+	builtins map[string]any
+	// cached holds the values of the builtins looked up since builtins last
+	// changed by their slots, and nil for the others.
+	cached []any
+	// resolver resolves the statements run in env, the top-level scope.
+	resolver *resolver.Resolver
+
+	// file is the path of the file being run, which imports are relative to.
+	file string
+	// modules caches the modules loaded so far by their absolute paths.
+	modules map[string]*Module
+	// loading holds the absolute paths of the files being run, outermost
+	// first, to detect import cycles.
+	loading []string
+	// frames holds the calls of Naive functions in progress, outermost first.
+	// Loc is the position of each call.
+	frames []Frame
+	// at is the position of the operation being run in the innermost call,
+	// where the errors it raises are reported.
+	at token.Location
+	// args holds the arguments of the calls being made.
+	args []any
+}
+
+// New returns an interpreter running src, the source of the file at filename,
+// with all capabilities.
+func New(filename string, src []byte) *Interpreter {
+	return NewWithCapabilities(filename, src, CapAll)
 }
 
-func New(filename string, src []byte) *Interpreter {
+// NewWithCapabilities is as New, but the builtins that need capabilities
+// other than caps raise a permission error when called, as do imports
+// without CapFSRead.
+func NewWithCapabilities(filename string, src []byte, caps Capabilities) *Interpreter {
 	i := &Interpreter{
 		P: parser.New(
 			token.NewFile(filename),
 			src,
 		),
-		env: newGlobalEnv(),
+		SearchPath:   filepath.SplitList(os.Getenv("NAIVE_PATH")),
+		MaxCallDepth: DefaultMaxCallDepth,
+		caps:         caps,
+		builtins:     BuiltinsWith(caps),
+		file:         filename,
+		modules:      make(map[string]*Module),
 	}
-	i.setupBuiltins()
+	i.env = newLocalEnv(nil)
+	i.resolver = i.newResolver()
 	return i
 }
 
-func (i *Interpreter) setupBuiltins() {
-	i.env.Define("print", BuiltinPrint{})
-	i.env.Define("println", BuiltinPrintLn{})
-	i.env.Define("format", BuiltinFormat{})
-	i.env.Define("getline", BuiltinGetLine{})
-}
-
 func Default() *Interpreter {
 	return New("", nil)
 }
 
 func (i *Interpreter) Interpret() {
+	i.Eval()
+}
+
+// Eval is as Interpret, but returns the value of the code run: the value of
+// its last statement if that is an expression, the value of a top-level return
+// statement, or nil.
+func (i *Interpreter) Eval() any {
+	// Errors in parsing and resolving the code are raised at no position of
+	// a call.
+	i.frames, i.args, i.at = i.frames[:0], i.args[:0], token.Location{}
 	i.P.Parse()
 	// fmt.Println(i.P.Statements)
-	for _, stmt := range i.P.Statements {
-		stmt.Accept(i)
+	i.P.Statements = i.rewrite(i.P.Statements)
+	if i.file != "" && len(i.loading) == 0 {
+		if path, err := filepath.Abs(i.file); err == nil {
+			i.loading = append(i.loading, path)
+			defer func() {
+				i.loading = i.loading[:0]
+			}()
+		}
+	}
+	i.ResetUsage()
+	i.check(i.resolver, i.P.Statements)
+	return i.run(i.P.Statements)
+}
+
+// SetSource makes i run src, the source of the file at path, next. Imports
+// are relative to path.
+func (i *Interpreter) SetSource(path string, src []byte) {
+	i.P, i.file = parser.New(token.NewFile(path), src), path
+}
+
+// run executes stmts, the top-level statements of a file, and returns their
+// value as Eval does. A return ends the file early, and an error thrown and
+// not caught is raised as a panic.
+func (i *Interpreter) run(stmts []ast.Stmt) (ans any) {
+	for _, stmt := range stmts {
+		var v any
+		if s, ok := stmt.(*ast.ExprStmt); ok {
+			v = s.Expr.Accept(i)
+		} else {
+			v = stmt.Accept(i)
+		}
+		if c, ok := v.(*Completion); ok {
+			if c.Kind == token.KindThrow {
+				panic(c.Value)
+			}
+			return c.Value
+		}
+		ans = v
 	}
+	return ans
+}
+
+// globals returns the top-level scope of the file being run.
+func (i *Interpreter) globals() *Env {
+	return i.env.ancestor(i.env.depth())
+}
+
+// Lookup returns the value of the latest top-level binding called name in the
+// file being run.
+func (i *Interpreter) Lookup(name string) (v any, present bool) {
+	return i.globals().lookup(name)
+}
+
+// Capabilities returns the capabilities of the code run by i.
+func (i *Interpreter) Capabilities() Capabilities {
+	return i.caps
+}
+
+// SetBuiltin makes v the builtin called name, in place of any other. It
+// raises a permission error when called if it is Privileged and needs more
+// than the capabilities of i.
+func (i *Interpreter) SetBuiltin(name string, v any) {
+	i.builtins[name] = restrict(name, v, i.caps)
+	i.cached = nil
+}
+
+// Define binds name to v at the top level of the file being run, as a
+// declaration run before the code run next would.
+func (i *Interpreter) Define(name string, v any) {
+	i.globals().Define(i.resolver.Declare(name), name, v)
+}
+
+// rewrite returns stmts, the statements of a file, as rewritten by Rewrite.
+func (i *Interpreter) rewrite(stmts []ast.Stmt) []ast.Stmt {
+	if i.Rewrite == nil {
+		return stmts
+	}
+	return i.Rewrite(stmts)
+}
+
+// check resolves stmts, the top-level statements of a file, with r.
+func (i *Interpreter) check(r *resolver.Resolver, stmts []ast.Stmt) {
+	r.Redeclarations = i.Redeclarations
+	n := len(r.Warnings)
+	r.Resolve(stmts)
+	for _, w := range r.Warnings[n:] {
+		fmt.Fprintln(i.stderr(), "warning: "+w)
+	}
+}
+
+// newResolver returns a resolver for a file run by i.
+func (i *Interpreter) newResolver() *resolver.Resolver {
+	return resolver.New(func(name string) bool {
+		_, ok := i.builtins[name]
+		return ok
+	})
 }
 
 func (Interpreter) VisitIntegerValue(expr ast.IntegerValue) any {
@@ -123,48 +371,53 @@ func (*Interpreter) VisitNil(_ ast.Nil) any {
 }
 
 func (i *Interpreter) VisitVariable(expr *ast.Variable) any {
-	v, ok := i.env.Lookup(expr.Ident)
-	if !ok {
-		panic("undefined variable: " + expr.Ident)
+	if expr.Depth < 0 {
+		if expr.Slot >= len(i.cached) {
+			i.cached = append(i.cached, make([]any, expr.Slot+1-len(i.cached))...)
+		}
+		v := i.cached[expr.Slot]
+		if v == nil {
+			v = i.builtins[expr.Ident]
+			i.cached[expr.Slot] = v
+		}
+		return v
+	}
+	v := i.env.Get(expr.Depth, expr.Slot)
+	if v == undefined {
+		i.at = expr.Loc
+		panic(expr.Ident + " is used before its definition")
 	}
 	return v
 }
 
 func (i *Interpreter) VisitBinaryExpr(expr *ast.BinaryExpr) any {
-	lhs, rhs := expr.Lhs.Accept(i), expr.Rhs.Accept(i)
-	switch expr.Op {
-	case token.KindAdd:
-		return doAdd(lhs, rhs)
-	case token.KindSub:
-		return doSub(lhs, rhs)
-	case token.KindMul:
-		return doMul(lhs, rhs)
-	case token.KindDiv:
-		return doDiv(lhs, rhs)
-	case token.KindMod:
-		return doMod(lhs, rhs)
-
-	case token.KindEq:
-		return doEq(lhs, rhs)
-	case token.KindNe:
-		return doNe(lhs, rhs)
-	case token.KindGt:
-		return doGt(lhs, rhs)
-	case token.KindGe:
-		return doGe(lhs, rhs)
-	case token.KindLt:
-		return doLt(lhs, rhs)
-	case token.KindLe:
-		return doLe(lhs, rhs)
-
-	case token.KindAnd:
-		return doLogicalAnd(lhs, rhs)
-	case token.KindOr:
-		return doLogicalOr(lhs, rhs)
-
-	default:
-		panic("unreachable")
+	lhs := expr.Lhs.Accept(i)
+	if abrupt(lhs) {
+		return lhs
+	}
+	rhs := expr.Rhs.Accept(i)
+	if abrupt(rhs) {
+		return rhs
 	}
+	i.at = expr.Loc
+	ans := Binary(expr.Op, lhs, rhs)
+	i.account(ans)
+	return ans
+}
+
+// smallInt is an integer with room for a magnitude of two words, which most
+// integers computed fit in.
+type smallInt struct {
+	n big.Int
+	w [2]big.Word
+}
+
+// NewInt returns a new integer set to 0. Integers of up to two words computed
+// into it take no allocation of their own.
+func NewInt() *big.Int {
+	s := new(smallInt)
+	s.n.SetBits(s.w[:0])
+	return &s.n
 }
 
 func doAdd(lhs, rhs any) any {
@@ -180,8 +433,7 @@ func doFloatAdd(lhs *big.Float, rhs *big.Float) *big.Float {
 }
 
 func doIntegerAdd(lhs *big.Int, rhs *big.Int) *big.Int {
-	ans := big.NewInt(0)
-	return ans.Add(lhs, rhs)
+	return NewInt().Add(lhs, rhs)
 }
 
 func doSub(lhs, rhs any) any {
@@ -197,8 +449,7 @@ func doFloatSub(lhs *big.Float, rhs *big.Float) *big.Float {
 }
 
 func doIntegerSub(lhs *big.Int, rhs *big.Int) *big.Int {
-	ans := big.NewInt(0)
-	return ans.Sub(lhs, rhs)
+	return NewInt().Sub(lhs, rhs)
 }
 
 func doMul(lhs, rhs any) any {
@@ -214,8 +465,7 @@ func doFloatMul(lhs *big.Float, rhs *big.Float) *big.Float {
 }
 
 func doIntegerMul(lhs *big.Int, rhs *big.Int) *big.Int {
-	ans := big.NewInt(0)
-	return ans.Mul(lhs, rhs)
+	return NewInt().Mul(lhs, rhs)
 }
 
 func doDiv(lhs, rhs any) any {
@@ -226,13 +476,18 @@ func doDiv(lhs, rhs any) any {
 }
 
 func doFloatDiv(lhs *big.Float, rhs *big.Float) *big.Float {
+	if lhs.Sign() == 0 && rhs.Sign() == 0 {
+		panic("division by zero")
+	}
 	ans := big.NewFloat(0)
 	return ans.Quo(lhs, rhs)
 }
 
 func doIntegerDiv(lhs *big.Int, rhs *big.Int) *big.Int {
-	ans := big.NewInt(0)
-	return ans.Div(lhs, rhs)
+	if rhs.Sign() == 0 {
+		panic("division by zero")
+	}
+	return NewInt().Div(lhs, rhs)
 }
 
 func doMod(lhs, rhs any) any {
@@ -247,8 +502,18 @@ func doFloatMod(lhs *big.Float, rhs *big.Float) *big.Float {
 }
 
 func doIntegerMod(lhs *big.Int, rhs *big.Int) *big.Int {
-	ans := big.NewInt(0)
-	return ans.Mod(lhs, rhs)
+	if rhs.Sign() == 0 {
+		panic("division by zero")
+	}
+	return NewInt().Mod(lhs, rhs)
+}
+
+func isNumber(x any) bool {
+	switch x.(type) {
+	case *big.Int, *big.Float:
+		return true
+	}
+	return false
 }
 
 func isFloat(x any) bool {
@@ -270,7 +535,7 @@ func toFloat(x0 any) *big.Float {
 			return big.NewFloat(0)
 		}
 	default:
-		panic("unreachable")
+		panic("type mismatch: " + repr(x0) + " is not a number")
 	}
 }
 
@@ -285,13 +550,16 @@ func toInt(x0 any) *big.Int {
 			return big.NewInt(0)
 		}
 	default:
-		panic("unreachable")
+		panic("type mismatch: " + repr(x0) + " is not an integer")
 	}
 }
 
 func doEq(lhs, rhs any) bool {
-	v, _ := branchByNumberType(lhs, rhs, doFloatEq, doIntegerEq).(bool)
-	return v
+	if isNumber(lhs) && isNumber(rhs) {
+		v, _ := branchByNumberType(lhs, rhs, doFloatEq, doIntegerEq).(bool)
+		return v
+	}
+	return equals(lhs, rhs)
 }
 
 func doFloatEq(lhs, rhs *big.Float) any {
@@ -303,15 +571,44 @@ func doIntegerEq(lhs, rhs *big.Int) any {
 }
 
 func doNe(lhs, rhs any) any {
-	return branchByNumberType(lhs, rhs, doFloatNe, doIntegerNe)
-}
-
-func doFloatNe(lhs, rhs *big.Float) any {
-	return lhs.Cmp(rhs) != 0
-}
-
-func doIntegerNe(lhs, rhs *big.Int) any {
-	return lhs.Cmp(rhs) != 0
+	return !doEq(lhs, rhs)
+}
+
+// equals compares values other than two numbers. Lists and maps are equal if
+// their elements are.
+func equals(lhs, rhs any) bool {
+	switch l := lhs.(type) {
+	case *big.Int, *big.Float:
+		return false
+	case *List:
+		r, ok := rhs.(*List)
+		if !ok || len(l.Elems) != len(r.Elems) {
+			return false
+		}
+		for j := range l.Elems {
+			if !doEq(l.Elems[j], r.Elems[j]) {
+				return false
+			}
+		}
+		return true
+	case *Result:
+		r, ok := rhs.(*Result)
+		return ok && l.Ok == r.Ok && doEq(l.Value, r.Value)
+	case *Map:
+		r, ok := rhs.(*Map)
+		if !ok || l.Len() != r.Len() {
+			return false
+		}
+		for j, k := range l.keys {
+			v, present := r.Get(k)
+			if !present || !doEq(l.values[j], v) {
+				return false
+			}
+		}
+		return true
+	default:
+		return lhs == rhs
+	}
 }
 
 func doGt(lhs, rhs any) any {
@@ -388,14 +685,13 @@ func isFalsy(x0 any) bool {
 
 func (i *Interpreter) VisitUnaryExpr(expr *ast.UnaryExpr) any {
 	x := expr.X.Accept(i)
-	switch expr.Op {
-	case token.KindSub:
-		return doNeg(x)
-	case token.KindNot:
-		return doLogicalNot(x)
-	default:
-		panic("unreachable")
+	if abrupt(x) {
+		return x
 	}
+	i.at = expr.Loc
+	ans := Unary(expr.Op, x)
+	i.account(ans)
+	return ans
 }
 
 func doNeg(x any) any {
@@ -437,69 +733,279 @@ func (i *Interpreter) VisitGroupingExpr(expr *ast.GroupingExpr) any {
 	return expr.Expr.Accept(i)
 }
 
+func (i *Interpreter) VisitRangeExpr(expr *ast.RangeExpr) any {
+	start := expr.Start.Accept(i)
+	if abrupt(start) {
+		return start
+	}
+	end := expr.End.Accept(i)
+	if abrupt(end) {
+		return end
+	}
+	i.at = expr.Loc
+	r := NewRange(start, end, expr.Inclusive)
+	if expr.Step != nil {
+		step := expr.Step.Accept(i)
+		if abrupt(step) {
+			return step
+		}
+		i.at = expr.Loc
+		r.SetStep(step)
+	}
+	return r
+}
+
+func rangeBound(what string, v any) *big.Int {
+	n, ok := v.(*big.Int)
+	if !ok {
+		panic("type mismatch: range " + what + " shall be of type 'Int'")
+	}
+	return n
+}
+
+func (i *Interpreter) VisitListExpr(expr *ast.ListExpr) any {
+	l := &List{
+		Elems: make([]any, 0, len(expr.Elems)),
+	}
+	for _, e := range expr.Elems {
+		v := e.Accept(i)
+		if abrupt(v) {
+			return v
+		}
+		l.Elems = append(l.Elems, v)
+	}
+	return l
+}
+
+func (i *Interpreter) VisitMapExpr(expr *ast.MapExpr) any {
+	m := NewMap()
+	for j := range expr.Keys {
+		k := expr.Keys[j].Accept(i)
+		if abrupt(k) {
+			return k
+		}
+		v := expr.Values[j].Accept(i)
+		if abrupt(v) {
+			return v
+		}
+		i.at = token.Location{}
+		m.Set(k, v)
+	}
+	return m
+}
+
 func (i *Interpreter) VisitLetStmt(stmt *ast.LetStmt) any {
 	init := stmt.Init.Accept(i)
-	i.env.Define(stmt.Ident, init)
+	if abrupt(init) {
+		return init
+	}
+	if stmt.Pattern == nil {
+		i.env.Define(stmt.Slot, stmt.Ident, init)
+	} else if !i.bind(stmt.Pattern, init) {
+		i.at = stmt.Loc
+		panic(fmt.Sprintf("cannot destructure %s with %s: %s",
+			repr(init), stmt.Pattern, Mismatch(stmt.Pattern, init)))
+	}
+	if stmt.Const {
+		for _, slot := range stmt.Slots() {
+			i.env.markConst(slot)
+		}
+	}
 	return nil
 }
 
 func (i *Interpreter) VisitAssignStmt(stmt *ast.AssignStmt) any {
 	v := stmt.Expr.Accept(i)
-	if !i.env.Assign(stmt.Ident, v) {
-		panic("assignment to undefined variable " + stmt.Ident)
+	if abrupt(v) {
+		return v
+	}
+	i.at = stmt.Loc
+	if !i.env.Set(stmt.Depth, stmt.Slot, v) {
+		panic(stmt.Ident + " is used before its definition")
 	}
 	return nil
 }
 
 func (i *Interpreter) VisitIfElseStmt(stmt *ast.IfElseStmt) any {
-	if isTruthy(stmt.Cond.Accept(i)) {
+	cond := stmt.Cond.Accept(i)
+	if abrupt(cond) {
+		return cond
+	}
+	if isTruthy(cond) {
 		return stmt.Then.Accept(i)
 	}
 	return stmt.Else.Accept(i)
 }
 
 func (i *Interpreter) VisitWhileStmt(stmt *ast.WhileStmt) any {
-	for isTruthy(stmt.Cond.Accept(i)) {
-		stmt.Body.Accept(i)
+	for {
+		cond := stmt.Cond.Accept(i)
+		if abrupt(cond) {
+			return cond
+		}
+		if isFalsy(cond) {
+			return nil
+		}
+		if c, ok := stmt.Body.Accept(i).(*Completion); ok {
+			if !c.targets(stmt.Label) {
+				return c
+			}
+			if c.Kind == token.KindBreak {
+				return nil
+			}
+		}
+		i.step(token.Location{})
+	}
+}
+
+func (i *Interpreter) VisitForStmt(stmt *ast.ForStmt) any {
+	iter := stmt.Iter.Accept(i)
+	if abrupt(iter) {
+		return iter
+	}
+	i.at = token.Location{}
+	it := Iterate(iter, i)
+	outer := i.env
+	for {
+		v, ok := it.Next()
+		if !ok {
+			break
+		}
+		if abrupt(v) {
+			i.env = outer
+			return v
+		}
+		// Every iteration gets a scope of its own, so closures created in the
+		// body capture the value of this iteration.
+		i.env = newEnv(outer, 1)
+		i.env.Define(0, stmt.Var, v)
+		if c, ok := stmt.Body.Accept(i).(*Completion); ok {
+			if !c.targets(stmt.Label) {
+				i.env = outer
+				return c
+			}
+			if c.Kind == token.KindBreak {
+				break
+			}
+		}
+		i.step(token.Location{})
 	}
+	i.env = outer
 	return nil
 }
 
+// Completion is the result of executing a statement that transfers control
+// elsewhere: a break, continue, return or throw. Statements, and expressions
+// that contain statements, hand it up unchanged until it reaches the loop,
+// call or try statement it targets, so none of them needs panic and recover.
+// Any other result is a normal completion.
+//
+// Errors raised by the interpreter itself and by builtins are still Go panics,
+// which try statements recover from. Only a try statement restores the
+// environment and the calls in progress when it catches one.
+type Completion struct {
+	Kind token.Kind // token.KindBreak, KindContinue, KindReturn or KindThrow
+	// Label is the label of the loop a break or continue targets, if any.
+	Label string
+	// Value is the value returned, or the *Error thrown.
+	Value any
+}
+
+var (
+	breakCompletion    = &Completion{Kind: token.KindBreak}
+	continueCompletion = &Completion{Kind: token.KindContinue}
+)
+
+// abrupt reports whether v, the result of executing a node, is a Completion.
+func abrupt(v any) bool {
+	_, ok := v.(*Completion)
+	return ok
+}
+
+// targets reports whether c is a break or continue meant for the loop
+// labelled label.
+func (c *Completion) targets(label string) bool {
+	if c.Kind != token.KindBreak && c.Kind != token.KindContinue {
+		return false
+	}
+	return c.Label == "" || c.Label == label
+}
+
+func (i *Interpreter) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	if stmt.Label == "" {
+		return breakCompletion
+	}
+	return &Completion{Kind: token.KindBreak, Label: stmt.Label}
+}
+
+func (i *Interpreter) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	if stmt.Label == "" {
+		return continueCompletion
+	}
+	return &Completion{Kind: token.KindContinue, Label: stmt.Label}
+}
+
 func (i *Interpreter) VisitFnStmt(stmt *ast.FnStmt) any {
-	i.env.Define(stmt.Ident, &Func{
+	i.env.Define(stmt.Slot, stmt.Ident, &Func{
 		Name:   stmt.Ident,
 		Params: stmt.Params,
 		Body:   stmt.Body,
 		Env:    i.env,
 	})
-	i.env = newLocalEnv(i.env)
 	return nil
 }
 
-type Return struct {
-	RetVal any
-}
-
 func (i *Interpreter) VisitReturnStmt(stmt *ast.ReturnStmt) any {
 	ret := stmt.RetVal.Accept(i)
-	// TODO: better solutions?
-	panic(&Return{RetVal: ret})
+	if abrupt(ret) {
+		return ret
+	}
+	return &Completion{Kind: token.KindReturn, Value: ret}
 }
 
 func (i *Interpreter) VisitCallExpr(expr *ast.CallExpr) any {
-	args := make([]any, 0, len(expr.Args))
+	i.step(expr.Loc)
+	// The arguments are pushed on i.args, and copied from there only for the
+	// calls that keep them.
+	base := len(i.args)
 	for _, a := range expr.Args {
-		args = append(args, a.Accept(i))
+		v := a.Accept(i)
+		if abrupt(v) {
+			i.args = i.args[:base]
+			return v
+		}
+		i.args = append(i.args, v)
 	}
-	v, ok := i.env.Lookup(expr.Callee)
-	if !ok {
-		panic("undefined callable object '" + expr.Callee + "'")
+	v := expr.Callee.Accept(i)
+	if abrupt(v) {
+		i.args = i.args[:base]
+		return v
+	}
+	fn, ok := v.(*Func)
+	if ok && !expr.Tail {
+		ans := i.call(fn, i.args[base:], expr.ArgNames, expr.Loc)
+		i.args = i.args[:base]
+		return ans
+	}
+	args := append([]any(nil), i.args[base:]...)
+	i.args = i.args[:base]
+	if ok {
+		if expr.Tail {
+			return &tailCall{f: fn, args: args, names: expr.ArgNames, loc: expr.Loc}
+		}
+		return i.call(fn, args, expr.ArgNames, expr.Loc)
+	}
+	i.at = expr.Loc
+	if expr.ArgNames != nil {
+		panic("function " + ast.CalleeName(expr.Callee) + " does not take named arguments")
 	}
 	f, ok := v.(Callable)
 	if !ok {
 		panic("calling non-callable object")
 	}
-	return f.Call(args, i)
+	ans := f.Call(args, i)
+	i.account(ans)
+	return ans
 }
 
 func (i *Interpreter) VisitLambda(expr *ast.Lambda) any {
@@ -509,12 +1015,13 @@ func (i *Interpreter) VisitLambda(expr *ast.Lambda) any {
 		Body:   expr.Body,
 		Env:    i.env,
 	}
-	i.env = newLocalEnv(i.env)
 	return ans
 }
 
 func (i *Interpreter) VisitExprStmt(stmt *ast.ExprStmt) any {
-	stmt.Expr.Accept(i)
+	if v := stmt.Expr.Accept(i); abrupt(v) {
+		return v
+	}
 	return nil
 }
 
@@ -522,14 +1029,20 @@ func (i *Interpreter) VisitEmptyStmt(stmt *ast.EmptyStmt) any {
 	return nil
 }
 
-func (i *Interpreter) VisitBlock(blk *ast.Block) any {
+func (i *Interpreter) VisitBlock(blk *ast.Block) (ans any) {
 	outer := i.env
-	i.env = newLocalEnv(outer)
-	defer func() {
-		i.env = outer
-	}()
+	if blk.Slots > 0 {
+		i.env = newEnv(outer, blk.Slots)
+	}
 	for _, stmt := range blk.Statements {
-		stmt.Accept(i)
+		if c, ok := stmt.Accept(i).(*Completion); ok {
+			i.env = outer
+			return c
+		}
 	}
-	return nil
+	if blk.Value != nil {
+		ans = blk.Value.Accept(i)
+	}
+	i.env = outer
+	return ans
 }
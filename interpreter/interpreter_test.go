@@ -1,9 +1,17 @@
 package interpreter
 
 import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/parser"
 )
 
 func TestInterpreter_VisitBlock(t *testing.T) {
@@ -28,3 +36,476 @@ println(a);`
 		interp.Interpret()
 	})
 }
+
+func TestInterpreter_VisitWhileStmt(t *testing.T) {
+	Convey("break and continue", t, func() {
+		src := `let n = 0;
+let sum = 0;
+while true {
+    n = n + 1;
+    if n > 10 { break; }
+    if n % 2 == 0 { continue; }
+    sum = sum + n;
+}`
+		interp := New("", []byte(src))
+		interp.Interpret()
+		v, _ := interp.env.Lookup("sum")
+		So(v.(*big.Int).Int64(), ShouldEqual, 25)
+	})
+
+	Convey("labelled", t, func() {
+		src := `let count = 0;
+let i = 0;
+outer: while i < 3 {
+    i = i + 1;
+    let j = 0;
+    while true {
+        j = j + 1;
+        if j > 2 { continue outer; }
+        if i == 3 { break outer; }
+        count = count + 1;
+    }
+}`
+		interp := New("", []byte(src))
+		interp.Interpret()
+		v, _ := interp.env.Lookup("count")
+		So(v.(*big.Int).Int64(), ShouldEqual, 4)
+	})
+}
+
+func TestInterpreter_VisitForStmt(t *testing.T) {
+	lookupInt := func(interp *Interpreter, name string) int64 {
+		v, _ := interp.env.Lookup(name)
+		return v.(*big.Int).Int64()
+	}
+
+	Convey("iterables", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want int64
+		}{
+			{name: "exclusive range", src: "for x in 0..5 { n = n + x; }", want: 10},
+			{name: "inclusive range", src: "for x in 0..=5 { n = n + x; }", want: 15},
+			{name: "stepped range", src: "for x in 10..0 step -4 { n = n + x; }", want: 18},
+			{name: "empty range", src: "for x in 5..5 { n = n + 1; }", want: 0},
+			{name: "list", src: "for x in [1, 2, 3] { n = n + x; }", want: 6},
+			{name: "map keys", src: "for k in {1: 10, 2: 20} { n = n + k; }", want: 3},
+			{name: "string", src: `for c in "héllo" { n = n + 1; }`, want: 5},
+			{name: "invalid UTF-8", src: "for c in \"a\xffb\xc3\" { if c == 'b' { n = n + 10; } else { n = n + 1; } }", want: 13},
+			{
+				name: "user-defined iterator",
+				src: `fn upto(m) {
+    let i = 0;
+    return fn () {
+        if i < m { i = i + 1; return i; }
+    };
+}
+for x in upto(4) { n = n + x; }`,
+				want: 10,
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				interp := New("", []byte("let n = 0;\n"+tc.src))
+				interp.Interpret()
+				So(lookupInt(interp, "n"), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("per-iteration binding", t, func() {
+		src := `let f = nil;
+let g = nil;
+for x in 0..2 {
+    if x == 0 { f = fn () -> x; } else { g = fn () -> x; }
+}
+let a = f();
+let b = g();`
+		interp := New("", []byte(src))
+		interp.Interpret()
+		So(lookupInt(interp, "a"), ShouldEqual, 0)
+		So(lookupInt(interp, "b"), ShouldEqual, 1)
+	})
+
+	Convey("not iterable", t, func() {
+		interp := New("", []byte("for x in 1.5 { }"))
+		So(func() { interp.Interpret() }, ShouldPanic)
+	})
+}
+
+func TestInterpreter_VisitIfElseStmt(t *testing.T) {
+	Convey("as expression", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want any
+		}{
+			{name: "then arm", src: "let x = if 1 < 2 { 10 } else { 20 };", want: int64(10)},
+			{name: "else-if arm", src: "let x = if false { 1 } else if true { 2 } else { 3 };", want: int64(2)},
+			{name: "missing else", src: "let x = if false { 1 };", want: nil},
+			{name: "block", src: "let x = { let a = 3; a * a };", want: int64(9)},
+			{name: "lambda body", src: "let f = fn (n) { n + 1 }; let x = f(41);", want: int64(42)},
+			{name: "function body", src: "fn f(n) { if n > 0 { n } else { -n } } let x = f(-7);", want: int64(7)},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				interp := New("", []byte(tc.src))
+				interp.Interpret()
+				v, _ := interp.env.Lookup("x")
+				if n, ok := v.(*big.Int); ok {
+					v = n.Int64()
+				}
+				So(v, ShouldEqual, tc.want)
+			})
+		}
+	})
+}
+
+func TestInterpreter_VisitMatchExpr(t *testing.T) {
+	describe := `fn describe(v) {
+    match v {
+        0 => "zero",
+        1..=9 => "digit",
+        'a'..='z' => "lower",
+        "hi" => "greeting",
+        [] => "empty",
+        [a, b, ...rest] if a == b => format("pair {} {}", a, rest),
+        [first, ...] => format("first {}", first),
+        {x, y: 0} => format("x axis {}", x),
+        n if n > 100 => "big",
+        _ => "other",
+    }
+}
+`
+	Convey("arms", t, func() {
+		testCases := []struct {
+			arg  string
+			want string
+		}{
+			{arg: "0", want: "zero"},
+			{arg: "7", want: "digit"},
+			{arg: "'q'", want: "lower"},
+			{arg: `"hi"`, want: "greeting"},
+			{arg: "[]", want: "empty"},
+			{arg: "[2, 2, 3]", want: "pair 2 [3]"},
+			{arg: "[1, 2]", want: "first 1"},
+			{arg: "{x: 3, y: 0}", want: "x axis 3"},
+			{arg: "101", want: "big"},
+			{arg: "50", want: "other"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.arg, func() {
+				interp := New("", []byte(describe+"let x = describe("+tc.arg+");"))
+				interp.Interpret()
+				v, _ := interp.env.Lookup("x")
+				So(v, ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("no arm matches", t, func() {
+		interp := New("", []byte("match [1] { [] => 0, [a, b] => 2 }"))
+		So(func() { interp.Interpret() }, ShouldPanicWith, "no match arm matches value [1]")
+	})
+}
+
+func TestInterpreter_destructuring(t *testing.T) {
+	Convey("valid", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{name: "list with rest", src: "let [a, b, ...rest] = [1, 2, 3, 4]; let x = format(\"{} {} {}\", a, b, rest);", want: "1 2 [3, 4]"},
+			{name: "nested record", src: "let {k, v: [p, q]} = {k: 1, v: [2, 3], z: 0}; let x = format(\"{} {} {}\", k, p, q);", want: "1 2 3"},
+			{name: "multiple results", src: "fn divmod(n, d) { [n / d, n % d] } let [q, r] = divmod(17, 5); let x = format(\"{} {}\", q, r);", want: "3 2"},
+			{name: "record parameter", src: "fn f({a, b}) { a - b } let x = format(\"{}\", f({a: 5, b: 3}));", want: "2"},
+			{name: "lambda parameter", src: "let f = fn ([a, b]) -> a * b; let x = format(\"{}\", f([6, 7]));", want: "42"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				interp := New("", []byte(tc.src))
+				interp.Interpret()
+				v, _ := interp.env.Lookup("x")
+				So(v, ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("mismatched shapes", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{name: "too many elements", src: "let [a, b] = [1, 2, 3];", want: "cannot destructure [1, 2, 3] with [a, b]: want 2 elements, got 3"},
+			{name: "too few elements", src: "let [a, b, ...c] = [1];", want: "cannot destructure [1] with [a, b, ...c]: want at least 2 elements, got 1"},
+			{name: "not a list", src: "let [a] = 1;", want: "cannot destructure 1 with [a]: want a list, got 1"},
+			{name: "missing key", src: "let {a, b} = {a: 1};", want: `cannot destructure {"a": 1} with {a, b}: missing key "b"`},
+			{name: "nested", src: "let [a, [b]] = [1, 2];", want: "cannot destructure [1, 2] with [a, [b]]: element 1: want a list, got 2"},
+			{
+				name: "parameter",
+				src:  "fn f([a]) { a } f([]);",
+				want: "function f: cannot destructure argument 1 [] with [a]: want 1 element, got 0",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				interp := New("", []byte(tc.src))
+				So(func() { interp.Interpret() }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+}
+
+func TestFunc_CallNamed(t *testing.T) {
+	const f = "fn f(a, b = a * 10, ...rest) { format(\"{} {} {}\", a, b, rest) }\n"
+
+	Convey("valid", t, func() {
+		testCases := []struct {
+			call string
+			want string
+		}{
+			{call: "f(1)", want: "1 10 []"},
+			{call: "f(1, 2)", want: "1 2 []"},
+			{call: "f(1, 2, 3, 4)", want: "1 2 [3, 4]"},
+			{call: "f(b: 5, a: 6)", want: "6 5 []"},
+			{call: "f(7, b: 8)", want: "7 8 []"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.call, func() {
+				interp := New("", []byte(f+"let x = "+tc.call+";"))
+				interp.Interpret()
+				v, _ := interp.env.Lookup("x")
+				So(v, ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("arity errors", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "fn g(x, y) { x } g(1);", want: "function g: missing argument for parameter y"},
+			{src: "fn g(x) { x } g(1, 2);", want: "function g takes 1 positional arguments but 2 are provided"},
+			{src: "fn g(x) { x } g(y: 1);", want: "function g: unexpected named argument y"},
+			{src: "fn g(x) { x } g(1, x: 2);", want: "function g: parameter x is given more than one argument"},
+			{src: "println(a: 1);", want: "function println does not take named arguments"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				interp := New("", []byte(tc.src))
+				So(func() { interp.Interpret() }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+}
+
+func TestInterpreter_VisitReturnStmt(t *testing.T) {
+	Convey("completions", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{
+				name: "from a loop",
+				src: `fn first(n) {
+    for k in 0..10 {
+        while true { if k * k > n { return k; } break; }
+    }
+}
+println(first(20));`,
+				want: "5\n",
+			},
+			{
+				name: "from a match arm",
+				src:  `fn m(x) { match x { 1 => { return "one"; }, _ => "other" } } println(m(1), m(2));`,
+				want: "one other\n",
+			},
+			{
+				name: "from inside an expression",
+				src:  `fn f() { [1, 2 + { return 5; }] } println(f());`,
+				want: "5\n",
+			},
+			{
+				name: "through finally",
+				src:  `fn f() { try { return 1; } finally { print("finally "); } } println(f());`,
+				want: "finally 1\n",
+			},
+			{
+				name: "at the top level",
+				src:  `println(1); return nil; println(2);`,
+				want: "1\n",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				So(output(tc.src), ShouldEqual, tc.want)
+			})
+		}
+	})
+}
+
+// constant is a builtin that returns v.
+type constant struct {
+	v any
+}
+
+func (c constant) Call(args []any, i *Interpreter) any {
+	return c.v
+}
+
+func TestInterpreter_call(t *testing.T) {
+	Convey("tail calls", t, func() {
+		So(output(`fn count(n, acc) { if n == 0 { acc } else { count(n - 1, acc + 1) } }
+fn down(n) { if n == 0 { return "done"; } return down(n - 1); }
+fn even(n) { if n == 0 { true } else { odd(n - 1) } }
+fn odd(n) { if n == 0 { false } else { even(n - 1) } }
+println(count(50000, 0), down(50000), even(50001));`), ShouldEqual, "50000 done false\n")
+	})
+
+	Convey("tail calls in try statements", t, func() {
+		So(output(`fn g() { throw "boom"; }
+fn f() { try { return g(); } catch e { return e.message; } }
+println(f());`), ShouldEqual, "boom\n")
+	})
+
+	Convey("calls returned at the top level", t, func() {
+		So(output(`fn main() { println("main ran"); 0 } return main();`), ShouldEqual, "main ran\n")
+		interp := New("main.nv", []byte("fn f() { 42 } return f();"))
+		So(interp.Eval(), ShouldResemble, big.NewInt(42))
+	})
+
+	Convey("stack overflow", t, func() {
+		interp := New("main.nv", []byte(`fn depth(n) { 1 + depth(n + 1) }
+let msg = nil;
+let frames = nil;
+try { depth(0); } catch e { msg = e.message; frames = e.stack; }
+let after = depth;`))
+		interp.MaxCallDepth = 100
+		interp.Interpret()
+		msg, _ := interp.env.Lookup("msg")
+		So(msg, ShouldEqual, "stack overflow: more than 100 calls in progress")
+		frames, _ := interp.env.Lookup("frames")
+		So(frames.(*List).Elems, ShouldHaveLength, overflowFrames)
+		So(frames.(*List).Elems[0], ShouldEqual, "depth (main.nv:1:19)")
+		So(interp.frames, ShouldBeEmpty)
+
+		var r any
+		func() {
+			defer func() {
+				r = recover()
+			}()
+			interp := New("main.nv", []byte(`fn depth(n) { 1 + depth(n + 1) } depth(0);`))
+			interp.MaxCallDepth = 20
+			interp.Interpret()
+		}()
+		So(r.(*Error).Elided, ShouldEqual, 21-overflowFrames)
+		So(r.(*Error).Trace(), ShouldEndWith, fmt.Sprintf("\t... %d more\n", 21-overflowFrames))
+	})
+
+	Convey("the Go stack holds MaxStackDepth calls", t, func() {
+		defer debug.SetMaxStack(debug.SetMaxStack(MaxStack))
+		interp := New("main.nv", []byte(fmt.Sprintf(`fn depth(n) { if n == 0 { 0 } else { 1 + depth(n - 1) } }
+let msg = nil;
+try { depth(%d); } catch e { msg = e.message; }
+depth(%d);`, MaxStackDepth, MaxStackDepth-1)))
+		interp.MaxCallDepth = MaxStackDepth
+		So(interp.Eval(), ShouldResemble, big.NewInt(MaxStackDepth-1))
+		msg, _ := interp.env.Lookup("msg")
+		So(msg, ShouldEqual, fmt.Sprintf("stack overflow: more than %d calls in progress", MaxStackDepth))
+	})
+
+	Convey("interpreters calling the same function at once use their own builtins", t, func() {
+		owner := New("main.nv", []byte("fn f(n) { if n > 0 { name() + f(n - 1) } else { 0 } } f;"))
+		owner.SetBuiltin("name", constant{nil})
+		f := owner.Eval().(*Func)
+		interps := make([]*Interpreter, 4)
+		for k := range interps {
+			interps[k] = Default()
+			interps[k].SetBuiltin("name", constant{big.NewInt(int64(k))})
+		}
+		results := make([]any, len(interps))
+		var wg sync.WaitGroup
+		for k, interp := range interps {
+			wg.Add(1)
+			go func(k int, interp *Interpreter) {
+				defer wg.Done()
+				results[k] = f.Call([]any{big.NewInt(100)}, interp)
+			}(k, interp)
+		}
+		wg.Wait()
+		for k, v := range results {
+			So(v.(*big.Int).Int64(), ShouldEqual, 100*k)
+		}
+	})
+}
+
+func TestInterpreter_VisitAssignStmt(t *testing.T) {
+	Convey("constants", t, func() {
+		interp := Default()
+		interp.P = parser.New(nil, []byte("const x = 1; const [a, b] = [2, 3]; let y = 4;"))
+		interp.Interpret()
+
+		// The REPL resolves each line in the scope left by the previous ones.
+		interp.P = parser.New(nil, []byte("y = 5; { let x = 6; x = 7; }"))
+		interp.Interpret()
+		v, _ := interp.env.Lookup("y")
+		So(v, ShouldResemble, big.NewInt(5))
+
+		interp.P = parser.New(nil, []byte("x = 8;"))
+		So(func() { interp.Interpret() }, ShouldPanicWith,
+			"<unknown>:1:1: cannot assign to constant x declared at <unknown>:1:1")
+		interp.P = parser.New(nil, []byte("b = 9;"))
+		So(func() { interp.Interpret() }, ShouldPanicWith,
+			"<unknown>:1:1: cannot assign to constant b declared at <unknown>:1:14")
+
+		interp.P = parser.New(nil, []byte("let x = 10; x = 11;"))
+		interp.Interpret()
+		v, _ = interp.env.Lookup("x")
+		So(v, ShouldResemble, big.NewInt(11))
+	})
+}
+
+func TestInterpreter_stdio(t *testing.T) {
+	Convey("getline reads lines from Stdin", t, func() {
+		var out strings.Builder
+		interp := New("main.nv", []byte(`let a = getline(); let b = getline();
+println(a, b, getline(), getline() == "");`))
+		interp.Stdin = strings.NewReader("a\nb\r\nc")
+		interp.Stdout = &out
+		interp.Interpret()
+		So(out.String(), ShouldEqual, "a b c true\n")
+	})
+
+	Convey("getline shares buffered readers with the host", t, func() {
+		in := bufio.NewReader(strings.NewReader("first\nsecond\nthird\n"))
+		line, _ := ReadLine(in)
+		So(line, ShouldEqual, "first")
+
+		var out strings.Builder
+		interp := New("main.nv", []byte("print(getline());"))
+		interp.Stdin, interp.Stdout = in, &out
+		interp.Interpret()
+		So(out.String(), ShouldEqual, "second")
+
+		line, ok := ReadLine(in)
+		So(line, ShouldEqual, "third")
+		So(ok, ShouldBeTrue)
+		_, ok = ReadLine(in)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("warnings go to Stderr", t, func() {
+		var out, errs strings.Builder
+		interp := New("main.nv", []byte("let x = 1; let x = 2; print(x);"))
+		interp.Redeclarations = true
+		interp.Stdout, interp.Stderr = &out, &errs
+		interp.Interpret()
+		So(out.String(), ShouldEqual, "2")
+		So(errs.String(), ShouldStartWith, "warning: ")
+	})
+}
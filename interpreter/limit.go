@@ -0,0 +1,150 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"time"
+
+	"naive/token"
+)
+
+// Limit is a limit on the resources code may use.
+type Limit int
+
+const (
+	// StepLimit is Limits.MaxSteps.
+	StepLimit Limit = iota + 1
+	// AllocLimit is Limits.MaxAlloc.
+	AllocLimit
+	// TimeLimit is Limits.Deadline.
+	TimeLimit
+	// Cancellation is the end of Limits.Context.
+	Cancellation
+)
+
+// LimitError is raised when code exceeds the Limits of the engine that runs
+// it. Unlike an Error, try statements do not catch it and finally blocks do
+// not run, so the code stops running at once.
+type LimitError struct {
+	Limit Limit
+	// Max is the value of the limit exceeded, for StepLimit and AllocLimit.
+	Max int
+	// Err is the error of the context, for Cancellation.
+	Err error
+	// Stack holds the calls in progress when the limit was exceeded,
+	// innermost first.
+	Stack []Frame
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case StepLimit:
+		return fmt.Sprintf("step limit exceeded: more than %d steps", e.Max)
+	case AllocLimit:
+		return fmt.Sprintf("allocation limit exceeded: more than %d bytes allocated", e.Max)
+	case TimeLimit:
+		return "time limit exceeded"
+	default:
+		return "execution cancelled: " + e.Err.Error()
+	}
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Limits limit the resources of the code an engine runs. Code that would
+// exceed them raises a LimitError instead. Zero values set no limit.
+type Limits struct {
+	// MaxSteps is the number of steps, calls and iterations of loops, that
+	// the code may take, and MaxAlloc the number of bytes it may allocate
+	// for numbers and strings, freed or not. They count from the last call of
+	// ResetUsage.
+	MaxSteps int
+	MaxAlloc int
+	// Deadline is when the code must stop running, and Context stops it once
+	// it is done.
+	Deadline time.Time
+	Context  context.Context
+
+	// steps and allocated count what MaxSteps and MaxAlloc limit. nextCheck
+	// is the step at which the limits are checked next.
+	steps, allocated, nextCheck int
+}
+
+// checkInterval is the number of steps between checks of the clock and the
+// context.
+const checkInterval = 1024
+
+// ResetUsage starts counting the steps and the bytes that MaxSteps and
+// MaxAlloc limit from zero.
+func (l *Limits) ResetUsage() {
+	l.steps, l.allocated, l.nextCheck = 0, 0, 0
+}
+
+// Step counts a step of the code run, and returns the error of the limit the
+// code exceeds, if any. The caller records the stack in the error.
+func (l *Limits) Step() *LimitError {
+	l.steps++
+	if l.steps < l.nextCheck {
+		return nil
+	}
+	if l.MaxSteps > 0 && l.steps > l.MaxSteps {
+		return &LimitError{Limit: StepLimit, Max: l.MaxSteps}
+	}
+	if !l.Deadline.IsZero() && !time.Now().Before(l.Deadline) {
+		return &LimitError{Limit: TimeLimit}
+	}
+	if l.Context != nil {
+		if err := l.Context.Err(); err != nil {
+			return &LimitError{Limit: Cancellation, Err: err}
+		}
+	}
+	l.nextCheck = l.steps + checkInterval
+	if l.MaxSteps > 0 && l.nextCheck > l.MaxSteps+1 {
+		l.nextCheck = l.MaxSteps + 1
+	}
+	return nil
+}
+
+// Account counts the bytes held by v, a value computed by the code run,
+// against MaxAlloc, as Step counts steps. Only numbers and strings are
+// counted.
+func (l *Limits) Account(v any) *LimitError {
+	if l.MaxAlloc <= 0 {
+		return nil
+	}
+	switch v := v.(type) {
+	case *big.Int:
+		l.allocated += len(v.Bits()) * bits.UintSize / 8
+	case *big.Float:
+		l.allocated += int(v.Prec()) / 8
+	case string:
+		l.allocated += len(v)
+	default:
+		return nil
+	}
+	if l.allocated > l.MaxAlloc {
+		return &LimitError{Limit: AllocLimit, Max: l.MaxAlloc}
+	}
+	return nil
+}
+
+// step counts a step of the code run, a call or an iteration of a loop made
+// at loc.
+func (i *Interpreter) step(loc token.Location) {
+	if e := i.Step(); e != nil {
+		e.Stack = i.stack(loc)
+		panic(e)
+	}
+}
+
+// account counts the bytes held by v, a value computed by the code run.
+func (i *Interpreter) account(v any) {
+	if e := i.Account(v); e != nil {
+		e.Stack = i.stack(token.Location{})
+		panic(e)
+	}
+}
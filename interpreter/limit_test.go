@@ -0,0 +1,122 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// limited runs src with the limits set by configure, and returns the
+// LimitError it raises, if any.
+func limited(src string, configure func(i *Interpreter)) (err *LimitError) {
+	interp := New("main.nv", []byte(src))
+	interp.Stdout = &strings.Builder{}
+	configure(interp)
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(*LimitError)
+		}
+	}()
+	interp.Interpret()
+	return nil
+}
+
+func TestInterpreter_limits(t *testing.T) {
+	Convey("steps", t, func() {
+		steps := func(i *Interpreter) { i.MaxSteps = 100 }
+
+		Convey("are loop iterations and calls", func() {
+			So(limited("let n = 0; while n < 50 { n = n + 1; }", steps), ShouldBeNil)
+			So(limited("for x in 0..99 { }", steps), ShouldBeNil)
+			So(limited("fn f(n) { if n > 0 { f(n - 1) } } f(99);", steps), ShouldBeNil)
+		})
+
+		Convey("beyond MaxSteps raise a LimitError", func() {
+			testCases := []struct {
+				name string
+				src  string
+			}{
+				{name: "while", src: "while true { }"},
+				{name: "for", src: "for x in 0..1000 { }"},
+				{name: "recursion", src: "fn f(n) { if n > 0 { 1 + f(n - 1) } else { 0 } } f(200);"},
+				{name: "tail calls", src: "fn f() { f() } f();"},
+			}
+			for _, tc := range testCases {
+				Convey(tc.name, func() {
+					err := limited(tc.src, steps)
+					So(err, ShouldNotBeNil)
+					So(err.Limit, ShouldEqual, StepLimit)
+					So(err.Error(), ShouldEqual, "step limit exceeded: more than 100 steps")
+				})
+			}
+		})
+
+		Convey("are counted anew by every Eval", func() {
+			interp := Default()
+			interp.MaxSteps = 100
+			for k := 0; k < 3; k++ {
+				interp.SetSource("", []byte("for x in 0..50 { }"))
+				So(func() { interp.Interpret() }, ShouldNotPanic)
+			}
+		})
+	})
+
+	Convey("try statements do not catch LimitErrors", t, func() {
+		steps := func(i *Interpreter) { i.MaxSteps = 100 }
+		err := limited(`fn f() {
+    try { while true { } } catch e { println(e); } finally { return 1; }
+}
+while true { f(); }`, steps)
+		So(err, ShouldNotBeNil)
+		So(err.Limit, ShouldEqual, StepLimit)
+	})
+
+	Convey("allocations beyond MaxAlloc raise a LimitError", t, func() {
+		alloc := func(i *Interpreter) { i.MaxAlloc = 1000 }
+		So(limited("let x = 1; for k in 0..10 { x = x * 2; }", alloc), ShouldBeNil)
+
+		err := limited("let x = 2; while true { x = x * x; }", alloc)
+		So(err, ShouldNotBeNil)
+		So(err.Limit, ShouldEqual, AllocLimit)
+		So(err.Error(), ShouldEqual, "allocation limit exceeded: more than 1000 bytes allocated")
+
+		err = limited(`let s = "0123456789"; while true { s = format("{}{}", s, s); }`, alloc)
+		So(err, ShouldNotBeNil)
+		So(err.Limit, ShouldEqual, AllocLimit)
+	})
+
+	Convey("code past Deadline raises a LimitError", t, func() {
+		err := limited("while true { }", func(i *Interpreter) {
+			i.Deadline = time.Now().Add(10 * time.Millisecond)
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Limit, ShouldEqual, TimeLimit)
+		So(err.Error(), ShouldEqual, "time limit exceeded")
+	})
+
+	Convey("code run once Context is done raises a LimitError", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err := limited("fn f() { } while true { f(); }", func(i *Interpreter) {
+			i.Context = ctx
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Limit, ShouldEqual, Cancellation)
+		So(err.Error(), ShouldEqual, "execution cancelled: context canceled")
+		So(errors.Is(err, context.Canceled), ShouldBeTrue)
+	})
+
+	Convey("LimitErrors record the calls in progress", t, func() {
+		err := limited("fn spin() { while true { } }\nspin();", func(i *Interpreter) {
+			i.MaxSteps = 10
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Stack, ShouldHaveLength, 2)
+		So(err.Stack[0].Func, ShouldEqual, "spin")
+		So(err.Stack[1].String(), ShouldEqual, "<main> (main.nv:2:1)")
+	})
+}
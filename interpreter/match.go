@@ -0,0 +1,109 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"naive/ast"
+	"naive/token"
+)
+
+func (i *Interpreter) VisitMatchExpr(expr *ast.MatchExpr) any {
+	v := expr.Value.Accept(i)
+	if abrupt(v) {
+		return v
+	}
+	outer := i.env
+	for _, arm := range expr.Arms {
+		i.env = newLocalEnv(outer)
+		if !i.bind(arm.Pattern, v) {
+			continue
+		}
+		if arm.Guard != nil {
+			g := arm.Guard.Accept(i)
+			if abrupt(g) {
+				i.env = outer
+				return g
+			}
+			if isFalsy(g) {
+				continue
+			}
+		}
+		ans := arm.Body.Accept(i)
+		i.env = outer
+		return ans
+	}
+	i.env = outer
+	i.at = token.Location{}
+	panic("no match arm matches value " + repr(v))
+}
+
+// bind reports whether v matches pat, defining the variables pat binds in
+// the current environment as Match does.
+func (i *Interpreter) bind(pat ast.Pattern, v any) bool {
+	return Match(pat, v, i.env.Define)
+}
+
+// Mismatch explains why v does not match pat. It returns "" if it does.
+func Mismatch(pat ast.Pattern, v any) string {
+	switch pat := pat.(type) {
+	case *ast.LiteralPattern:
+		if !doEq(literal(pat.Value), v) {
+			return fmt.Sprintf("want %s, got %s", pat, repr(v))
+		}
+	case *ast.RangePattern:
+		if !inRange(v, literal(pat.Start), literal(pat.End), pat.Inclusive) {
+			return fmt.Sprintf("want a value in %s, got %s", pat, repr(v))
+		}
+	case *ast.ListPattern:
+		l, ok := v.(*List)
+		if !ok {
+			return "want a list, got " + repr(v)
+		}
+		if !pat.HasRest && len(l.Elems) != len(pat.Elems) {
+			return fmt.Sprintf("want %s, got %d", elements(len(pat.Elems)), len(l.Elems))
+		}
+		if len(l.Elems) < len(pat.Elems) {
+			return fmt.Sprintf("want at least %s, got %d", elements(len(pat.Elems)), len(l.Elems))
+		}
+		for j, e := range pat.Elems {
+			if why := Mismatch(e, l.Elems[j]); why != "" {
+				return fmt.Sprintf("element %d: %s", j, why)
+			}
+		}
+	case *ast.RecordPattern:
+		m, ok := v.(*Map)
+		if !ok {
+			return "want a map, got " + repr(v)
+		}
+		for j, k := range pat.Keys {
+			x, present := m.Get(k)
+			if !present {
+				return fmt.Sprintf("missing key %q", k)
+			}
+			if why := Mismatch(pat.Values[j], x); why != "" {
+				return fmt.Sprintf("key %q: %s", k, why)
+			}
+		}
+	}
+	return ""
+}
+
+func elements(n int) string {
+	if n == 1 {
+		return "1 element"
+	}
+	return fmt.Sprintf("%d elements", n)
+}
+
+// inRange reports whether v is a number or character between start and end.
+func inRange(v, start, end any, inclusive bool) bool {
+	if r, ok := v.(rune); ok {
+		lo, ok1 := start.(rune)
+		hi, ok2 := end.(rune)
+		return ok1 && ok2 && lo <= r && (r < hi || inclusive && r == hi)
+	}
+	if !isNumber(v) || !isNumber(start) || !isNumber(end) {
+		return false
+	}
+	return doGe(v, start).(bool) && (doLt(v, end).(bool) || inclusive && doEq(v, end))
+}
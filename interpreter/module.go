@@ -0,0 +1,198 @@
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"naive/ast"
+	"naive/parser"
+	"naive/token"
+)
+
+// ModuleExt is the extension of Naive source files, which may be left out of
+// import paths.
+const ModuleExt = ".nv"
+
+// Module is the runtime representation of an imported file. Its members are
+// the public top-level let and fn bindings of the file: those whose names do
+// not start with an underscore.
+type Module struct {
+	Name string
+	Path string
+
+	// lookup looks up the top-level bindings of the file by name.
+	lookup  func(name string) (v any, present bool)
+	exports map[string]bool
+}
+
+// NewModule returns the module of the file at path, an absolute path, whose
+// public top-level bindings are called exports, as given by Exports. lookup
+// looks up the top-level bindings of the file, once it has run, by name.
+func NewModule(path string, exports []string, lookup func(name string) (v any, present bool)) *Module {
+	m := &Module{
+		Name:    strings.TrimSuffix(filepath.Base(path), ModuleExt),
+		Path:    path,
+		lookup:  lookup,
+		exports: make(map[string]bool, len(exports)),
+	}
+	for _, name := range exports {
+		m.exports[name] = true
+	}
+	return m
+}
+
+// IsPublic reports whether a top-level binding called name is visible to the
+// importers of its module.
+func IsPublic(name string) bool {
+	return !strings.HasPrefix(name, "_")
+}
+
+// Lookup returns the value of the public member name.
+func (m *Module) Lookup(name string) (v any, present bool) {
+	if !m.exports[name] {
+		return nil, false
+	}
+	return m.lookup(name)
+}
+
+// Check returns why name cannot be looked up in m, or "" if it can.
+func (m *Module) Check(name string) string {
+	if m.exports[name] {
+		return ""
+	}
+	if _, present := m.lookup(name); present && !IsPublic(name) {
+		return fmt.Sprintf("%s is private to module %s", name, m.Name)
+	}
+	return fmt.Sprintf("module %s has no member %s", m.Name, name)
+}
+
+func (m *Module) String() string {
+	return "<module " + m.Name + ">"
+}
+
+func (i *Interpreter) VisitImportStmt(stmt *ast.ImportStmt) any {
+	i.at = stmt.Loc
+	AllowImport(stmt.Loc, stmt.Path, i.caps)
+	m := i.load(FindModule(stmt.Loc, i.file, i.SearchPath, stmt.Path))
+	if len(stmt.Names) == 0 {
+		i.env.Define(stmt.Slot, stmt.Name(), m)
+		return nil
+	}
+	// Check every name before binding any, so that a failed import binds
+	// nothing.
+	for _, n := range stmt.Names {
+		if msg := m.Check(n.Name); msg != "" {
+			i.at = n.Loc
+			panic(fmt.Sprintf("%s: %s", n.Loc, msg))
+		}
+	}
+	for _, n := range stmt.Names {
+		v, _ := m.Lookup(n.Name)
+		i.env.Define(n.Slot, n.Binding(), v)
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitMemberExpr(expr *ast.MemberExpr) any {
+	x := expr.X.Accept(i)
+	if abrupt(x) {
+		return x
+	}
+	i.at = expr.Loc
+	if m, ok := x.(*Module); ok {
+		if msg := m.Check(expr.Name); msg != "" {
+			panic(fmt.Sprintf("%s: %s", expr.Loc, msg))
+		}
+	}
+	return Member(x, expr.Name)
+}
+
+// FindModule returns the absolute path of the file imported by path at loc in
+// the file from. Relative paths are looked up in the directory of the
+// importing file first, and then in the directories of searchPath.
+func FindModule(loc token.Location, from string, searchPath []string, path string) string {
+	if filepath.Ext(path) != ModuleExt {
+		path += ModuleExt
+	}
+	path = filepath.FromSlash(path)
+
+	dirs := []string{""}
+	if !filepath.IsAbs(path) {
+		dirs = append([]string{filepath.Dir(from)}, searchPath...)
+	}
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, path)
+		if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() {
+			if abs, err := filepath.Abs(candidate); err == nil {
+				return abs
+			}
+		}
+	}
+	if filepath.IsAbs(path) {
+		panic(fmt.Sprintf("%s: cannot find module %s", loc, path))
+	}
+	panic(fmt.Sprintf("%s: cannot find module %s in %s", loc, path, strings.Join(dirs, string(filepath.ListSeparator))))
+}
+
+// load runs the file at path, an absolute path, as a module. A module is run
+// only once, however many times it is imported.
+func (i *Interpreter) load(path string) *Module {
+	if m, ok := i.modules[path]; ok {
+		return m
+	}
+	for j, p := range i.loading {
+		if p == path {
+			cycle := append(append([]string(nil), i.loading[j:]...), path)
+			panic("import cycle: " + strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load module %s: %v", path, err))
+	}
+	p := parser.New(token.NewFile(path), src)
+	p.Parse()
+	p.Statements = i.rewrite(p.Statements)
+	i.check(i.newResolver(), p.Statements)
+
+	oldEnv, oldFile := i.env, i.file
+	i.env, i.file = newLocalEnv(nil), path
+	i.loading = append(i.loading, path)
+	defer func() {
+		i.env, i.file = oldEnv, oldFile
+		i.loading = i.loading[:len(i.loading)-1]
+	}()
+	i.run(p.Statements)
+
+	m := NewModule(path, Exports(p.Statements), i.env.lookup)
+	i.modules[path] = m
+	return m
+}
+
+// Exports returns the public names bound by stmts, the top-level statements
+// of a module.
+func Exports(stmts []ast.Stmt) []string {
+	var names []string
+	add := func(name string) {
+		if IsPublic(name) {
+			names = append(names, name)
+		}
+	}
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStmt:
+			if s.Pattern == nil {
+				add(s.Ident)
+			}
+			for _, name := range ast.Names(s.Pattern) {
+				add(name)
+			}
+		case *ast.FnStmt:
+			add(s.Ident)
+		}
+	}
+	return names
+}
@@ -0,0 +1,123 @@
+package interpreter
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInterpreter_VisitImportStmt(t *testing.T) {
+	Convey("resolution", t, func() {
+		dir := writeFiles(t, map[string]string{
+			"main.nv":        "",
+			"lib/strutil.nv": `let loads = 1; fn greet(name) { format("hello, {}", name) }`,
+			"path/mathx.nv":  "fn sq(x) { x * x } let cfg = {name: \"mathx\"};",
+		})
+		run := func(src string) *Interpreter {
+			interp := New(filepath.Join(dir, "main.nv"), []byte(src))
+			interp.SearchPath = []string{filepath.Join(dir, "path")}
+			interp.Interpret()
+			return interp
+		}
+
+		Convey("relative to the importing file", func() {
+			interp := run(`import "lib/strutil"; let x = strutil.greet("naive");`)
+			v, _ := interp.env.Lookup("x")
+			So(v, ShouldEqual, "hello, naive")
+		})
+
+		Convey("search path and alias", func() {
+			interp := run(`import mathx as m; let x = format("{} {}", m.sq(4), m.cfg.name);`)
+			v, _ := interp.env.Lookup("x")
+			So(v, ShouldEqual, "16 mathx")
+		})
+
+		Convey("loaded once", func() {
+			interp := run(`import "lib/strutil"; import "lib/strutil.nv" as s; let x = strutil == s;`)
+			v, _ := interp.env.Lookup("x")
+			So(v, ShouldBeTrue)
+		})
+
+		Convey("not found", func() {
+			So(func() { run(`import nowhere;`) }, ShouldPanic)
+		})
+
+		Convey("no such member", func() {
+			So(func() { run(`import mathx; mathx.cube(2);`) }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":1:20: module mathx has no member cube")
+		})
+	})
+
+	Convey("export control", t, func() {
+		dir := writeFiles(t, map[string]string{
+			"main.nv": "",
+			"shapes.nv": `import mathx;
+let _unit = 1;
+let [w, _h] = [2, 3];
+fn _sq(x) { x * x }
+fn area(r) { 3 * _sq(r) * _unit }`,
+			"mathx.nv": "fn sq(x) { x * x }",
+		})
+		run := func(src string) *Interpreter {
+			interp := New(filepath.Join(dir, "main.nv"), []byte(src))
+			interp.Interpret()
+			return interp
+		}
+
+		Convey("public names", func() {
+			interp := run(`import {area, w as width} from shapes; let x = area(2) + width;`)
+			v, _ := interp.env.Lookup("x")
+			So(v, ShouldResemble, big.NewInt(14))
+		})
+
+		Convey("private names", func() {
+			So(func() { run(`import shapes; shapes._sq(2);`) }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":1:22: _sq is private to module shapes")
+			So(func() { run("import {area,\n  _unit} from shapes;") }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":2:3: _unit is private to module shapes")
+			So(func() { run(`import {_h} from shapes;`) }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":1:9: _h is private to module shapes")
+		})
+
+		Convey("missing names", func() {
+			So(func() { run(`import {area, volume} from shapes;`) }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":1:15: module shapes has no member volume")
+		})
+
+		Convey("imports are not re-exported", func() {
+			So(func() { run(`import shapes; shapes.mathx;`) }, ShouldPanicWith,
+				filepath.Join(dir, "main.nv")+":1:22: module shapes has no member mathx")
+		})
+	})
+
+	Convey("cycle", t, func() {
+		dir := writeFiles(t, map[string]string{
+			"a.nv": "import b;",
+			"b.nv": "import c;",
+			"c.nv": "import a;",
+		})
+		path := filepath.Join(dir, "a.nv")
+		src, _ := os.ReadFile(path)
+		interp := New(path, src)
+		want := "import cycle: " + path + " -> " + filepath.Join(dir, "b.nv") + " -> " +
+			filepath.Join(dir, "c.nv") + " -> " + path
+		So(func() { interp.Interpret() }, ShouldPanicWith, want)
+	})
+}
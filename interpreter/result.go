@@ -0,0 +1,124 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"naive/ast"
+	"naive/token"
+)
+
+// Result is the runtime representation of ok(v) and err(e), the values of
+// computations that may fail in an expected way.
+type Result struct {
+	Ok bool
+	// Value is v for ok(v) and e for err(e).
+	Value any
+}
+
+func (r *Result) String() string {
+	if r.Ok {
+		return "ok(" + repr(r.Value) + ")"
+	}
+	return "err(" + repr(r.Value) + ")"
+}
+
+func (r *Result) member(name string) (any, bool) {
+	switch name {
+	case "value":
+		if r.Ok {
+			return r.Value, true
+		}
+		return nil, true
+	case "error":
+		if !r.Ok {
+			return r.Value, true
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+func (i *Interpreter) VisitPropagateExpr(expr *ast.PropagateExpr) any {
+	v := expr.X.Accept(i)
+	if abrupt(v) {
+		return v
+	}
+	r, ok := v.(*Result)
+	if !ok {
+		i.at = token.Location{}
+		panic(fmt.Sprintf("type mismatch: the ? operator wants ok(v) or err(e), got %s", repr(v)))
+	}
+	if !r.Ok {
+		return &Completion{Kind: token.KindReturn, Value: r}
+	}
+	return r.Value
+}
+
+// toResult returns args[0] of the builtin name, which must be a Result.
+func toResult(name string, args []any, n int) *Result {
+	checkArity(name, args, n)
+	r, ok := args[0].(*Result)
+	if !ok {
+		panic(fmt.Sprintf("type mismatch: 1st argument of function %s shall be ok(v) or err(e), got %s",
+			name, repr(args[0])))
+	}
+	return r
+}
+
+func checkArity(name string, args []any, n int) {
+	if len(args) != n {
+		panic(fmt.Sprintf("function %s takes %d arguments, but %d are provided", name, n, len(args)))
+	}
+}
+
+type BuiltinOk struct{}
+
+func (BuiltinOk) Call(args []any, i *Interpreter) any {
+	checkArity("ok", args, 1)
+	return &Result{Ok: true, Value: args[0]}
+}
+
+type BuiltinErr struct{}
+
+func (BuiltinErr) Call(args []any, i *Interpreter) any {
+	checkArity("err", args, 1)
+	return &Result{Ok: false, Value: args[0]}
+}
+
+type BuiltinIsOk struct{}
+
+func (BuiltinIsOk) Call(args []any, i *Interpreter) any {
+	return toResult("ok?", args, 1).Ok
+}
+
+type BuiltinIsErr struct{}
+
+func (BuiltinIsErr) Call(args []any, i *Interpreter) any {
+	return !toResult("err?", args, 1).Ok
+}
+
+// BuiltinUnwrap returns v for ok(v), and throws for err(e): e itself if it is
+// an error value, or else an error caused by e.
+type BuiltinUnwrap struct{}
+
+func (BuiltinUnwrap) Call(args []any, i *Interpreter) any {
+	r := toResult("unwrap", args, 1)
+	if r.Ok {
+		return r.Value
+	}
+	e, ok := r.Value.(*Error)
+	if !ok {
+		e = &Error{Message: "unwrap of " + r.String(), Cause: r.Value}
+	}
+	return Throw(e, i)
+}
+
+type BuiltinUnwrapOr struct{}
+
+func (BuiltinUnwrapOr) Call(args []any, i *Interpreter) any {
+	r := toResult("unwrap_or", args, 2)
+	if r.Ok {
+		return r.Value
+	}
+	return args[1]
+}
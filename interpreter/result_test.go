@@ -0,0 +1,46 @@
+package interpreter
+
+import (
+	"math/big"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInterpreter_VisitPropagateExpr(t *testing.T) {
+	src := `fn parse(s) {
+    if s == "1" { return ok(1); }
+    err(format("cannot parse {}", s))
+}
+fn sum(a, b) {
+    let x = parse(a)?;
+    ok(x + parse(b)?)
+}
+let good = sum("1", "1");
+let bad = sum("x", "1");
+let bad2 = sum("1", "y");
+let flags = [ok?(good), err?(good), ok?(bad), err?(bad)];
+let dflt = unwrap_or(bad, 0);
+let msg = nil;
+try { unwrap(bad); } catch e { msg = e.message; }`
+
+	Convey("propagation", t, func() {
+		interp := New("", []byte(src))
+		interp.Interpret()
+		lookup := func(name string) any {
+			v, _ := interp.env.Lookup(name)
+			return v
+		}
+		So(lookup("good"), ShouldResemble, &Result{Ok: true, Value: big.NewInt(2)})
+		So(lookup("bad"), ShouldResemble, &Result{Ok: false, Value: "cannot parse x"})
+		So(lookup("bad2"), ShouldResemble, &Result{Ok: false, Value: "cannot parse y"})
+		So(lookup("flags").(*List).Elems, ShouldResemble, []any{true, false, false, true})
+		So(lookup("dflt"), ShouldResemble, big.NewInt(0))
+		So(lookup("msg"), ShouldEqual, `unwrap of err("cannot parse x")`)
+	})
+
+	Convey("not a result", t, func() {
+		interp := New("", []byte(`fn f(x) { x? } f(1);`))
+		So(func() { interp.Interpret() }, ShouldPanicWith, "type mismatch: the ? operator wants ok(v) or err(e), got 1")
+	})
+}
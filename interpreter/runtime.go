@@ -0,0 +1,293 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+
+	"naive/ast"
+	"naive/token"
+)
+
+// The functions of this file make up the runtime shared by the interpreter
+// and the other engines running Naive code, such as package vm, so that they
+// compute the same values and raise the same errors.
+
+// Builtins returns the builtin functions by their names.
+func Builtins() map[string]any {
+	return map[string]any{
+		"print":     BuiltinPrint{},
+		"println":   BuiltinPrintLn{},
+		"format":    BuiltinFormat{},
+		"getline":   BuiltinGetLine{},
+		"error":     BuiltinError{},
+		"ok":        BuiltinOk{},
+		"err":       BuiltinErr{},
+		"ok?":       BuiltinIsOk{},
+		"err?":      BuiltinIsErr{},
+		"unwrap":    BuiltinUnwrap{},
+		"unwrap_or": BuiltinUnwrapOr{},
+	}
+}
+
+// Binary returns the value of `lhs op rhs`.
+func Binary(op token.Kind, lhs, rhs any) any {
+	switch op {
+	case token.KindAdd:
+		return doAdd(lhs, rhs)
+	case token.KindSub:
+		return doSub(lhs, rhs)
+	case token.KindMul:
+		return doMul(lhs, rhs)
+	case token.KindDiv:
+		return doDiv(lhs, rhs)
+	case token.KindMod:
+		return doMod(lhs, rhs)
+
+	case token.KindEq:
+		return doEq(lhs, rhs)
+	case token.KindNe:
+		return doNe(lhs, rhs)
+	case token.KindGt:
+		return doGt(lhs, rhs)
+	case token.KindGe:
+		return doGe(lhs, rhs)
+	case token.KindLt:
+		return doLt(lhs, rhs)
+	case token.KindLe:
+		return doLe(lhs, rhs)
+
+	case token.KindAnd:
+		return doLogicalAnd(lhs, rhs)
+	case token.KindOr:
+		return doLogicalOr(lhs, rhs)
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// Unary returns the value of `op x`.
+func Unary(op token.Kind, x any) any {
+	switch op {
+	case token.KindSub:
+		return doNeg(x)
+	case token.KindNot:
+		return doLogicalNot(x)
+	default:
+		panic("unreachable")
+	}
+}
+
+// Truthy reports whether v counts as true in a condition: anything but false
+// and nil does.
+func Truthy(v any) bool {
+	return isTruthy(v)
+}
+
+// Display returns the textual form of v as printed by print and println.
+func Display(v any) string {
+	return display(v)
+}
+
+// Repr returns the textual form of v as it appears inside lists and maps.
+func Repr(v any) string {
+	return repr(v)
+}
+
+// NewRange returns the range from start to end with step 1.
+func NewRange(start, end any, inclusive bool) *Range {
+	return &Range{
+		Start:     rangeBound("start", start),
+		End:       rangeBound("end", end),
+		Step:      big.NewInt(1),
+		Inclusive: inclusive,
+	}
+}
+
+// SetStep sets the step of r, which must be a nonzero integer.
+func (r *Range) SetStep(step any) {
+	r.Step = rangeBound("step", step)
+	if r.Step.Sign() == 0 {
+		panic("range step cannot be zero")
+	}
+}
+
+// Iterate returns an Iterator over v. Besides Iterable values, strings yield
+// their characters, and a callable is a user-defined iterator: it is called
+// by i without arguments until it returns nil.
+func Iterate(v any, i *Interpreter) Iterator {
+	switch x := v.(type) {
+	case Iterable:
+		return x.Iter()
+	case string:
+		return &stringIterator{s: x}
+	case Callable:
+		return &callIterator{f: x, i: i}
+	default:
+		panic(fmt.Sprintf("type mismatch: %s is not iterable", repr(v)))
+	}
+}
+
+// Member returns the value of `x.name`.
+func Member(x any, name string) any {
+	switch x := x.(type) {
+	case *Module:
+		v, ok := x.Lookup(name)
+		if !ok {
+			panic(x.Check(name))
+		}
+		return v
+	case *Error:
+		v, ok := x.member(name)
+		if !ok {
+			panic(fmt.Sprintf("error has no member %s", name))
+		}
+		return v
+	case *Result:
+		v, ok := x.member(name)
+		if !ok {
+			panic(fmt.Sprintf("result has no member %s", name))
+		}
+		return v
+	case *Map:
+		v, ok := x.Get(name)
+		if !ok {
+			panic(fmt.Sprintf("map has no key %q", name))
+		}
+		return v
+	default:
+		panic(fmt.Sprintf("type mismatch: %s has no members", repr(x)))
+	}
+}
+
+// Match reports whether v matches pat. It calls define with the slot, name
+// and value of every variable pat binds as matching goes, so the variables may
+// be left partially defined if it fails.
+func Match(pat ast.Pattern, v any, define func(slot int, name string, v any)) bool {
+	switch pat := pat.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.BindingPattern:
+		define(pat.Slot, pat.Ident, v)
+		return true
+	case *ast.LiteralPattern:
+		return doEq(literal(pat.Value), v)
+	case *ast.RangePattern:
+		return inRange(v, literal(pat.Start), literal(pat.End), pat.Inclusive)
+	case *ast.ListPattern:
+		l, ok := v.(*List)
+		if !ok || len(l.Elems) < len(pat.Elems) || !pat.HasRest && len(l.Elems) != len(pat.Elems) {
+			return false
+		}
+		for j, e := range pat.Elems {
+			if !Match(e, l.Elems[j], define) {
+				return false
+			}
+		}
+		if pat.Rest != "" {
+			rest := append([]any(nil), l.Elems[len(pat.Elems):]...)
+			define(pat.RestSlot, pat.Rest, &List{Elems: rest})
+		}
+		return true
+	case *ast.RecordPattern:
+		m, ok := v.(*Map)
+		if !ok {
+			return false
+		}
+		for j, k := range pat.Keys {
+			x, present := m.Get(k)
+			if !present || !Match(pat.Values[j], x, define) {
+				return false
+			}
+		}
+		return true
+	default:
+		panic("unreachable")
+	}
+}
+
+// literal returns the value of e, a literal of a pattern.
+func literal(e ast.Expr) any {
+	return e.Accept(&Interpreter{})
+}
+
+// Thrown returns the error value raised by `throw v`: v itself if it is an
+// error value, or else an error carrying v.
+func Thrown(v any) *Error {
+	e, ok := v.(*Error)
+	if !ok {
+		e = &Error{Message: display(v), Value: v}
+	}
+	return e
+}
+
+// StackOverflow returns the error raised by a call beyond the maximum depth
+// max, where stack is the stack trace of the call.
+func StackOverflow(max int, stack []Frame) *Error {
+	e := &Error{
+		Message: fmt.Sprintf("stack overflow: more than %d calls in progress", max),
+		Stack:   stack,
+	}
+	if n := len(e.Stack); n > overflowFrames {
+		e.Stack, e.Elided = e.Stack[:overflowFrames], n-overflowFrames
+	}
+	return e
+}
+
+// Arrange assigns args to the parameters of the function fn, where names is
+// as in Func.CallNamed. keywords[j] is the name the j-th parameter may be
+// passed by, or "" if it may not, and variadic tells whether the last one
+// collects the remaining positional arguments. given[j] reports whether the
+// j-th parameter gets an argument, which is then vals[j].
+func Arrange(fn string, keywords []string, variadic bool, args []any, names []string) (vals []any, given []bool) {
+	n := len(keywords)
+	vals, given = make([]any, n), make([]bool, n)
+	if variadic {
+		n--
+	}
+
+	nPositional := len(args)
+	if names != nil {
+		nPositional = 0
+		for _, name := range names {
+			if name == "" {
+				nPositional++
+			}
+		}
+	}
+	if nPositional > n && !variadic {
+		panic(fmt.Sprintf(
+			"function %s takes %d positional arguments but %d are provided",
+			fn, n, nPositional))
+	}
+
+	var rest []any
+	for j, a := range args {
+		if names == nil || names[j] == "" {
+			if j < n {
+				vals[j], given[j] = a, true
+			} else {
+				rest = append(rest, a)
+			}
+			continue
+		}
+		k := -1
+		for m, kw := range keywords {
+			if kw != "" && kw == names[j] {
+				k = m
+				break
+			}
+		}
+		if k < 0 {
+			panic(fmt.Sprintf("function %s: unexpected named argument %s", fn, names[j]))
+		}
+		if given[k] {
+			panic(fmt.Sprintf("function %s: parameter %s is given more than one argument", fn, names[j]))
+		}
+		vals[k], given[k] = a, true
+	}
+	if variadic {
+		vals[n], given[n] = &List{Elems: rest}, true
+	}
+	return
+}
@@ -0,0 +1,234 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// List is the runtime representation of list values. Lists are mutable and
+// shared by reference.
+type List struct {
+	Elems []any
+}
+
+func (l *List) Iter() Iterator {
+	return &sliceIterator{elems: l.Elems}
+}
+
+func (l *List) String() string {
+	ss := make([]string, 0, len(l.Elems))
+	for _, e := range l.Elems {
+		ss = append(ss, repr(e))
+	}
+	return "[" + strings.Join(ss, ", ") + "]"
+}
+
+// Map is the runtime representation of map values. Keys are kept in the order
+// they were first inserted, which is also the order of iteration.
+type Map struct {
+	keys   []any
+	values []any
+	index  map[any]int
+}
+
+func NewMap() *Map {
+	return &Map{
+		index: make(map[any]int),
+	}
+}
+
+func (m *Map) Get(k any) (v any, present bool) {
+	j, present := m.index[hashKey(k)]
+	if !present {
+		return nil, false
+	}
+	return m.values[j], true
+}
+
+func (m *Map) Set(k, v any) {
+	h := hashKey(k)
+	if j, present := m.index[h]; present {
+		m.values[j] = v
+		return
+	}
+	m.index[h] = len(m.keys)
+	m.keys = append(m.keys, k)
+	m.values = append(m.values, v)
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Iter iterates over the keys of m.
+func (m *Map) Iter() Iterator {
+	return &sliceIterator{elems: m.keys}
+}
+
+func (m *Map) String() string {
+	ss := make([]string, 0, len(m.keys))
+	for j, k := range m.keys {
+		ss = append(ss, repr(k)+": "+repr(m.values[j]))
+	}
+	return "{" + strings.Join(ss, ", ") + "}"
+}
+
+type (
+	intKey   string
+	floatKey string
+)
+
+// hashKey maps k to a comparable Go value identifying it as a map key.
+func hashKey(k0 any) any {
+	switch k := k0.(type) {
+	case *big.Int:
+		return intKey(k.String())
+	case *big.Float:
+		return floatKey(k.Text('g', -1))
+	case string, rune, bool, nil:
+		return k
+	default:
+		panic(fmt.Sprintf("type mismatch: %s cannot be used as a map key", repr(k0)))
+	}
+}
+
+// Range is the runtime representation of integer ranges such as `0..10`.
+type Range struct {
+	Start, End *big.Int
+	Step       *big.Int
+	Inclusive  bool
+}
+
+func (r *Range) Iter() Iterator {
+	return &rangeIterator{
+		r:    r,
+		next: new(big.Int).Set(r.Start),
+	}
+}
+
+func (r *Range) String() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+	s := r.Start.String() + op + r.End.String()
+	if r.Step.Cmp(big.NewInt(1)) != 0 {
+		s += " step " + r.Step.String()
+	}
+	return s
+}
+
+// Iterator produces the values bound by a for-in loop, one at a time.
+type Iterator interface {
+	// Next returns the next value, or false once the sequence is exhausted.
+	Next() (v any, ok bool)
+}
+
+// Iterable is implemented by values a for-in loop can iterate over.
+type Iterable interface {
+	Iter() Iterator
+}
+
+type sliceIterator struct {
+	elems []any
+	next  int
+}
+
+func (it *sliceIterator) Next() (any, bool) {
+	if it.next >= len(it.elems) {
+		return nil, false
+	}
+	it.next++
+	return it.elems[it.next-1], true
+}
+
+type stringIterator struct {
+	s string
+}
+
+func (it *stringIterator) Next() (any, bool) {
+	if it.s == "" {
+		return nil, false
+	}
+	r, size := utf8.DecodeRuneInString(it.s)
+	it.s = it.s[size:]
+	return r, true
+}
+
+type rangeIterator struct {
+	r    *Range
+	next *big.Int
+}
+
+func (it *rangeIterator) Next() (any, bool) {
+	c := it.next.Cmp(it.r.End)
+	if it.r.Step.Sign() < 0 {
+		c = -c
+	}
+	if c > 0 || (c == 0 && !it.r.Inclusive) {
+		return nil, false
+	}
+	v := NewInt().Set(it.next)
+	it.next.Add(it.next, it.r.Step)
+	return v, true
+}
+
+type callIterator struct {
+	f Callable
+	i *Interpreter
+}
+
+func (it *callIterator) Next() (any, bool) {
+	v := it.f.Call(nil, it.i)
+	return v, v != nil
+}
+
+// repr returns the textual form of v as it appears inside lists and maps.
+func repr(v0 any) string {
+	switch v := v0.(type) {
+	case string:
+		return strconv.Quote(v)
+	case rune:
+		return strconv.QuoteRune(v)
+	default:
+		return display(v)
+	}
+}
+
+// display returns the textual form of v as printed by print and println.
+func display(v0 any) string {
+	switch v := v0.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	case rune:
+		return string(v)
+	case *Func:
+		return "<fn " + v.Name + ">"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// displayAll joins args the way fmt.Print does: a space is added between two
+// operands when neither of them is a string. If sep is true, a space is added
+// between all operands, as fmt.Println does.
+func displayAll(args []any, sep bool) string {
+	var sb strings.Builder
+	for j, a := range args {
+		if j > 0 && (sep || !isString(args[j-1]) && !isString(a)) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(display(a))
+	}
+	return sb.String()
+}
+
+func isString(x any) bool {
+	_, ok := x.(string)
+	return ok
+}
@@ -1,20 +1,131 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"runtime/debug"
+	"time"
 
+	"naive/compiler"
 	"naive/interpreter"
+	"naive/optimize"
 	"naive/parser"
 	"naive/token"
+	"naive/vm"
 )
 
+var (
+	warnRedeclare = flag.Bool("warn-redeclare", false, "warn about names declared twice in the same scope")
+	maxCallDepth  = flag.Int("max-call-depth", interpreter.DefaultMaxCallDepth, "the number of calls that may be in progress at once")
+	engineName    = flag.String("engine", "tree", "how to run scripts: tree, walking the syntax tree, or vm, compiling them to bytecode")
+	cacheDir      = flag.String("cache", os.Getenv("NAIVE_CACHE"), "the directory to keep compiled scripts in, for -engine vm and build")
+	optimizeCode  = flag.Bool("optimize", false, "fold constant expressions and drop code that cannot run before running scripts")
+	maxSteps      = flag.Int("max-steps", 0, "the number of calls and loop iterations scripts may make, or 0 for no limit")
+	maxAlloc      = flag.Int("max-alloc", 0, "the number of bytes scripts may allocate for numbers and strings, or 0 for no limit")
+	timeout       = flag.Duration("timeout", 0, "how long scripts may run, or 0 for no limit")
+	allow         = flag.String("allow", "all", "the capabilities of scripts, separated by commas: stdio, fs-read, fs-write, env, clock, random, all or none")
+
+	// capabilities are the capabilities named by -allow.
+	capabilities interpreter.Capabilities
+)
+
+// engine is what runs scripts: an interpreter or a VM.
+type engine interface {
+	// use makes the engine run the statements of p next.
+	use(p *parser.Parser)
+	run()
+}
+
+type treeEngine struct{ *interpreter.Interpreter }
+
+func (e treeEngine) use(p *parser.Parser) { e.P = p }
+func (e treeEngine) run() {
+	e.Limits = limits()
+	raise(e.Try(func() any { e.Interpret(); return nil }))
+}
+
+type vmEngine struct{ *vm.VM }
+
+func (e vmEngine) use(p *parser.Parser) { e.P = p }
+func (e vmEngine) run() {
+	e.Limits = limits()
+	raise(e.Try(func() any { e.Run(); return nil }))
+}
+
+// raise panics with the error a script run by an engine throws or raises, if
+// any. Engines raise errors as strings, which Try turns into errors with stack
+// traces.
+func raise(_ any, caught *interpreter.Error) {
+	if caught != nil {
+		panic(caught)
+	}
+}
+
+// limits returns the limits set by the flags on a script starting to run.
+func limits() interpreter.Limits {
+	l := interpreter.Limits{MaxSteps: *maxSteps, MaxAlloc: *maxAlloc}
+	if *timeout > 0 {
+		l.Deadline = time.Now().Add(*timeout)
+	}
+	return l
+}
+
+// newEngine returns the engine chosen by the -engine flag for the file at
+// path, holding src.
+func newEngine(path string, src []byte) engine {
+	switch *engineName {
+	case "tree":
+		interp := interpreter.NewWithCapabilities(path, src, capabilities)
+		interp.Redeclarations = *warnRedeclare
+		interp.MaxCallDepth = *maxCallDepth
+		if *optimizeCode {
+			interp.Rewrite = optimize.Optimize
+		}
+		return treeEngine{interp}
+	case "vm":
+		return vmEngine{newVM(path, src)}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine %s\n", *engineName)
+		os.Exit(1)
+		return nil
+	}
+}
+
+// newVM returns a VM for the file at path, holding src, set up by the flags.
+func newVM(path string, src []byte) *vm.VM {
+	m := vm.NewWithCapabilities(path, src, capabilities)
+	m.Redeclarations = *warnRedeclare
+	m.MaxCallDepth = *maxCallDepth
+	m.Cache = *cacheDir
+	if *optimizeCode {
+		m.Rewrite = optimize.Optimize
+	}
+	return m
+}
+
 func main() {
-	if len(os.Args) == 2 {
-		runFile(os.Args[1])
-	} else if len(os.Args) == 1 {
+	flag.Usage = printUsage
+	flag.Parse()
+	var err error
+	if capabilities, err = interpreter.ParseCapabilities(*allow); err != nil {
+		fmt.Fprintln(os.Stderr, "naive: -allow: "+err.Error())
+		os.Exit(2)
+	}
+	// The tree engine runs calls on the Go stack, the VM on its own.
+	if *engineName == "tree" && *maxCallDepth > interpreter.MaxStackDepth {
+		fmt.Fprintf(os.Stderr, "naive: -max-call-depth: the tree engine runs at most %d calls at once\n", interpreter.MaxStackDepth)
+		os.Exit(2)
+	}
+	debug.SetMaxStack(interpreter.MaxStack)
+	if flag.NArg() == 2 && flag.Arg(0) == "disasm" {
+		disasm(flag.Arg(1))
+	} else if flag.NArg() >= 2 && flag.Arg(0) == "build" {
+		build(flag.Args()[1:])
+	} else if flag.NArg() == 1 {
+		runFile(flag.Arg(0))
+	} else if flag.NArg() == 0 {
 		runPrompt()
 	} else {
 		printUsage()
@@ -22,11 +133,14 @@ func main() {
 }
 
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "naive /path/to/script")
+	fmt.Fprintln(os.Stderr, "naive [flags] /path/to/script")
+	fmt.Fprintln(os.Stderr, "naive [flags] disasm /path/to/script")
+	fmt.Fprintln(os.Stderr, "naive [flags] build /path/to/script...")
+	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-func runFile(path string) error {
+func readFile(path string) []byte {
 	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
 		panic(err)
@@ -35,26 +149,80 @@ func runFile(path string) error {
 	if err != nil {
 		panic(err)
 	}
+	return src
+}
 
-	interp := interpreter.New(path, src)
-	interp.Interpret()
+func runFile(path string) error {
+	src := readFile(path)
+	e := newEngine(path, src)
+	defer func() {
+		if r := recover(); r != nil {
+			if !report(r) {
+				panic(r)
+			}
+			os.Exit(1)
+		}
+	}()
+	e.run()
 
 	return nil
 }
 
+// report prints r, a value recovered from a panic of an engine, if it is an
+// error of the script run, and reports whether it is.
+func report(r any) bool {
+	switch err := r.(type) {
+	case *interpreter.Error:
+		fmt.Fprint(os.Stderr, err.Trace())
+	case *interpreter.LimitError:
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// disasm prints the bytecode the script at path compiles to.
+func disasm(path string) {
+	m := newVM(path, readFile(path))
+	m.Cache = ""
+	compiler.Disassemble(os.Stdout, m.Compile())
+}
+
+// build compiles the scripts at paths, and the modules they import, into the
+// cache directory.
+func build(paths []string) {
+	if *cacheDir == "" {
+		fmt.Fprintln(os.Stderr, "naive build: no cache directory: set -cache or NAIVE_CACHE")
+		os.Exit(1)
+	}
+	for _, path := range paths {
+		newVM(path, readFile(path)).Build()
+	}
+}
+
 func runPrompt() {
-	scan := bufio.NewScanner(os.Stdin)
-	scan.Split(bufio.ScanLines)
+	// Lines are read through the buffer getline reads through, so that
+	// getline gets the lines after the one calling it.
+	in := interpreter.Stdin()
 
-	interp := interpreter.Default()
+	e := newEngine("", nil)
 
 	for {
 		fmt.Printf("naive> ")
-		if !scan.Scan() {
+		line, ok := interpreter.ReadLine(in)
+		if !ok {
 			break
 		}
-		p := parser.New(token.NewFile("<repl>"), []byte(scan.Text()))
-		interp.P = p
-		interp.Interpret()
+		p := parser.New(token.NewFile("<repl>"), []byte(line))
+		e.use(p)
+		func() {
+			defer func() {
+				if r := recover(); r != nil && !report(r) {
+					panic(r)
+				}
+			}()
+			e.run()
+		}()
 	}
 }
@@ -0,0 +1,321 @@
+package naive
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"naive/interpreter"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// goFunc is a Go function called as a Naive builtin.
+type goFunc struct {
+	name string
+	fn   reflect.Value
+}
+
+func (f *goFunc) String() string {
+	return "<fn " + f.name + ">"
+}
+
+func (f *goFunc) Call(args []any, i *interpreter.Interpreter) any {
+	t := f.fn.Type()
+	n := t.NumIn()
+	if t.IsVariadic() && len(args) < n-1 {
+		panic(fmt.Sprintf("function %s takes at least %d arguments but %d are provided", f.name, n-1, len(args)))
+	} else if !t.IsVariadic() && len(args) != n {
+		panic(fmt.Sprintf("function %s takes %d arguments but %d are provided", f.name, n, len(args)))
+	}
+	in := make([]reflect.Value, len(args))
+	for j, a := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && j >= n-1 {
+			pt = t.In(n - 1).Elem()
+		} else {
+			pt = t.In(j)
+		}
+		v, err := fromValue(a, pt)
+		if err != nil {
+			panic(fmt.Sprintf("type mismatch: argument %d of function %s: %v", j+1, f.name, err))
+		}
+		in[j] = v
+	}
+
+	out := f.fn.Call(in)
+	if k := len(out); k > 0 && t.Out(k-1) == errorType {
+		if err, _ := out[k-1].Interface().(error); err != nil {
+			return interpreter.Throw(&interpreter.Error{Message: err.Error(), Err: err}, i)
+		}
+		out = out[:k-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil
+	case 1:
+		return ValueOf(out[0].Interface())
+	default:
+		l := &interpreter.List{Elems: make([]any, len(out))}
+		for j, v := range out {
+			l.Elems[j] = ValueOf(v.Interface())
+		}
+		return l
+	}
+}
+
+// ValueOf converts x to a Naive value.
+//
+// Go integers become Naive integers, except for runes, which become
+// characters, and floats become Naive floats. Slices and arrays become lists,
+// maps become maps, with their keys in order, and structs become maps from
+// the names of their exported fields to their values. A field is named by its
+// `naive` tag if it has one, and by its Go name with its first letter in lower
+// case otherwise. The tag "-" leaves it out. Functions become builtins, as
+// registered by Runtime.Register. Other values, including Naive ones and
+// pointers, are left as they are.
+func ValueOf(x any) Value {
+	switch x := x.(type) {
+	case nil, bool, string, rune, *big.Int, *big.Float:
+		return x
+	case int:
+		return big.NewInt(int64(x))
+	case int64:
+		return big.NewInt(x)
+	case float64:
+		return big.NewFloat(x)
+	case []any:
+		l := &interpreter.List{Elems: make([]any, len(x))}
+		for j, e := range x {
+			l.Elems[j] = ValueOf(e)
+		}
+		return l
+	case interpreter.Callable, *interpreter.List, *interpreter.Map, *interpreter.Range,
+		*interpreter.Error, *interpreter.Module, *interpreter.Result:
+		return x
+	}
+
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return big.NewFloat(v.Float())
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Slice, reflect.Array:
+		l := &interpreter.List{Elems: make([]any, v.Len())}
+		for j := range l.Elems {
+			l.Elems[j] = ValueOf(v.Index(j).Interface())
+		}
+		return l
+	case reflect.Map:
+		type entry struct{ k, v any }
+		entries := make([]entry, 0, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			entries = append(entries, entry{ValueOf(it.Key().Interface()), ValueOf(it.Value().Interface())})
+		}
+		sort.Slice(entries, func(a, b int) bool {
+			return interpreter.Repr(entries[a].k) < interpreter.Repr(entries[b].k)
+		})
+		m := interpreter.NewMap()
+		for _, e := range entries {
+			m.Set(e.k, e.v)
+		}
+		return m
+	case reflect.Struct:
+		m := interpreter.NewMap()
+		t := v.Type()
+		for j := 0; j < t.NumField(); j++ {
+			if name, ok := fieldName(t.Field(j)); ok {
+				m.Set(name, ValueOf(v.Field(j).Interface()))
+			}
+		}
+		return m
+	case reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+		return &goFunc{name: "<anonymous>", fn: v}
+	default:
+		return x
+	}
+}
+
+// fieldName returns the name of the key a struct field has in maps, and
+// whether it has one.
+func fieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	if tag, ok := f.Tag.Lookup("naive"); ok {
+		return tag, tag != "-"
+	}
+	r, n := utf8.DecodeRuneInString(f.Name)
+	return string(unicode.ToLower(r)) + f.Name[n:], true
+}
+
+// FromValue converts v, a Naive value, to a Go value of type T.
+//
+// v converts to T if it is assignable to T, as Naive values are to any, and
+// integers to *big.Int. Besides, integers convert to Go integers they fit in
+// and to floats, floats to Go floats, lists to slices, and maps to maps and to
+// structs, whose fields are named as by ValueOf. Structs convert to pointers
+// to them as well. nil converts to the zero value of pointers, slices, maps,
+// functions and interfaces.
+func FromValue[T any](v Value) (T, error) {
+	var zero T
+	x, err := fromValue(v, reflect.TypeOf(&zero).Elem())
+	if err != nil {
+		return zero, err
+	}
+	return x.Interface().(T), nil
+}
+
+func fromValue(v Value, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Func, reflect.Interface:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, mismatch(v, t)
+	}
+	if x := reflect.ValueOf(v); x.Type().AssignableTo(t) {
+		// Keep the value in an interface, or the kind of its type.
+		return x.Convert(t), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(*big.Int)
+		if !ok {
+			return reflect.Value{}, mismatch(v, t)
+		}
+		x := reflect.New(t).Elem()
+		if !n.IsInt64() || x.OverflowInt(n.Int64()) {
+			return reflect.Value{}, fmt.Errorf("%s overflows %s", n, t)
+		}
+		x.SetInt(n.Int64())
+		return x, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := v.(*big.Int)
+		if !ok {
+			return reflect.Value{}, mismatch(v, t)
+		}
+		x := reflect.New(t).Elem()
+		if !n.IsUint64() || x.OverflowUint(n.Uint64()) {
+			return reflect.Value{}, fmt.Errorf("%s overflows %s", n, t)
+		}
+		x.SetUint(n.Uint64())
+		return x, nil
+	case reflect.Float32, reflect.Float64:
+		x := reflect.New(t).Elem()
+		switch n := v.(type) {
+		case *big.Float:
+			f, _ := n.Float64()
+			x.SetFloat(f)
+		case *big.Int:
+			f, _ := new(big.Float).SetInt(n).Float64()
+			x.SetFloat(f)
+		default:
+			return reflect.Value{}, mismatch(v, t)
+		}
+		return x, nil
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			return reflect.ValueOf(b).Convert(t), nil
+		}
+	case reflect.Slice:
+		l, ok := v.(*interpreter.List)
+		if !ok {
+			return reflect.Value{}, mismatch(v, t)
+		}
+		x := reflect.MakeSlice(t, len(l.Elems), len(l.Elems))
+		for j, e := range l.Elems {
+			y, err := fromValue(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %v", j, err)
+			}
+			x.Index(j).Set(y)
+		}
+		return x, nil
+	case reflect.Map:
+		m, ok := v.(*interpreter.Map)
+		if !ok {
+			return reflect.Value{}, mismatch(v, t)
+		}
+		x := reflect.MakeMapWithSize(t, m.Len())
+		for it := m.Iter(); ; {
+			k, more := it.Next()
+			if !more {
+				break
+			}
+			e, _ := m.Get(k)
+			gk, err := fromValue(k, t.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %s: %v", interpreter.Repr(k), err)
+			}
+			ge, err := fromValue(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("value of %s: %v", interpreter.Repr(k), err)
+			}
+			x.SetMapIndex(gk, ge)
+		}
+		return x, nil
+	case reflect.Struct:
+		m, ok := v.(*interpreter.Map)
+		if !ok {
+			return reflect.Value{}, mismatch(v, t)
+		}
+		fields := make(map[string]int)
+		for j := 0; j < t.NumField(); j++ {
+			if name, ok := fieldName(t.Field(j)); ok {
+				fields[name] = j
+			}
+		}
+		x := reflect.New(t).Elem()
+		for it := m.Iter(); ; {
+			k, more := it.Next()
+			if !more {
+				break
+			}
+			name, _ := k.(string)
+			j, ok := fields[name]
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("%s has no field %s", t, interpreter.Repr(k))
+			}
+			e, _ := m.Get(k)
+			y, err := fromValue(e, t.Field(j).Type)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %v", name, err)
+			}
+			x.Field(j).Set(y)
+		}
+		return x, nil
+	case reflect.Pointer:
+		y, err := fromValue(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		x := reflect.New(t.Elem())
+		x.Elem().Set(y)
+		return x, nil
+	}
+	return reflect.Value{}, mismatch(v, t)
+}
+
+// mismatch returns the error of converting v to t.
+func mismatch(v Value, t reflect.Type) error {
+	return fmt.Errorf("cannot use %s as %s", interpreter.Repr(v), t)
+}
@@ -0,0 +1,172 @@
+package naive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/interpreter"
+)
+
+type point struct {
+	X, Y  int
+	Label string `naive:"name"`
+	Note  string `naive:"-"`
+	local bool
+}
+
+var errNotFound = errors.New("not found")
+
+func TestRuntime_Register(t *testing.T) {
+	ctx := context.Background()
+	rt := NewRuntime(Options{})
+	helpers := map[string]any{
+		"add":   func(a, b int) int { return a + b },
+		"half":  func(x float64) float64 { return x / 2 },
+		"upper": strings.ToUpper,
+		"sum": func(xs []int64) (n int64) {
+			for _, x := range xs {
+				n += x
+			}
+			return
+		},
+		"keys":   func(m map[string]bool) int { return len(m) },
+		"norm":   func(p point) int { return p.X*p.X + p.Y*p.Y },
+		"origin": func() point { return point{Label: "o", Note: "n", local: true} },
+		"move":   func(p *point, dx int) point { p.X += dx; return *p },
+		"join":   func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"divmod": func(a, b int) (int, int) { return a / b, a % b },
+		"small":  func(x uint8) uint8 { return x },
+		"find": func(key string) (string, error) {
+			if key == "a" {
+				return "found", nil
+			}
+			return "", fmt.Errorf("key %q: %w", key, errNotFound)
+		},
+		"twice": func(f func(any) any, x any) any { return f(f(x)) },
+	}
+	for name, fn := range helpers {
+		if err := rt.Register(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Convey("Go functions convert their arguments and results", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "add(40, 2);", want: "42"},
+			{src: "half(3);", want: "1.5"},
+			{src: `upper("abc");`, want: "ABC"},
+			{src: "sum([1, 2, 3]);", want: "6"},
+			{src: `keys({"a": true, "b": false});`, want: "2"},
+			{src: `norm({x: 3, y: 4, name: "p"});`, want: "25"},
+			{src: "origin();", want: `{"x": 0, "y": 0, "name": "o"}`},
+			{src: "move({x: 1, y: 2}, 3).x;", want: "4"},
+			{src: `join("-", "a", "b", "c");`, want: "a-b-c"},
+			{src: `join(",");`, want: ""},
+			{src: "divmod(7, 2);", want: "[3, 1]"},
+			{src: `find("a");`, want: "found"},
+			{src: "let n = 0; for k in [1, 2] { n = add(n, k); } n;", want: "3"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				v, err := rt.Eval(ctx, tc.src)
+				So(err, ShouldBeNil)
+				So(interpreter.Display(v), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("Wrong arguments are errors", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "add(1);", want: "function add takes 2 arguments but 1 are provided"},
+			{src: "join();", want: "function join takes at least 1 arguments but 0 are provided"},
+			{src: `add(1, "2");`, want: `type mismatch: argument 2 of function add: cannot use "2" as int`},
+			{src: "small(256);", want: "type mismatch: argument 1 of function small: 256 overflows uint8"},
+			{src: `sum([1, nil]);`, want: "type mismatch: argument 1 of function sum: element 1: cannot use nil as int64"},
+			{src: `norm({x: 1, z: 2});`, want: `type mismatch: argument 1 of function norm: naive.point has no field "z"`},
+			{src: `norm({x: 1.5});`, want: "type mismatch: argument 1 of function norm: field x: cannot use 1.5 as int"},
+			{src: "twice(fn(x) -> x, 1);", want: "type mismatch: argument 1 of function twice: cannot use <fn <anonymous>> as func(interface {}) interface {}"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				_, err := rt.Eval(ctx, tc.src)
+				So(err, ShouldBeError, tc.want)
+			})
+		}
+	})
+
+	Convey("Returned errors are thrown", t, func() {
+		v, err := rt.Eval(ctx, `try { find("b") } catch e { e.message }`)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, `key "b": not found`)
+
+		_, err = rt.Eval(ctx, `fn lookup(k) { find(k) } lookup("c");`)
+		So(err, ShouldBeError, `key "c": not found`)
+		So(errors.Is(err, errNotFound), ShouldBeTrue)
+		So(err.(*Error).Trace(), ShouldContainSubstring, "at lookup")
+	})
+
+	Convey("Register replaces builtins", t, func() {
+		_, err := rt.Eval(ctx, "fn wrap(x) { ok(x) }")
+		So(err, ShouldBeNil)
+		v, _ := rt.Eval(ctx, "wrap(1);")
+		So(interpreter.Display(v), ShouldEqual, "ok(1)")
+		So(rt.Register("ok", func(x int) int { return x * 10 }), ShouldBeNil)
+		v, _ = rt.Eval(ctx, "wrap(1);")
+		So(v, ShouldResemble, big.NewInt(10))
+	})
+
+	Convey("Only functions may be registered", t, func() {
+		So(rt.Register("x", 1), ShouldBeError, "naive: cannot register int as function x")
+		var f func()
+		So(rt.Register("f", f), ShouldBeError, "naive: cannot register func() as function f")
+	})
+}
+
+func TestValueOf(t *testing.T) {
+	Convey("ValueOf converts Go values", t, func() {
+		So(ValueOf(int64(-3)), ShouldResemble, big.NewInt(-3))
+		So(ValueOf(uint64(1<<63)).(*big.Int).String(), ShouldEqual, "9223372036854775808")
+		So(ValueOf('x'), ShouldEqual, 'x')
+		So(ValueOf(float32(1.5)), ShouldResemble, big.NewFloat(1.5))
+		So(interpreter.Repr(ValueOf([2]string{"a", "b"})), ShouldEqual, `["a", "b"]`)
+		So(interpreter.Repr(ValueOf(map[int]string{2: "b", 1: "a"})), ShouldEqual, `{1: "a", 2: "b"}`)
+		type named string
+		So(ValueOf(named("n")), ShouldEqual, "n")
+		l := &interpreter.List{}
+		So(ValueOf(l), ShouldEqual, l)
+		p := &point{}
+		So(ValueOf(p), ShouldEqual, p)
+	})
+
+	Convey("FromValue converts Naive values", t, func() {
+		n, err := FromValue[int](big.NewInt(7))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 7)
+		f, err := FromValue[float64](big.NewInt(2))
+		So(err, ShouldBeNil)
+		So(f, ShouldEqual, 2.0)
+		m, err := FromValue[map[string][]int](ValueOf(map[string]any{"a": []any{1, 2}}))
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, map[string][]int{"a": {1, 2}})
+		p, err := FromValue[point](ValueOf(point{X: 1, Label: "l", Note: "n"}))
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, point{X: 1, Label: "l"})
+		v, err := FromValue[any](big.NewInt(1))
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, big.NewInt(1))
+		_, err = FromValue[string](big.NewInt(1))
+		So(err, ShouldBeError, "cannot use 1 as string")
+	})
+}
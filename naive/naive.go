@@ -0,0 +1,176 @@
+// Package naive runs Naive code from Go programs.
+//
+// A Runtime keeps the top-level bindings of the code it runs, as the REPL
+// does, so code run later sees the declarations of code run earlier. Errors of
+// the code, including the ones it throws and does not catch, are returned as
+// *Error values instead of being raised as panics.
+package naive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"naive/interpreter"
+)
+
+// Options configure a Runtime.
+type Options struct {
+	// SearchPath lists the directories searched for a module that is not
+	// found relative to the importing file.
+	SearchPath []string
+	// MaxCallDepth is the number of calls that may be in progress at once.
+	// It defaults to interpreter.DefaultMaxCallDepth.
+	MaxCallDepth int
+	// MaxSteps and MaxAlloc limit each run of code, a call of Eval, RunFile
+	// or Call, as in interpreter.Interpreter, and Timeout limits how long it
+	// may take. Zero values set no limit.
+	MaxSteps int
+	MaxAlloc int
+	Timeout  time.Duration
+	// Sandbox limits the builtins the code may call to those that need no
+	// more than Capabilities. Others raise a permission error when called,
+	// as do imports without interpreter.CapFSRead.
+	Sandbox      bool
+	Capabilities interpreter.Capabilities
+	// Stdin, Stdout and Stderr are the files of the code run, as in
+	// interpreter.Interpreter. They default to the standard files of the
+	// process.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Value is a Naive value. Integers are *big.Int, floats *big.Float and
+// characters rune, and strings, booleans and nil are the Go ones. Other values
+// have types of package interpreter, such as *interpreter.List,
+// *interpreter.Map and *interpreter.Func.
+type Value = any
+
+// Error is an error raised by Naive code. Its Trace method returns its stack
+// trace.
+type Error = interpreter.Error
+
+// LimitError is the error of code stopped for exceeding a limit of Options,
+// or for running once its context is done. It unwraps to the error of the
+// context then.
+type LimitError = interpreter.LimitError
+
+// Runtime runs Naive code. It is not safe for concurrent use.
+type Runtime struct {
+	interp  *interpreter.Interpreter
+	timeout time.Duration
+}
+
+// NewRuntime returns a Runtime configured by opts.
+func NewRuntime(opts Options) *Runtime {
+	caps := interpreter.CapAll
+	if opts.Sandbox {
+		caps = opts.Capabilities
+	}
+	interp := interpreter.NewWithCapabilities("", nil, caps)
+	interp.SearchPath = opts.SearchPath
+	interp.Stdin, interp.Stdout, interp.Stderr = opts.Stdin, opts.Stdout, opts.Stderr
+	interp.MaxSteps, interp.MaxAlloc = opts.MaxSteps, opts.MaxAlloc
+	if opts.MaxCallDepth > 0 {
+		interp.MaxCallDepth = opts.MaxCallDepth
+	}
+	return &Runtime{interp: interp, timeout: opts.Timeout}
+}
+
+// Eval runs src and returns its value: the value of its last statement if
+// that is an expression, the value of a top-level return statement, or nil.
+// Imports are relative to the working directory. The code stops running with
+// a LimitError once ctx is done.
+func (rt *Runtime) Eval(ctx context.Context, src string) (Value, error) {
+	return rt.run(ctx, "<eval>", []byte(src))
+}
+
+// RunFile runs the file at path.
+func (rt *Runtime) RunFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = rt.run(context.Background(), path, src)
+	return err
+}
+
+func (rt *Runtime) run(ctx context.Context, path string, src []byte) (Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rt.interp.SetSource(path, src)
+	return rt.protect(ctx, rt.interp.Eval)
+}
+
+// Register makes fn, a Go function, the builtin called name, in place of any
+// other. Naive values are converted to the types of the parameters of fn, as
+// described by FromValue, and the results of fn are converted back by ValueOf.
+// If fn has a trailing error result, the error is thrown as a Naive error
+// that unwraps to it. Other results make a list if there are several.
+func (rt *Runtime) Register(name string, fn any) error {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return fmt.Errorf("naive: cannot register %T as function %s", fn, name)
+	}
+	rt.interp.SetBuiltin(name, &goFunc{name: name, fn: f})
+	return nil
+}
+
+// Get returns the value of the latest global called name, and whether there
+// is one.
+func (rt *Runtime) Get(name string) (Value, bool) {
+	return rt.interp.Lookup(name)
+}
+
+// Set binds the global called name to v, converted as by ValueOf, as a let
+// statement run before the code run next would.
+func (rt *Runtime) Set(name string, v any) {
+	rt.interp.Define(name, ValueOf(v))
+}
+
+// Call calls fn, a Naive function or builtin, with args converted as by
+// ValueOf, and returns its result.
+func (rt *Runtime) Call(fn Value, args ...any) (Value, error) {
+	f, ok := fn.(interpreter.Callable)
+	if !ok {
+		return nil, &Error{Message: "calling non-callable object"}
+	}
+	vals := make([]any, len(args))
+	for j, a := range args {
+		vals[j] = ValueOf(a)
+	}
+	return rt.protect(context.Background(), func() any {
+		rt.interp.ResetUsage()
+		return f.Call(vals, rt.interp)
+	})
+}
+
+// protect calls f, which runs Naive code until ctx is done, and returns the
+// error the code raises as an error. Go panics, which stand for bugs of the
+// interpreter, are returned as errors as well.
+func (rt *Runtime) protect(ctx context.Context, f func() any) (v Value, err error) {
+	rt.interp.Context, rt.interp.Deadline = ctx, time.Time{}
+	if rt.timeout > 0 {
+		rt.interp.Deadline = time.Now().Add(rt.timeout)
+	}
+	defer func() {
+		rt.interp.Context = nil
+		if r := recover(); r != nil {
+			if e, ok := r.(*LimitError); ok {
+				v, err = nil, e
+				return
+			}
+			v, err = nil, fmt.Errorf("naive: internal error: %v", r)
+		}
+	}()
+	v, caught := rt.interp.Try(f)
+	if caught != nil {
+		return nil, caught
+	}
+	return v, nil
+}
@@ -0,0 +1,288 @@
+package naive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/interpreter"
+)
+
+func TestRuntime_Eval(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Eval returns the value of the code", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "1 + 2;", want: "3"},
+			{src: "let x = 1;", want: "<nil>"},
+			{src: `let x = [1, "a"]; x;`, want: `[1, "a"]`},
+			{src: "fn f(n) { n * 2 } f(21);", want: "42"},
+			{src: "return 7; 8;", want: "7"},
+			{src: `if true { "y" } else { "n" }`, want: "y"},
+			{src: "fn f() { 42 } return f();", want: "42"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				v, err := NewRuntime(Options{}).Eval(ctx, tc.src)
+				So(err, ShouldBeNil)
+				So(fmt.Sprint(v), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("Eval keeps the globals of earlier code", t, func() {
+		rt := NewRuntime(Options{})
+		_, err := rt.Eval(ctx, "let n = 40; fn add(x) { n + x }")
+		So(err, ShouldBeNil)
+		v, err := rt.Eval(ctx, "add(2);")
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, big.NewInt(42))
+	})
+
+	Convey("Eval returns errors", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{name: "syntax", src: "let = 1;", want: "<eval>:1:5: incomplete let-statement, want an identifier but got ASSIGN"},
+			{name: "missing expression", src: "let x = ;", want: "<eval>:1:9: want an expression, got SEMICOLON"},
+			{name: "resolution", src: "x + 1;", want: "<eval>:1:1: undefined variable x"},
+			{name: "runtime", src: "fn f() { 1 / 0 } f();", want: "division by zero"},
+			{name: "thrown", src: `throw error("boom");`, want: "boom"},
+			{name: "stack overflow", src: "fn f() { 1 + f() } f();", want: "stack overflow: more than 100 calls in progress"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				rt := NewRuntime(Options{MaxCallDepth: 100})
+				_, err := rt.Eval(ctx, tc.src)
+				So(err, ShouldBeError, tc.want)
+				var e *Error
+				So(errors.As(err, &e), ShouldBeTrue)
+
+				Convey("and can run code after them", func() {
+					v, err := rt.Eval(ctx, "let y = 1; y;")
+					So(err, ShouldBeNil)
+					So(v, ShouldResemble, big.NewInt(1))
+				})
+			})
+		}
+	})
+
+	Convey("Errors in parsing and resolving code are at no position of a call", t, func() {
+		rt := NewRuntime(Options{})
+		_, err := rt.Eval(ctx, "fn f() { 1 / 0 }\nf();")
+		So(err.(*Error).Trace(), ShouldEqual, "error: division by zero\n\tat f (<eval>:1:12)\n\tat <main> (<eval>:2:1)\n")
+		_, err = rt.Eval(ctx, "let x = ;")
+		So(err.(*Error).Trace(), ShouldEqual, "error: <eval>:1:9: want an expression, got SEMICOLON\n\tat <main>\n")
+		_, err = rt.Eval(ctx, "y;")
+		So(err.(*Error).Trace(), ShouldEqual, "error: <eval>:1:1: undefined variable y\n\tat <main>\n")
+	})
+
+	Convey("Eval does not run once the context is done", t, func() {
+		rt := NewRuntime(Options{})
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := rt.Eval(cancelled, "let x = 1;")
+		So(err, ShouldEqual, context.Canceled)
+		_, ok := rt.Get("x")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Eval reads and writes the files of Options", t, func() {
+		var out strings.Builder
+		rt := NewRuntime(Options{Stdin: strings.NewReader("Ada\n"), Stdout: &out})
+		_, err := rt.Eval(ctx, `println("hello", getline());`)
+		So(err, ShouldBeNil)
+		So(out.String(), ShouldEqual, "hello Ada\n")
+	})
+}
+
+func TestRuntime_globals(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Set binds globals that code and Get see", t, func() {
+		rt := NewRuntime(Options{})
+		rt.Set("limit", 10)
+		rt.Set("ratio", 0.5)
+		rt.Set("name", "rule")
+		rt.Set("tags", []any{"a", 'b', true})
+		rt.Set("opts", map[string]any{"b": uint8(2), "a": nil})
+		v, err := rt.Eval(ctx, `format("{} {} {} {} {}", limit * 2, ratio, name, tags, opts);`)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, `20 0.5 rule ["a", 'b', true] {"a": nil, "b": 2}`)
+
+		_, err = rt.Eval(ctx, "let total = limit + 1; limit = 3;")
+		So(err, ShouldBeNil)
+		total, ok := rt.Get("total")
+		So(ok, ShouldBeTrue)
+		So(total, ShouldResemble, big.NewInt(11))
+		limit, _ := rt.Get("limit")
+		So(limit, ShouldResemble, big.NewInt(3))
+		_, ok = rt.Get("missing")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestRuntime_Call(t *testing.T) {
+	ctx := context.Background()
+	rt := NewRuntime(Options{})
+	_, err := rt.Eval(ctx, `fn greet(name, punct = "!") { format("hi {}{}", name, punct) }
+fn fail(x) { throw error("bad " + x); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Call calls Naive functions", t, func() {
+		greet, _ := rt.Get("greet")
+		v, err := rt.Call(greet, "bob")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "hi bob!")
+		v, err = rt.Call(greet, "ann", '?')
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "hi ann?")
+
+		lambda, err := rt.Eval(ctx, "fn(x, y) -> x * y;")
+		So(err, ShouldBeNil)
+		v, err = rt.Call(lambda, 6, 7)
+		So(err, ShouldBeNil)
+		So(v, ShouldResemble, big.NewInt(42))
+	})
+
+	Convey("Call calls builtins", t, func() {
+		ok, _ := rt.Eval(ctx, "ok;")
+		v, err := rt.Call(ok, 1)
+		So(err, ShouldBeNil)
+		So(fmt.Sprint(v), ShouldEqual, "ok(1)")
+	})
+
+	Convey("Call returns errors", t, func() {
+		fail, _ := rt.Get("fail")
+		_, err := rt.Call(fail, 1)
+		So(err, ShouldBeError, `type mismatch: "bad " is not an integer`)
+		greet, _ := rt.Get("greet")
+		_, err = rt.Call(greet)
+		So(err, ShouldBeError, "function greet: missing argument for parameter name")
+		_, err = rt.Call(big.NewInt(1))
+		So(err, ShouldBeError, "calling non-callable object")
+	})
+}
+
+func TestRuntime_RunFile(t *testing.T) {
+	Convey("RunFile runs files with their imports", t, func() {
+		dir := t.TempDir()
+		write := func(name, src string) string {
+			path := filepath.Join(dir, name)
+			So(os.WriteFile(path, []byte(src), 0644), ShouldBeNil)
+			return path
+		}
+		write("lib.nv", "fn twice(x) { x * 2 }")
+		main := write("main.nv", "import lib; let answer = lib.twice(21);")
+
+		rt := NewRuntime(Options{})
+		So(rt.RunFile(main), ShouldBeNil)
+		v, _ := rt.Get("answer")
+		So(v, ShouldResemble, big.NewInt(42))
+
+		So(rt.RunFile(write("bad.nv", "import missing;")), ShouldNotBeNil)
+		So(rt.RunFile(filepath.Join(dir, "none.nv")), ShouldNotBeNil)
+	})
+}
+
+func TestRuntime_limits(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Code that exceeds a limit returns a LimitError", t, func() {
+		testCases := []struct {
+			name  string
+			opts  Options
+			limit interpreter.Limit
+		}{
+			{name: "steps", opts: Options{MaxSteps: 1000}, limit: interpreter.StepLimit},
+			{name: "allocations", opts: Options{MaxAlloc: 1 << 10}, limit: interpreter.AllocLimit},
+			{name: "timeout", opts: Options{Timeout: 10 * time.Millisecond}, limit: interpreter.TimeLimit},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				rt := NewRuntime(tc.opts)
+				_, err := rt.Eval(ctx, "let x = 2; while true { x = x * 2; }")
+				var le *LimitError
+				So(errors.As(err, &le), ShouldBeTrue)
+				So(le.Limit, ShouldEqual, tc.limit)
+
+				Convey("and can run code after it", func() {
+					v, err := rt.Eval(ctx, "x > 2;")
+					So(err, ShouldBeNil)
+					So(v, ShouldBeTrue)
+				})
+			})
+		}
+	})
+
+	Convey("Eval stops once the context is done", t, func() {
+		rt := NewRuntime(Options{})
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := rt.Eval(timeout, "while true { }")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+	})
+
+	Convey("Call is limited as Eval is", t, func() {
+		rt := NewRuntime(Options{MaxSteps: 1000})
+		spin, err := rt.Eval(ctx, "fn(n) { for k in 0..n { } };")
+		So(err, ShouldBeNil)
+		for k := 0; k < 3; k++ {
+			_, err = rt.Call(spin, 900)
+			So(err, ShouldBeNil)
+		}
+		_, err = rt.Call(spin, 2000)
+		So(err, ShouldBeError, "step limit exceeded: more than 1000 steps")
+	})
+}
+
+func TestRuntime_sandbox(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("A sandboxed Runtime denies the builtins that need capabilities it lacks", t, func() {
+		var out strings.Builder
+		rt := NewRuntime(Options{Sandbox: true, Stdout: &out})
+		v, err := rt.Eval(ctx, `format("{}!", 42);`)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "42!")
+		_, err = rt.Eval(ctx, `println("hello");`)
+		So(err, ShouldBeError, "permission denied: function println needs the stdio capability")
+		So(out.String(), ShouldBeEmpty)
+
+		Convey("and allows the others", func() {
+			rt := NewRuntime(Options{Sandbox: true, Capabilities: interpreter.CapStdio, Stdout: &out})
+			_, err := rt.Eval(ctx, `println("hello");`)
+			So(err, ShouldBeNil)
+			So(out.String(), ShouldEqual, "hello\n")
+		})
+	})
+
+	Convey("A sandboxed Runtime imports nothing without the fs-read capability", t, func() {
+		dir := t.TempDir()
+		So(os.WriteFile(filepath.Join(dir, "lib.nv"), []byte(`let secret = "s3cret";`), 0644), ShouldBeNil)
+		rt := NewRuntime(Options{Sandbox: true, SearchPath: []string{dir}})
+		v, err := rt.Eval(ctx, "import lib; lib.secret;")
+		So(v, ShouldBeNil)
+		So(err, ShouldBeError, "<eval>:1:1: permission denied: import of module lib needs the fs-read capability")
+
+		rt = NewRuntime(Options{Sandbox: true, Capabilities: interpreter.CapFSRead, SearchPath: []string{dir}})
+		v, err = rt.Eval(ctx, "import lib; lib.secret;")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "s3cret")
+	})
+}
@@ -0,0 +1,340 @@
+// Package optimize rewrites parsed Naive code into equivalent code that runs
+// faster. It folds constant expressions and drops code that cannot run.
+package optimize
+
+import (
+	"math/big"
+
+	"naive/ast"
+	"naive/interpreter"
+)
+
+// Optimize rewrites stmts, as parsed and not yet resolved, in place, and
+// returns the statements to run in their place.
+//
+// Operators applied to literals are folded into their values, computed as
+// the interpreter does, unless they raise an error, which is left for the
+// code to raise when it runs. Arms of if-else statements whose conditions are
+// constant, and `while` loops whose conditions are constantly false, are
+// dropped.
+func Optimize(stmts []ast.Stmt) []ast.Stmt {
+	return (&optimizer{}).stmts(stmts)
+}
+
+type optimizer struct{}
+
+func (o *optimizer) expr(e ast.Expr) ast.Expr {
+	if e == nil {
+		return nil
+	}
+	return e.Accept(o).(ast.Expr)
+}
+
+func (o *optimizer) exprs(es []ast.Expr) {
+	for j, e := range es {
+		es[j] = o.expr(e)
+	}
+}
+
+func (o *optimizer) stmt(s ast.Stmt) ast.Stmt {
+	if s == nil {
+		return nil
+	}
+	return s.Accept(o).(ast.Stmt)
+}
+
+// stmts rewrites the statements of a block or file, leaving out those that
+// do nothing.
+func (o *optimizer) stmts(stmts []ast.Stmt) []ast.Stmt {
+	ans := stmts[:0]
+	for _, s := range stmts {
+		s = o.stmt(s)
+		if empty(s) {
+			continue
+		}
+		ans = append(ans, s)
+	}
+	return ans
+}
+
+// empty reports whether s does nothing.
+func empty(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.EmptyStmt:
+		return true
+	case *ast.Block:
+		return len(s.Statements) == 0 && s.Value == nil
+	default:
+		return false
+	}
+}
+
+func (o *optimizer) params(params []*ast.Param) {
+	for _, pm := range params {
+		pm.Default = o.expr(pm.Default)
+	}
+}
+
+// constant returns the value of e if it is a literal.
+func constant(e ast.Expr) (v any, ok bool) {
+	switch e := e.(type) {
+	case *ast.IntegerValue:
+		return e.Value, true
+	case *ast.FloatValue:
+		return e.Value, true
+	case ast.CharValue:
+		return e.Value, true
+	case ast.StringValue:
+		return e.Value, true
+	case ast.True:
+		return true, true
+	case ast.False:
+		return false, true
+	case ast.Nil:
+		return nil, true
+	default:
+		return nil, false
+	}
+}
+
+// literal returns the literal of value v, if v has one.
+func literal(v any) (e ast.Expr, ok bool) {
+	switch v := v.(type) {
+	case *big.Int:
+		return &ast.IntegerValue{Value: v}, true
+	case *big.Float:
+		return &ast.FloatValue{Value: v}, true
+	case rune:
+		return ast.CharValue{Value: v}, true
+	case string:
+		return ast.StringValue{Value: v}, true
+	case bool:
+		if v {
+			return ast.True{}, true
+		}
+		return ast.False{}, true
+	case nil:
+		return ast.Nil{}, true
+	default:
+		return nil, false
+	}
+}
+
+// fold returns the literal of the value computed by f, unless f raises an
+// error.
+func fold(f func() any) (e ast.Expr, ok bool) {
+	defer func() {
+		if recover() != nil {
+			e, ok = nil, false
+		}
+	}()
+	return literal(f())
+}
+
+func (o *optimizer) VisitIntegerValue(expr ast.IntegerValue) any {
+	return &expr
+}
+
+func (o *optimizer) VisitFloatValue(expr ast.FloatValue) any {
+	return &expr
+}
+
+func (o *optimizer) VisitStringValue(expr ast.StringValue) any {
+	return expr
+}
+
+func (o *optimizer) VisitCharValue(expr ast.CharValue) any {
+	return expr
+}
+
+func (o *optimizer) VisitTrue(expr ast.True) any {
+	return expr
+}
+
+func (o *optimizer) VisitFalse(expr ast.False) any {
+	return expr
+}
+
+func (o *optimizer) VisitNil(expr ast.Nil) any {
+	return expr
+}
+
+func (o *optimizer) VisitVariable(expr *ast.Variable) any {
+	return expr
+}
+
+func (o *optimizer) VisitBinaryExpr(expr *ast.BinaryExpr) any {
+	expr.Lhs, expr.Rhs = o.expr(expr.Lhs), o.expr(expr.Rhs)
+	lhs, ok := constant(expr.Lhs)
+	if !ok {
+		return expr
+	}
+	rhs, ok := constant(expr.Rhs)
+	if !ok {
+		return expr
+	}
+	if e, ok := fold(func() any { return interpreter.Binary(expr.Op, lhs, rhs) }); ok {
+		return e
+	}
+	return expr
+}
+
+func (o *optimizer) VisitUnaryExpr(expr *ast.UnaryExpr) any {
+	expr.X = o.expr(expr.X)
+	x, ok := constant(expr.X)
+	if !ok {
+		return expr
+	}
+	if e, ok := fold(func() any { return interpreter.Unary(expr.Op, x) }); ok {
+		return e
+	}
+	return expr
+}
+
+func (o *optimizer) VisitGroupingExpr(expr *ast.GroupingExpr) any {
+	expr.Expr = o.expr(expr.Expr)
+	if _, ok := constant(expr.Expr); ok {
+		return expr.Expr
+	}
+	return expr
+}
+
+func (o *optimizer) VisitRangeExpr(expr *ast.RangeExpr) any {
+	expr.Start, expr.End, expr.Step = o.expr(expr.Start), o.expr(expr.End), o.expr(expr.Step)
+	return expr
+}
+
+func (o *optimizer) VisitListExpr(expr *ast.ListExpr) any {
+	o.exprs(expr.Elems)
+	return expr
+}
+
+func (o *optimizer) VisitMapExpr(expr *ast.MapExpr) any {
+	o.exprs(expr.Keys)
+	o.exprs(expr.Values)
+	return expr
+}
+
+func (o *optimizer) VisitMatchExpr(expr *ast.MatchExpr) any {
+	expr.Value = o.expr(expr.Value)
+	for _, arm := range expr.Arms {
+		arm.Guard, arm.Body = o.expr(arm.Guard), o.expr(arm.Body)
+	}
+	return expr
+}
+
+func (o *optimizer) VisitCallExpr(expr *ast.CallExpr) any {
+	expr.Callee = o.expr(expr.Callee)
+	o.exprs(expr.Args)
+	return expr
+}
+
+func (o *optimizer) VisitMemberExpr(expr *ast.MemberExpr) any {
+	expr.X = o.expr(expr.X)
+	return expr
+}
+
+func (o *optimizer) VisitPropagateExpr(expr *ast.PropagateExpr) any {
+	expr.X = o.expr(expr.X)
+	return expr
+}
+
+func (o *optimizer) VisitLambda(expr *ast.Lambda) any {
+	o.params(expr.Params)
+	expr.Body = o.stmt(expr.Body)
+	return expr
+}
+
+func (o *optimizer) VisitLetStmt(stmt *ast.LetStmt) any {
+	stmt.Init = o.expr(stmt.Init)
+	return stmt
+}
+
+func (o *optimizer) VisitAssignStmt(stmt *ast.AssignStmt) any {
+	stmt.Expr = o.expr(stmt.Expr)
+	return stmt
+}
+
+func (o *optimizer) VisitImportStmt(stmt *ast.ImportStmt) any {
+	return stmt
+}
+
+// VisitIfElseStmt replaces stmt with the arm that runs if its condition is
+// constant. The result is then a block or an if-else statement, so that it
+// may still stand for the value of stmt.
+func (o *optimizer) VisitIfElseStmt(stmt *ast.IfElseStmt) any {
+	stmt.Cond = o.expr(stmt.Cond)
+	cond, ok := constant(stmt.Cond)
+	if !ok {
+		stmt.Then, stmt.Else = o.stmt(stmt.Then), o.stmt(stmt.Else)
+		return stmt
+	}
+	arm := stmt.Else
+	if interpreter.Truthy(cond) {
+		arm = stmt.Then
+	}
+	if e, ok := o.stmt(arm).(ast.Expr); ok {
+		return e
+	}
+	// The else arm is missing.
+	return &ast.Block{}
+}
+
+func (o *optimizer) VisitWhileStmt(stmt *ast.WhileStmt) any {
+	stmt.Cond = o.expr(stmt.Cond)
+	if cond, ok := constant(stmt.Cond); ok && !interpreter.Truthy(cond) {
+		return &ast.EmptyStmt{}
+	}
+	stmt.Body = o.stmt(stmt.Body)
+	return stmt
+}
+
+func (o *optimizer) VisitForStmt(stmt *ast.ForStmt) any {
+	stmt.Iter = o.expr(stmt.Iter)
+	stmt.Body = o.stmt(stmt.Body)
+	return stmt
+}
+
+func (o *optimizer) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	return stmt
+}
+
+func (o *optimizer) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	return stmt
+}
+
+func (o *optimizer) VisitFnStmt(stmt *ast.FnStmt) any {
+	o.params(stmt.Params)
+	stmt.Body = o.stmt(stmt.Body)
+	return stmt
+}
+
+func (o *optimizer) VisitReturnStmt(stmt *ast.ReturnStmt) any {
+	stmt.RetVal = o.expr(stmt.RetVal)
+	return stmt
+}
+
+func (o *optimizer) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	stmt.Value = o.expr(stmt.Value)
+	return stmt
+}
+
+func (o *optimizer) VisitTryStmt(stmt *ast.TryStmt) any {
+	stmt.Body, stmt.Catch, stmt.Finally = o.stmt(stmt.Body), o.stmt(stmt.Catch), o.stmt(stmt.Finally)
+	return stmt
+}
+
+func (o *optimizer) VisitExprStmt(stmt *ast.ExprStmt) any {
+	stmt.Expr = o.expr(stmt.Expr)
+	return stmt
+}
+
+func (o *optimizer) VisitEmptyStmt(stmt *ast.EmptyStmt) any {
+	return stmt
+}
+
+func (o *optimizer) VisitBlock(blk *ast.Block) any {
+	blk.Statements = o.stmts(blk.Statements)
+	blk.Value = o.expr(blk.Value)
+	return blk
+}
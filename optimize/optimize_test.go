@@ -0,0 +1,120 @@
+package optimize
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/ast"
+	"naive/interpreter"
+	"naive/parser"
+	"naive/token"
+)
+
+// optimize returns the statements of src, after rewriting them with rewrite,
+// one per line.
+func optimize(src string, rewrite ...func([]ast.Stmt) []ast.Stmt) string {
+	p := parser.New(token.NewFile("a.nv"), []byte(src))
+	p.Parse()
+	stmts := p.Statements
+	if len(rewrite) == 0 {
+		stmts = Optimize(stmts)
+	}
+	var b strings.Builder
+	for _, s := range stmts {
+		fmt.Fprintln(&b, s)
+	}
+	return b.String()
+}
+
+// run runs src and returns what it prints, or the error it raises.
+func run(src string, rewrite func([]ast.Stmt) []ast.Stmt) (out string) {
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		b, _ := io.ReadAll(r)
+		out = string(b)
+		if err := recover(); err != nil {
+			out += fmt.Sprint(err)
+		}
+	}()
+	interp := interpreter.New("a.nv", []byte(src))
+	interp.Rewrite = rewrite
+	interp.Interpret()
+	return
+}
+
+func TestOptimize(t *testing.T) {
+	Convey("Optimize folds constant expressions", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{"let d = 60 * 60 * 24;", optimize("let d = 86400;")},
+			{`let s = "ab" == "ab" and 1 < 2;`, optimize(`let s = true;`)},
+			{"let x = -(1 + 2) * 0.5;", optimize("let x = -1.5;")},
+			{"let b = not (1 < 2) or 3 == 3.0;", optimize("let b = true;")},
+			{"let x = y + 2 * 3;", optimize("let x = y + 6;")},
+			{"let n = 7 / 2 % 2;", optimize("let n = 1;")},
+			{"let c = 'a' == 'b' or nil == nil;", optimize("let c = true;")},
+			{"let f = false and true;", optimize("let f = false;")},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				So(optimize(tc.src), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("Optimize leaves errors to be raised at run time", t, func() {
+		for _, src := range []string{"let x = 1 / 0;", `let x = 1 + "a";`, `let x = "a" + "b";`} {
+			So(optimize(src), ShouldEqual, optimize(src, nil))
+		}
+	})
+
+	Convey("Optimize drops code that cannot run", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{`if false { println(1); } else { println(2); }`, optimize(`{ println(2); }`)},
+			{`if 1 < 2 { println(1); }`, optimize(`{ println(1); }`)},
+			{`if false { println(1); }`, ""},
+			{`if 0 == 1 { 1 } else if true { 2 } else { 3 }`, optimize(`{ 2 }`)},
+			{`while false { println(1); }`, ""},
+			{`while 1 > 2 { println(1); } println(2);`, optimize(`println(2);`)},
+			{`fn f(x) { if x { 1 } else if nil { 2 } else { 3 } }`, optimize(`fn f(x) { if x { 1 } else { 3 } }`)},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				So(optimize(tc.src), ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("Optimized code runs as the code it replaces", t, func() {
+		testCases := []string{
+			`println(60 * 60 * 24, "a" == "b", 1.0 / 3, 100000000000 * 100000000000);`,
+			`let x = if 1 > 2 { "yes" } else { "no" }; println(x);`,
+			`let y = if false { 1 }; println(y);`,
+			`let i = 0; while false { i += 1; } println(i);`,
+			`fn f() { if true { return 1; } 2 } println(f());`,
+			`println(1 / 0);`,
+			`let a = { if false { 1 } else { 2 } }; println(a);`,
+		}
+		for _, src := range testCases {
+			Convey(src, func() {
+				want := run(src, nil)
+				So(want, ShouldNotBeEmpty)
+				So(run(src, Optimize), ShouldEqual, want)
+			})
+		}
+	})
+}
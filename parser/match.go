@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+
+	"naive/ast"
+	"naive/token"
+)
+
+func (p *Parser) parseMatch() *ast.MatchExpr {
+	p.discard()
+	me := &ast.MatchExpr{
+		Value: p.parseExpr(),
+	}
+	p.consume(token.KindLBrace)
+	p.skipComments()
+	for !p.match(token.KindRBrace) {
+		arm := &ast.MatchArm{
+			Pattern: p.parsePattern(),
+		}
+		if p.match(token.KindIf) {
+			p.discard()
+			arm.Guard = p.parseExpr()
+		}
+		p.consume(token.KindFatArrow)
+		// An arm whose body is a block needs no ',' after it.
+		if p.match(token.KindLBrace) {
+			arm.Body = p.parseBlock().(ast.Expr)
+			if p.match(token.KindComma) {
+				p.discard()
+			}
+		} else {
+			arm.Body = p.parseExpr()
+			if !p.match(token.KindRBrace) {
+				p.consume(token.KindComma)
+			}
+		}
+		me.Arms = append(me.Arms, arm)
+		p.skipComments()
+	}
+	p.consume(token.KindRBrace)
+	checkArms(me)
+	return me
+}
+
+func (p *Parser) parsePattern() ast.Pattern {
+	if p.match(token.KindIdent) {
+		ident := p.text
+		p.discard()
+		if ident == "_" {
+			return &ast.WildcardPattern{}
+		}
+		return &ast.BindingPattern{Ident: ident}
+	} else if p.match(token.KindLBracket) {
+		return p.parseListPattern()
+	} else if p.match(token.KindLBrace) {
+		return p.parseRecordPattern()
+	}
+
+	start := p.parseLiteral()
+	if !p.matchAny(token.KindRange, token.KindRangeIncl) {
+		return &ast.LiteralPattern{Value: start}
+	}
+	rp := &ast.RangePattern{
+		Start:     start,
+		Inclusive: p.match(token.KindRangeIncl),
+	}
+	p.discard()
+	rp.End = p.parseLiteral()
+	return rp
+}
+
+// parseLiteral parses a literal in a pattern. Numbers may be negative.
+func (p *Parser) parseLiteral() ast.Expr {
+	neg := p.match(token.KindSub)
+	if neg {
+		p.discard()
+		if !p.matchAny(token.KindInt, token.KindFloat) {
+			panic(fmt.Sprintf("when parsing pattern: want a number after '-', got %s", p.kind))
+		}
+	}
+	if !p.matchAny(token.KindInt, token.KindFloat, token.KindChar, token.KindString,
+		token.KindTrue, token.KindFalse, token.KindNil) {
+		panic(fmt.Sprintf("when parsing pattern: want a literal, got %s", p.kind))
+	}
+	e := p.parsePrimary()
+	if neg {
+		switch v := e.(type) {
+		case *ast.IntegerValue:
+			v.Value.Neg(v.Value)
+		case *ast.FloatValue:
+			v.Value.Neg(v.Value)
+		}
+	}
+	return e
+}
+
+func (p *Parser) parseListPattern() ast.Pattern {
+	p.consume(token.KindLBracket)
+	lp := &ast.ListPattern{}
+	for !p.match(token.KindRBracket) {
+		if p.match(token.KindEllipsis) {
+			p.discard()
+			lp.HasRest = true
+			if p.match(token.KindIdent) {
+				if p.text != "_" {
+					lp.Rest = p.text
+				}
+				p.discard()
+			}
+			if !p.match(token.KindRBracket) {
+				panic("when parsing list pattern: '...' must come last")
+			}
+			break
+		}
+		lp.Elems = append(lp.Elems, p.parsePattern())
+		if !p.match(token.KindComma) {
+			break
+		}
+		p.discard()
+	}
+	p.consume(token.KindRBracket)
+	return lp
+}
+
+func (p *Parser) parseRecordPattern() ast.Pattern {
+	p.consume(token.KindLBrace)
+	rp := &ast.RecordPattern{}
+	for !p.match(token.KindRBrace) {
+		if !p.matchAny(token.KindIdent, token.KindString) {
+			panic(fmt.Sprintf("when parsing record pattern: want a key, got %s", p.kind))
+		}
+		key, quoted := p.text, p.match(token.KindString)
+		if quoted {
+			key = ast.NewStringValue(p.text).Value
+		}
+		p.discard()
+		var value ast.Pattern = &ast.BindingPattern{Ident: key}
+		if p.match(token.KindColon) {
+			p.discard()
+			value = p.parsePattern()
+		} else if quoted {
+			panic(fmt.Sprintf("when parsing record pattern: key %q needs a pattern", key))
+		}
+		rp.Keys = append(rp.Keys, key)
+		rp.Values = append(rp.Values, value)
+		if !p.match(token.KindComma) {
+			break
+		}
+		p.discard()
+	}
+	p.consume(token.KindRBrace)
+	return rp
+}
+
+// checkArms reports the arms of me that can never be chosen because an
+// earlier arm without a guard already matches every value they match. It also
+// reports matches that evidently do not cover every value: those without a
+// catch-all arm whose patterns are all literals and ranges.
+func checkArms(me *ast.MatchExpr) {
+	exhaustive, scalarOnly := false, true
+	hasTrue, hasFalse := false, false
+	for j, arm := range me.Arms {
+		for k, prev := range me.Arms[:j] {
+			if prev.Guard == nil && covers(prev.Pattern, arm.Pattern) {
+				panic(fmt.Sprintf("unreachable match arm %d: pattern %s is already covered by arm %d",
+					j+1, arm.Pattern, k+1))
+			}
+		}
+
+		switch pat := arm.Pattern.(type) {
+		case *ast.WildcardPattern, *ast.BindingPattern:
+			exhaustive = exhaustive || arm.Guard == nil
+		case *ast.LiteralPattern:
+			if arm.Guard == nil {
+				_, isTrue := pat.Value.(ast.True)
+				_, isFalse := pat.Value.(ast.False)
+				hasTrue, hasFalse = hasTrue || isTrue, hasFalse || isFalse
+			}
+		case *ast.RangePattern:
+		default:
+			scalarOnly = false
+		}
+	}
+	if !exhaustive && scalarOnly && !(hasTrue && hasFalse) {
+		panic("non-exhaustive match: add a `_` arm for the values no arm matches")
+	}
+}
+
+// covers reports whether every value matched by b is matched by a as well.
+// It answers false when that cannot be told without running the program.
+func covers(a, b ast.Pattern) bool {
+	switch a := a.(type) {
+	case *ast.WildcardPattern, *ast.BindingPattern:
+		return true
+	case *ast.LiteralPattern:
+		if b, ok := b.(*ast.LiteralPattern); ok {
+			return literalKey(a.Value) == literalKey(b.Value)
+		}
+	case *ast.RangePattern:
+		switch b := b.(type) {
+		case *ast.LiteralPattern:
+			return rangeCovers(a, b.Value, b.Value, true)
+		case *ast.RangePattern:
+			return rangeCovers(a, b.Start, b.End, b.Inclusive)
+		}
+	}
+	return false
+}
+
+// literalKey returns a string identifying the value of literal e, which tells
+// apart literals of different types with the same text, e.g. 1 and 1.0.
+func literalKey(e ast.Expr) string {
+	return fmt.Sprintf("%T:%s", e, e)
+}
+
+// rangeCovers reports whether r covers the values from lo to hi, both of
+// which are integer or character literals.
+func rangeCovers(r *ast.RangePattern, lo, hi ast.Expr, inclusive bool) bool {
+	start, ok1 := ordinal(r.Start, lo)
+	end, ok2 := ordinal(r.End, hi)
+	from, ok3 := ordinal(lo, r.Start)
+	to, ok4 := ordinal(hi, r.End)
+	if !(ok1 && ok2 && ok3 && ok4) || start.Cmp(from) > 0 {
+		return false
+	}
+	c := to.Cmp(end)
+	return c < 0 || c == 0 && (r.Inclusive || !inclusive)
+}
+
+// ordinal returns the value of e, an integer or character literal of the same
+// type as other.
+func ordinal(e, other ast.Expr) (*big.Int, bool) {
+	switch v := e.(type) {
+	case *ast.IntegerValue:
+		if _, ok := other.(*ast.IntegerValue); ok {
+			return v.Value, true
+		}
+	case ast.CharValue:
+		if _, ok := other.(ast.CharValue); ok {
+			return big.NewInt(int64(v.Value)), true
+		}
+	}
+	return nil, false
+}
@@ -9,24 +9,25 @@ import (
 )
 
 type elem struct {
+	loc  token.Location
 	kind token.Kind
 	text string
 }
 
 type lookAheadStack []elem
 
-func (s *lookAheadStack) push(kind token.Kind, text string) {
-	*s = append(*s, elem{kind, text})
+func (s *lookAheadStack) push(loc token.Location, kind token.Kind, text string) {
+	*s = append(*s, elem{loc, kind, text})
 }
 
-func (s *lookAheadStack) pop() (kind token.Kind, text string) {
+func (s *lookAheadStack) pop() (loc token.Location, kind token.Kind, text string) {
 	if s.empty() {
 		panic("no staged tokens")
 	}
 	last := len(*s) - 1
 	var e elem
 	e, *s = (*s)[last], (*s)[:last]
-	return e.kind, e.text
+	return e.loc, e.kind, e.text
 }
 
 func (s lookAheadStack) empty() bool {
@@ -36,14 +37,27 @@ func (s lookAheadStack) empty() bool {
 type Parser struct {
 	s scanner.Scanner
 
+	loc  token.Location
 	kind token.Kind
 	text string
 
+	prevLoc  token.Location
 	prevKind token.Kind
 	prevText string
 
 	lookAhead lookAheadStack
 
+	// loops holds the labels of the loops enclosing the statement being
+	// parsed, innermost last. Unlabelled loops are recorded as "".
+	loops []string
+	// barrier names what hides the loops outside of loops from break and
+	// continue, e.g. "a function". It is empty if nothing does.
+	barrier string
+	// blocks is the number of blocks enclosing the statement being parsed.
+	blocks int
+	// funcs is the number of functions enclosing the statement being parsed.
+	funcs int
+
 	Statements []ast.Stmt
 }
 
@@ -55,7 +69,27 @@ func New(file *token.File, src []byte) *Parser {
 	return p
 }
 
+// located is the panic of an error found at loc, rather than at the token
+// being parsed.
+type located struct {
+	loc token.Location
+	msg string
+}
+
+// Parse parses the statements of the source into Statements. It panics at the
+// first error with a message that starts with the position of the error.
 func (p *Parser) Parse() {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case string:
+			panic(fmt.Sprintf("%s: %s", p.loc, r))
+		case located:
+			panic(fmt.Sprintf("%s: %s", r.loc, r.msg))
+		default:
+			panic(r)
+		}
+	}()
 	for p.kind != token.KindEOF {
 		if p.kind == token.KindComment {
 			p.discard()
@@ -73,7 +107,7 @@ func (p *Parser) parseStatement() ast.Stmt {
 	if p.kind == token.KindSemicolon {
 		p.discard()
 		return &ast.EmptyStmt{}
-	} else if p.kind == token.KindLet {
+	} else if p.kind == token.KindLet || p.kind == token.KindConst {
 		return p.parseDeclStmt()
 	} else if p.kind == token.KindIdent {
 		// look ahead
@@ -86,64 +120,141 @@ func (p *Parser) parseStatement() ast.Stmt {
 		panic("dangling else")
 	} else if p.kind == token.KindWhile {
 		return p.parseWhile()
+	} else if p.kind == token.KindFor {
+		return p.parseFor()
+	} else if p.kind == token.KindMatch {
+		return p.parseMatch()
 	} else if p.kind == token.KindFn {
 		return p.branchNamedFuncOrLambda()
 	} else if p.kind == token.KindReturn {
 		return p.parseReturn()
+	} else if p.kind == token.KindBreak || p.kind == token.KindContinue {
+		return p.parseJump()
+	} else if p.kind == token.KindImport {
+		return p.parseImport()
+	} else if p.kind == token.KindThrow {
+		return p.parseThrow()
+	} else if p.kind == token.KindTry {
+		return p.parseTry()
+	} else if p.kind == token.KindCatch || p.kind == token.KindFinally {
+		panic(fmt.Sprintf("%s without try", p.text))
 	}
 	return p.parseExprStmt()
 }
 
 func (p *Parser) parseDeclStmt() ast.Stmt {
+	ls := &ast.LetStmt{
+		Loc:   p.loc,
+		Const: p.match(token.KindConst),
+	}
 	p.discard()
+	if p.matchAny(token.KindLBracket, token.KindLBrace) {
+		return p.parseDestructuringDecl(ls)
+	}
 	if !p.match(token.KindIdent) {
-		panic(fmt.Sprintf("incomplete let-statement, want an identifier but got %s", p.kind.String()))
+		panic(fmt.Sprintf("incomplete %s-statement, want an identifier but got %s", declKeyword(ls), p.kind.String()))
 	}
-	ident := p.text
+	ls.Ident = p.text
 	p.discard()
-	var init ast.Expr = ast.Nil{}
+	ls.Init = ast.Nil{}
 	if p.match(token.KindAssign) {
 		p.discard()
-		init = p.parseExpr()
+		ls.Init = p.parseExpr()
+	} else if ls.Const {
+		panic(fmt.Sprintf("incomplete const-statement, %s needs an initializer", ls.Ident))
 	}
 	p.consume(token.KindSemicolon)
-	return &ast.LetStmt{
-		Ident: ident,
-		Init:  init,
+	return ls
+}
+
+func (p *Parser) parseDestructuringDecl(ls *ast.LetStmt) ast.Stmt {
+	ls.Pattern = p.parsePattern()
+	if !p.match(token.KindAssign) {
+		panic(fmt.Sprintf("incomplete %s-statement, pattern %s needs an initializer", declKeyword(ls), ls.Pattern))
+	}
+	p.discard()
+	ls.Init = p.parseExpr()
+	p.consume(token.KindSemicolon)
+	return ls
+}
+
+func declKeyword(ls *ast.LetStmt) string {
+	if ls.Const {
+		return "const"
 	}
+	return "let"
 }
 
 func (p *Parser) branchAssignOrExpr() ast.Stmt {
 	ident := p.text
 	p.advance()
 	if p.match(token.KindAssign) {
-		return p.parseAssignStmt(ident)
+		return p.parseAssignStmt(p.prevLoc, ident)
+	} else if p.match(token.KindColon) {
+		return p.parseLabeledLoop(ident)
 	}
 	p.goBack()
 	return p.parseExprStmt()
 }
 
-func (p *Parser) parseAssignStmt(ident string) ast.Stmt {
+func (p *Parser) parseLabeledLoop(label string) ast.Stmt {
+	// skip ':'
+	p.discard()
+	if !p.matchAny(token.KindWhile, token.KindFor) {
+		panic(fmt.Sprintf("label %s must be followed by a loop, got %s", label, p.kind))
+	}
+	if p.inLoop(label) {
+		panic(fmt.Sprintf("label %s is already used by an enclosing loop", label))
+	}
+	if p.match(token.KindFor) {
+		return p.parseForWithLabel(label)
+	}
+	return p.parseWhileWithLabel(label)
+}
+
+func (p *Parser) parseAssignStmt(loc token.Location, ident string) ast.Stmt {
 	// skip '='
 	p.discard()
 	v := p.parseExpr()
 	p.consume(token.KindSemicolon)
 	return &ast.AssignStmt{
+		Loc:   loc,
 		Ident: ident,
 		Expr:  v,
 	}
 }
 
+// tailExpr is the trailing expression of a block that is not followed by
+// ';'. It becomes the value of the block.
+type tailExpr struct {
+	*ast.ExprStmt
+}
+
 func (p *Parser) parseBlock() ast.Stmt {
 	p.skipComments()
 	p.consume(token.KindLBrace)
 	p.skipComments()
+	p.blocks++
 	blk := &ast.Block{}
 	for !p.match(token.KindRBrace) {
-		blk.Statements = append(blk.Statements, p.parseStatement())
+		stmt := p.parseStatement()
+		if t, ok := stmt.(tailExpr); ok {
+			blk.Value = t.Expr
+			break
+		}
+		blk.Statements = append(blk.Statements, stmt)
 		p.skipComments()
 	}
+	p.blocks--
 	p.consume(token.KindRBrace)
+
+	// A trailing if-else or block gives its value to the enclosing block as
+	// well, as in `{ if c { 1 } else { 2 } }`.
+	if n := len(blk.Statements); blk.Value == nil && n > 0 {
+		if e, ok := blk.Statements[n-1].(ast.Expr); ok {
+			blk.Statements, blk.Value = blk.Statements[:n-1], e
+		}
+	}
 	return blk
 }
 
@@ -151,7 +262,7 @@ func (p *Parser) parseIfElse() ast.Stmt {
 	p.discard()
 	cond := p.parseExpr()
 	thenArm := p.parseBlock()
-	var elseArm ast.Stmt = ast.EmptyStmt{}
+	var elseArm ast.Stmt = &ast.EmptyStmt{}
 	if p.match(token.KindElse) {
 		p.discard()
 		if p.match(token.KindIf) {
@@ -170,12 +281,94 @@ func (p *Parser) parseIfElse() ast.Stmt {
 }
 
 func (p *Parser) parseWhile() ast.Stmt {
+	return p.parseWhileWithLabel("")
+}
+
+func (p *Parser) parseWhileWithLabel(label string) ast.Stmt {
 	p.discard()
 	cond := p.parseExpr()
-	body := p.parseBlock()
+	body := p.parseLoopBody(label)
 	return &ast.WhileStmt{
-		Cond: cond,
-		Body: body,
+		Label: label,
+		Cond:  cond,
+		Body:  body,
+	}
+}
+
+func (p *Parser) parseFor() ast.Stmt {
+	return p.parseForWithLabel("")
+}
+
+func (p *Parser) parseForWithLabel(label string) ast.Stmt {
+	p.discard()
+	if !p.match(token.KindIdent) {
+		panic(fmt.Sprintf("when parsing for-loop: want %s, got %s", token.KindIdent, p.kind))
+	}
+	name := p.text
+	p.discard()
+	p.consume(token.KindIn)
+	iter := p.parseExpr()
+	body := p.parseLoopBody(label)
+	return &ast.ForStmt{
+		Label: label,
+		Var:   name,
+		Iter:  iter,
+		Body:  body,
+	}
+}
+
+func (p *Parser) parseLoopBody(label string) ast.Stmt {
+	p.loops = append(p.loops, label)
+	defer func() {
+		p.loops = p.loops[:len(p.loops)-1]
+	}()
+	return p.parseBlock()
+}
+
+func (p *Parser) parseJump() ast.Stmt {
+	loc, kind, word := p.loc, p.kind, "break"
+	if kind == token.KindContinue {
+		word = "continue"
+	}
+	p.discard()
+	label := ""
+	if p.match(token.KindIdent) {
+		label = p.text
+		p.discard()
+	}
+	p.consume(token.KindSemicolon)
+
+	if len(p.loops) == 0 || label != "" && !p.inLoop(label) {
+		if p.barrier != "" {
+			panic(located{loc, fmt.Sprintf("%s cannot jump out of %s", word, p.barrier)})
+		} else if label != "" {
+			panic(located{loc, fmt.Sprintf("%s to unknown label %s", word, label)})
+		}
+		panic(located{loc, word + " outside of a loop"})
+	}
+	if kind == token.KindBreak {
+		return &ast.BreakStmt{Label: label}
+	}
+	return &ast.ContinueStmt{Label: label}
+}
+
+func (p *Parser) inLoop(label string) bool {
+	for _, l := range p.loops {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// hideLoops hides the enclosing loops from break and continue statements
+// while parsing a function body or a block used as an expression, neither of
+// which they may jump out of. The returned func restores them.
+func (p *Parser) hideLoops(barrier string) (restore func()) {
+	outerLoops, outerBarrier := p.loops, p.barrier
+	p.loops, p.barrier = nil, barrier
+	return func() {
+		p.loops, p.barrier = outerLoops, outerBarrier
 	}
 }
 
@@ -190,6 +383,7 @@ func (p *Parser) branchNamedFuncOrLambda() ast.Stmt {
 }
 
 func (p *Parser) parseFunction() ast.Stmt {
+	loc := p.loc
 	p.discard()
 	if !p.match(token.KindIdent) {
 		panic(fmt.Sprintf("when parsing function definition: want %s, got %s", token.KindIdent, p.kind))
@@ -197,34 +391,152 @@ func (p *Parser) parseFunction() ast.Stmt {
 	name := p.text
 	p.discard()
 	p.consume(token.KindLParen)
-	params := p.parseIdentList()
+	params := p.parseParamList()
 	p.consume(token.KindRParen)
+	restore := p.hideLoops("a function")
+	p.funcs++
 	body := p.parseBlock()
+	p.funcs--
+	restore()
 	return &ast.FnStmt{
+		Loc:    loc,
 		Ident:  name,
 		Params: params,
 		Body:   body,
 	}
 }
 
-func (p *Parser) parseIdentList() (ans []string) {
-	if p.match(token.KindRParen) {
-		return
+func (p *Parser) parseParamList() (ans []*ast.Param) {
+	for !p.match(token.KindRParen) {
+		if len(ans) > 0 {
+			p.consume(token.KindComma)
+		}
+		pm := p.parseParam()
+		if len(ans) > 0 {
+			prev := ans[len(ans)-1]
+			if prev.Variadic {
+				panic(fmt.Sprintf("variadic parameter %s must be the last one", prev))
+			}
+			if prev.Default != nil && pm.Default == nil && !pm.Variadic {
+				panic(fmt.Sprintf("parameter %s without default follows parameter %s", pm, prev))
+			}
+		}
+		for _, prev := range ans {
+			if pm.Name != "" && prev.Name == pm.Name {
+				panic(fmt.Sprintf("duplicate parameter %s", pm.Name))
+			}
+		}
+		ans = append(ans, pm)
 	}
-	if !p.match(token.KindIdent) {
-		panic(fmt.Sprintf("when parsing identifier list: want %s, got %s", token.KindIdent, p.kind))
+	return
+}
+
+// parseParam parses a parameter, which is an identifier or a list or record
+// pattern destructuring the argument, optionally followed by a default. A
+// variadic parameter is an identifier preceded by '...'.
+func (p *Parser) parseParam() *ast.Param {
+	pm := &ast.Param{}
+	if p.match(token.KindEllipsis) {
+		p.discard()
+		pm.Variadic = true
+	}
+	if !pm.Variadic && p.matchAny(token.KindLBracket, token.KindLBrace) {
+		pm.Pattern = p.parsePattern()
+	} else if p.match(token.KindIdent) {
+		pm.Name = p.text
+		p.discard()
+	} else {
+		panic(fmt.Sprintf("when parsing parameter list: want %s, got %s", token.KindIdent, p.kind))
 	}
-	ans = append(ans, p.text)
+	if p.match(token.KindAssign) {
+		if pm.Variadic {
+			panic(fmt.Sprintf("variadic parameter %s cannot have a default", pm))
+		}
+		p.discard()
+		pm.Default = p.parseExpr()
+	}
+	return pm
+}
+
+func (p *Parser) parseImport() ast.Stmt {
+	if p.blocks > 0 || p.barrier != "" {
+		panic("import must be at the top level of a file")
+	}
+	is := &ast.ImportStmt{Loc: p.loc}
 	p.discard()
-	for !p.match(token.KindRParen) {
-		p.consume(token.KindComma)
+	if p.match(token.KindLBrace) {
+		is.Names = p.parseImportNames()
+		if !p.match(token.KindIdent) || p.text != "from" {
+			panic(fmt.Sprintf("when parsing import: want from after the imported names, got %s", p.kind))
+		}
+		p.discard()
+	}
+	if p.match(token.KindString) {
+		is.Path = ast.NewStringValue(p.text).Value
+	} else if p.match(token.KindIdent) {
+		is.Path = p.text
+	} else {
+		panic(fmt.Sprintf("when parsing import: want a module path, got %s", p.kind))
+	}
+	p.discard()
+	if len(is.Names) > 0 {
+		p.consume(token.KindSemicolon)
+		return is
+	}
+	if p.match(token.KindAs) {
+		p.discard()
 		if !p.match(token.KindIdent) {
-			panic(fmt.Sprintf("when parsing identifier list: want %s, got %s", token.KindIdent, p.kind))
+			panic(fmt.Sprintf("when parsing import: want %s after as, got %s", token.KindIdent, p.kind))
 		}
-		ans = append(ans, p.text)
+		is.Alias = p.text
 		p.discard()
+	} else if !isIdentifier(is.Name()) {
+		panic(fmt.Sprintf("module %q needs an alias: import %q as name;", is.Path, is.Path))
 	}
-	return
+	p.consume(token.KindSemicolon)
+	return is
+}
+
+// isIdentifier reports whether s is spelled as an identifier.
+func isIdentifier(s string) bool {
+	sc := scanner.New(nil, []byte(s))
+	_, kind, text := sc.Scan()
+	return kind == token.KindIdent && text == s
+}
+
+// parseImportNames parses the members listed in `import {a, b as c} from m;`.
+func (p *Parser) parseImportNames() (names []*ast.ImportName) {
+	p.consume(token.KindLBrace)
+	seen := make(map[string]bool)
+	for !p.match(token.KindRBrace) {
+		if !p.match(token.KindIdent) {
+			panic(fmt.Sprintf("when parsing import: want a name, got %s", p.kind))
+		}
+		in := &ast.ImportName{Loc: p.loc, Name: p.text}
+		p.discard()
+		if p.match(token.KindAs) {
+			p.discard()
+			if !p.match(token.KindIdent) {
+				panic(fmt.Sprintf("when parsing import: want %s after as, got %s", token.KindIdent, p.kind))
+			}
+			in.Alias = p.text
+			p.discard()
+		}
+		if seen[in.Binding()] {
+			panic(located{in.Loc, in.Binding() + " is imported more than once"})
+		}
+		seen[in.Binding()] = true
+		names = append(names, in)
+		if !p.match(token.KindComma) {
+			break
+		}
+		p.discard()
+	}
+	p.consume(token.KindRBrace)
+	if len(names) == 0 {
+		panic("when parsing import: want at least one name between braces")
+	}
+	return names
 }
 
 func (p *Parser) parseReturn() ast.Stmt {
@@ -236,10 +548,46 @@ func (p *Parser) parseReturn() ast.Stmt {
 	}
 }
 
+func (p *Parser) parseThrow() ast.Stmt {
+	ts := &ast.ThrowStmt{Loc: p.loc}
+	p.discard()
+	ts.Value = p.parseExpr()
+	p.consume(token.KindSemicolon)
+	return ts
+}
+
+func (p *Parser) parseTry() ast.Stmt {
+	p.discard()
+	ts := &ast.TryStmt{
+		Body: p.parseBlock(),
+	}
+	p.skipComments()
+	if p.match(token.KindCatch) {
+		p.discard()
+		if p.match(token.KindIdent) {
+			ts.Var = p.text
+			p.discard()
+		}
+		ts.Catch = p.parseBlock()
+		p.skipComments()
+	}
+	if p.match(token.KindFinally) {
+		p.discard()
+		ts.Finally = p.parseBlock()
+	}
+	if ts.Catch == nil && ts.Finally == nil {
+		panic(fmt.Sprintf("try needs a catch or finally block, got %s", p.kind))
+	}
+	return ts
+}
+
 func (p *Parser) parseExprStmt() ast.Stmt {
 	s := &ast.ExprStmt{
 		Expr: p.parseExpr(),
 	}
+	if p.blocks > 0 && p.match(token.KindRBrace) {
+		return tailExpr{s}
+	}
 	p.consume(token.KindSemicolon)
 	return s
 }
@@ -254,8 +602,13 @@ func (p *Parser) parseLambda() (ans ast.Expr) {
 	}
 	p.consume(token.KindFn)
 	p.consume(token.KindLParen)
-	params := p.parseIdentList()
+	params := p.parseParamList()
 	p.consume(token.KindRParen)
+	defer p.hideLoops("a function")()
+	p.funcs++
+	defer func() {
+		p.funcs--
+	}()
 	var body ast.Stmt
 	if p.match(token.KindLtRArrow) {
 		p.discard()
@@ -283,9 +636,11 @@ func (p *Parser) parseLogical() ast.Expr {
 func (p *Parser) parseOrClause() (ans ast.Expr) {
 	ans = p.parseAndClause()
 	for p.matchAny(token.KindOr) {
+		loc := p.loc
 		p.discard()
 		rhs := p.parseAndClause()
 		ans = &ast.BinaryExpr{
+			Loc: loc,
 			Lhs: ans,
 			Rhs: rhs,
 			Op:  token.KindOr,
@@ -297,9 +652,11 @@ func (p *Parser) parseOrClause() (ans ast.Expr) {
 func (p *Parser) parseAndClause() (ans ast.Expr) {
 	ans = p.parseRelational()
 	for p.matchAny(token.KindAnd) {
+		loc := p.loc
 		p.discard()
 		rhs := p.parseRelational()
 		ans = &ast.BinaryExpr{
+			Loc: loc,
 			Lhs: ans,
 			Rhs: rhs,
 			Op:  token.KindAnd,
@@ -309,12 +666,13 @@ func (p *Parser) parseAndClause() (ans ast.Expr) {
 }
 
 func (p *Parser) parseRelational() (ans ast.Expr) {
-	ans = p.parseTerm()
+	ans = p.parseRange()
 	for p.matchAny(token.KindEq, token.KindNe, token.KindLt, token.KindGt, token.KindLe, token.KindGe) {
-		op := p.kind
+		op, loc := p.kind, p.loc
 		p.discard()
-		rhs := p.parseTerm()
+		rhs := p.parseRange()
 		ans = &ast.BinaryExpr{
+			Loc: loc,
 			Lhs: ans,
 			Rhs: rhs,
 			Op:  op,
@@ -323,13 +681,35 @@ func (p *Parser) parseRelational() (ans ast.Expr) {
 	return
 }
 
+func (p *Parser) parseRange() (ans ast.Expr) {
+	ans = p.parseTerm()
+	if !p.matchAny(token.KindRange, token.KindRangeIncl) {
+		return
+	}
+	re := &ast.RangeExpr{
+		Loc:       p.loc,
+		Start:     ans,
+		Inclusive: p.match(token.KindRangeIncl),
+	}
+	p.discard()
+	re.End = p.parseTerm()
+	// "step" is only special right after a range, so it remains usable as an
+	// ordinary identifier elsewhere.
+	if p.match(token.KindIdent) && p.text == "step" {
+		p.discard()
+		re.Step = p.parseTerm()
+	}
+	return re
+}
+
 func (p *Parser) parseTerm() (ans ast.Expr) {
 	ans = p.parseFactor()
 	for p.matchAny(token.KindAdd, token.KindSub) {
-		op := p.kind
+		op, loc := p.kind, p.loc
 		p.discard()
 		rhs := p.parseFactor()
 		ans = &ast.BinaryExpr{
+			Loc: loc,
 			Lhs: ans,
 			Rhs: rhs,
 			Op:  op,
@@ -341,10 +721,11 @@ func (p *Parser) parseTerm() (ans ast.Expr) {
 func (p *Parser) parseFactor() (ans ast.Expr) {
 	ans = p.parseUnary()
 	for p.matchAny(token.KindMul, token.KindDiv, token.KindMod) {
-		op := p.kind
+		op, loc := p.kind, p.loc
 		p.discard()
 		rhs := p.parseUnary()
 		ans = &ast.BinaryExpr{
+			Loc: loc,
 			Lhs: ans,
 			Rhs: rhs,
 			Op:  op,
@@ -357,45 +738,84 @@ func (p *Parser) parseUnary() (ans ast.Expr) {
 	if !p.matchAny(token.KindNot, token.KindSub) {
 		return p.parseCall()
 	}
-	op := p.kind
+	op, loc := p.kind, p.loc
 	p.discard()
 	return &ast.UnaryExpr{
-		X:  p.parseUnary(),
-		Op: op,
+		Loc: loc,
+		X:   p.parseUnary(),
+		Op:  op,
 	}
 }
 
-func (p *Parser) parseCall() ast.Expr {
-	if !p.match(token.KindIdent) {
-		return p.parsePrimary()
-	}
-	ident := p.text
-	p.advance()
-	if p.match(token.KindLParen) {
-		p.discard()
-		args := p.parseExprList()
-		p.consume(token.KindRParen)
-		return &ast.CallExpr{
-			Callee: ident,
-			Args:   args,
+// parseCall parses a primary expression followed by any number of calls and
+// member accesses, as in `m.make(1).name`.
+func (p *Parser) parseCall() (ans ast.Expr) {
+	loc := p.loc
+	ans = p.parsePrimary()
+	for {
+		if p.match(token.KindLParen) {
+			p.discard()
+			args, names := p.parseArgList()
+			p.consume(token.KindRParen)
+			ans = &ast.CallExpr{
+				Loc:      loc,
+				Callee:   ans,
+				Args:     args,
+				ArgNames: names,
+			}
+		} else if p.match(token.KindDot) {
+			dot := p.loc
+			p.discard()
+			if !p.match(token.KindIdent) {
+				panic(fmt.Sprintf("when parsing member access: want %s, got %s", token.KindIdent, p.kind))
+			}
+			ans = &ast.MemberExpr{
+				Loc:  dot,
+				X:    ans,
+				Name: p.text,
+			}
+			p.discard()
+		} else if p.match(token.KindQuestion) {
+			if p.funcs == 0 {
+				panic("the ? operator can only be used inside a function")
+			}
+			p.discard()
+			ans = &ast.PropagateExpr{
+				X: ans,
+			}
+		} else {
+			return
 		}
 	}
-	// If current token is not '(', leave it unchanged and return a Variable.
-	// NOTE: although assignment statements also start with IDENT's, call sites
-	// of parseCall can eliminate the possibility.
-	return &ast.Variable{
-		Ident: ident,
-	}
 }
 
-func (p *Parser) parseExprList() (ans []ast.Expr) {
-	if p.match(token.KindRParen) {
-		return
-	}
-	ans = append(ans, p.parseExpr())
+// parseArgList parses the arguments of a call. names is nil unless some of
+// the arguments are named, as in `f(1, b: 2)`.
+func (p *Parser) parseArgList() (args []ast.Expr, names []string) {
 	for !p.match(token.KindRParen) {
-		p.consume(token.KindComma)
-		ans = append(ans, p.parseExpr())
+		if len(args) > 0 {
+			p.consume(token.KindComma)
+		}
+		name := ""
+		if p.match(token.KindIdent) && p.peek(1) == token.KindColon {
+			name = p.text
+			p.discard()
+			p.discard()
+			if names == nil {
+				names = make([]string, len(args))
+			}
+			for _, prev := range names {
+				if prev == name {
+					panic(fmt.Sprintf("argument %s is given more than once", name))
+				}
+			}
+		} else if names != nil {
+			panic("positional argument follows named argument")
+		}
+		args = append(args, p.parseExpr())
+		if names != nil {
+			names = append(names, name)
+		}
 	}
 	return
 }
@@ -403,6 +823,20 @@ func (p *Parser) parseExprList() (ans []ast.Expr) {
 func (p *Parser) parsePrimary() (ans ast.Expr) {
 	if p.match(token.KindLParen) {
 		return p.parseGroupingExpr()
+	} else if p.match(token.KindLBracket) {
+		return p.parseList()
+	} else if p.match(token.KindLBrace) {
+		if p.isMapLiteral() {
+			return p.parseMap()
+		}
+		defer p.hideLoops("an expression")()
+		return p.parseBlock().(ast.Expr)
+	} else if p.match(token.KindIf) {
+		defer p.hideLoops("an expression")()
+		return p.parseIfElse().(ast.Expr)
+	} else if p.match(token.KindMatch) {
+		defer p.hideLoops("an expression")()
+		return p.parseMatch()
 	}
 
 	if p.match(token.KindInt) {
@@ -417,17 +851,108 @@ func (p *Parser) parsePrimary() (ans ast.Expr) {
 		ans = ast.True{}
 	} else if p.match(token.KindFalse) {
 		ans = ast.False{}
+	} else if p.match(token.KindNil) {
+		ans = ast.Nil{}
 	} else if p.match(token.KindIdent) {
+		loc, text := p.loc, p.text
+		if p.splitsQuestion() {
+			return &ast.Variable{
+				Loc:   loc,
+				Ident: text[:len(text)-1],
+			}
+		}
 		ans = &ast.Variable{
+			Loc:   loc,
 			Ident: p.text,
 		}
 	} else {
-		panic("unreachable")
+		panic(fmt.Sprintf("want an expression, got %s", p.kind))
 	}
 	p.discard()
 	return
 }
 
+func (p *Parser) parseList() ast.Expr {
+	p.consume(token.KindLBracket)
+	l := &ast.ListExpr{}
+	for !p.match(token.KindRBracket) {
+		l.Elems = append(l.Elems, p.parseExpr())
+		if !p.match(token.KindComma) {
+			break
+		}
+		p.discard()
+	}
+	p.consume(token.KindRBracket)
+	return l
+}
+
+// splitsQuestion reports whether the current token, an identifier ending in
+// '?' such as `r?`, is an identifier followed by the ? operator. That is the
+// case unless a function named with the '?' is being called, as in
+// `empty?(xs)`. If so, it advances to a ? token that stands for the operator.
+func (p *Parser) splitsQuestion() bool {
+	n := len(p.text)
+	if n < 2 || p.text[n-1] != '?' || p.peek(1) == token.KindLParen {
+		return false
+	}
+	loc, text := p.loc, p.text
+	p.discard()
+	p.lookAhead.push(p.loc, p.kind, p.text)
+	loc.Column += n - 1
+	p.loc, p.kind, p.text = loc, token.KindQuestion, text
+	return true
+}
+
+// isMapLiteral tells a map literal from a block when the current token is
+// '{'. `{}` is an empty map, and a map starts with a key followed by ':'
+// unless that is the label of a loop.
+func (p *Parser) isMapLiteral() bool {
+	switch p.peek(1) {
+	case token.KindRBrace:
+		return true
+	case token.KindIdent:
+		return p.peek(2) == token.KindColon && !p.isLoopKind(p.peek(3))
+	case token.KindInt, token.KindChar, token.KindString, token.KindTrue, token.KindFalse:
+		return p.peek(2) == token.KindColon
+	}
+	return false
+}
+
+func (p *Parser) isLoopKind(kind token.Kind) bool {
+	return kind == token.KindWhile || kind == token.KindFor
+}
+
+func (p *Parser) parseMap() ast.Expr {
+	p.consume(token.KindLBrace)
+	p.skipComments()
+	m := &ast.MapExpr{}
+	for !p.match(token.KindRBrace) {
+		m.Keys = append(m.Keys, p.parseMapKey())
+		p.consume(token.KindColon)
+		m.Values = append(m.Values, p.parseExpr())
+		p.skipComments()
+		if !p.match(token.KindComma) {
+			break
+		}
+		p.discard()
+		p.skipComments()
+	}
+	p.consume(token.KindRBrace)
+	return m
+}
+
+func (p *Parser) parseMapKey() ast.Expr {
+	if p.match(token.KindIdent) {
+		key := ast.StringValue{Value: p.text}
+		p.discard()
+		return key
+	}
+	if !p.matchAny(token.KindInt, token.KindChar, token.KindString, token.KindTrue, token.KindFalse) {
+		panic(fmt.Sprintf("when parsing map literal: want a literal key, got %s", p.kind))
+	}
+	return p.parsePrimary()
+}
+
 func (p *Parser) parseGroupingExpr() (ans *ast.GroupingExpr) {
 	p.consume(token.KindLParen)
 	e := p.parseExpr()
@@ -439,10 +964,30 @@ func (p *Parser) parseGroupingExpr() (ans *ast.GroupingExpr) {
 
 func (p *Parser) nextToken() {
 	if !p.lookAhead.empty() {
-		p.kind, p.text = p.lookAhead.pop()
+		p.loc, p.kind, p.text = p.lookAhead.pop()
 		return
 	}
-	_, p.kind, p.text = p.s.Scan()
+	p.loc, p.kind, p.text = p.s.Scan()
+}
+
+// peek returns the kind of the n-th token after the current one, not counting
+// comments, without consuming any token.
+func (p *Parser) peek(n int) token.Kind {
+	loc, kind, text := p.loc, p.kind, p.text
+	ahead := make([]elem, 0, n)
+	for j := 0; j < n; {
+		p.nextToken()
+		ahead = append(ahead, elem{p.loc, p.kind, p.text})
+		if p.kind != token.KindComment {
+			j++
+		}
+	}
+	ans := p.kind
+	for j := len(ahead) - 1; j >= 0; j-- {
+		p.lookAhead.push(ahead[j].loc, ahead[j].kind, ahead[j].text)
+	}
+	p.loc, p.kind, p.text = loc, kind, text
+	return ans
 }
 
 func (p *Parser) match(kind token.Kind) bool {
@@ -476,13 +1021,13 @@ func (p *Parser) consumeMany(kinds ...token.Kind) {
 }
 
 func (p *Parser) advance() {
-	p.prevKind, p.prevText = p.kind, p.text
+	p.prevLoc, p.prevKind, p.prevText = p.loc, p.kind, p.text
 	p.nextToken()
 }
 
 func (p *Parser) goBack() {
-	p.lookAhead.push(p.kind, p.text)
-	p.kind, p.text = p.prevKind, p.prevText
+	p.lookAhead.push(p.loc, p.kind, p.text)
+	p.loc, p.kind, p.text = p.prevLoc, p.prevKind, p.prevText
 }
 
 func (p *Parser) skipComments() {
@@ -10,6 +10,24 @@ import (
 )
 
 func TestParser_Parse(t *testing.T) {
+	Convey("errors start with their positions", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "let x = ;", want: "a.nv:1:9: want an expression, got SEMICOLON"},
+			{src: "let x = 1;\nlet = 2;", want: "a.nv:2:5: incomplete let-statement, want an identifier but got ASSIGN"},
+			{src: "let x = 1", want: "a.nv:1:10: expect token: SEMICOLON, actual: EOF"},
+			{src: "if true { 1 } else;", want: "a.nv:1:19: incomplete else"},
+			{src: "fn f() {\n    break;\n}", want: "a.nv:2:5: break cannot jump out of a function"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				p := New(token.NewFile("a.nv"), []byte(tc.src))
+				So(func() { p.Parse() }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
 }
 
 func TestParser_parseStmt(t *testing.T) {
@@ -64,5 +82,400 @@ func TestParser_parseDeclStmt(t *testing.T) {
 			So(ds.Ident, ShouldEqual, "a")
 			So(ds.Init, ShouldHaveSameTypeAs, ast.Nil{})
 		})
+
+		Convey("const", func() {
+			p := New(nil, []byte("const [a, b] = xs;"))
+			ds := p.parseDeclStmt().(*ast.LetStmt)
+			So(ds.Const, ShouldBeTrue)
+			So(ds.Names(), ShouldResemble, []string{"a", "b"})
+		})
+	})
+
+	Convey("invalid", t, func() {
+		Convey("const w/o init", func() {
+			p := New(nil, []byte("const a;"))
+			So(func() { p.parseDeclStmt() }, ShouldPanicWith, "incomplete const-statement, a needs an initializer")
+		})
+	})
+}
+
+func TestParser_parseJump(t *testing.T) {
+	Convey("valid", t, func() {
+		Convey("labelled", func() {
+			p := New(nil, []byte("outer: while true { while true { break outer; } }"))
+			s := p.parseStatement()
+			ws, ok := s.(*ast.WhileStmt)
+			So(ok, ShouldBeTrue)
+			So(ws.Label, ShouldEqual, "outer")
+			inner := ws.Body.(*ast.Block).Statements[0].(*ast.WhileStmt)
+			bs, ok := inner.Body.(*ast.Block).Statements[0].(*ast.BreakStmt)
+			So(ok, ShouldBeTrue)
+			So(bs.Label, ShouldEqual, "outer")
+		})
+	})
+
+	Convey("invalid", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+		}{
+			{name: "outside of loop", src: "break;"},
+			{name: "unknown label", src: "while true { continue outer; }"},
+			{name: "across function", src: "while true { fn f() { break; } }"},
+			{name: "across lambda", src: "while true { let f = fn () { continue; }; }"},
+			{name: "duplicate label", src: "l: while true { l: while true { } }"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				p := New(nil, []byte(tc.src))
+				So(func() { p.Parse() }, ShouldPanic)
+			})
+		}
+	})
+}
+
+func TestParser_parseFor(t *testing.T) {
+	Convey("range with step", t, func() {
+		p := New(nil, []byte("for i in 0..=n + 1 step 2 { }"))
+		s := p.parseStatement()
+		fs, ok := s.(*ast.ForStmt)
+		So(ok, ShouldBeTrue)
+		So(fs.Var, ShouldEqual, "i")
+		re, ok := fs.Iter.(*ast.RangeExpr)
+		So(ok, ShouldBeTrue)
+		So(re.Inclusive, ShouldBeTrue)
+		So(re.End, ShouldHaveSameTypeAs, &ast.BinaryExpr{})
+		So(re.Step, ShouldNotBeNil)
+	})
+
+	Convey("labelled", t, func() {
+		p := New(nil, []byte("rows: for r in [1, 2] { for c in {a: 1} { continue rows; } }"))
+		s := p.parseStatement()
+		fs, ok := s.(*ast.ForStmt)
+		So(ok, ShouldBeTrue)
+		So(fs.Label, ShouldEqual, "rows")
+		So(fs.Iter, ShouldHaveSameTypeAs, &ast.ListExpr{})
+	})
+}
+
+func TestParser_parseBlock(t *testing.T) {
+	Convey("trailing expression", t, func() {
+		p := New(nil, []byte("{ let a = 1; a + 1 }"))
+		blk := p.parseBlock().(*ast.Block)
+		So(blk.Statements, ShouldHaveLength, 1)
+		So(blk.Value, ShouldHaveSameTypeAs, &ast.BinaryExpr{})
+	})
+
+	Convey("trailing if-else", t, func() {
+		p := New(nil, []byte("{ if c { 1 } else { 2 } }"))
+		blk := p.parseBlock().(*ast.Block)
+		So(blk.Statements, ShouldBeEmpty)
+		So(blk.Value, ShouldHaveSameTypeAs, &ast.IfElseStmt{})
+	})
+
+	Convey("no value", t, func() {
+		p := New(nil, []byte("{ a + 1; }"))
+		blk := p.parseBlock().(*ast.Block)
+		So(blk.Statements, ShouldHaveLength, 1)
+		So(blk.Value, ShouldBeNil)
+	})
+}
+
+func TestParser_parsePrimary(t *testing.T) {
+	Convey("blocks and maps", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want any
+		}{
+			{name: "empty map", src: "{}", want: &ast.MapExpr{}},
+			{name: "map", src: "{a: 1}", want: &ast.MapExpr{}},
+			{name: "block", src: "{ a }", want: &ast.Block{}},
+			{name: "block with labelled loop", src: "{ a: while true { } }", want: &ast.Block{}},
+			{name: "map after a comment", src: "{ # c\n a: 1 }", want: &ast.MapExpr{}},
+			{name: "block after a comment", src: "{ # c\n a }", want: &ast.Block{}},
+			{name: "if-else", src: "if a { 1 } else { 2 }", want: &ast.IfElseStmt{}},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				p := New(nil, []byte(tc.src))
+				So(p.parseExpr(), ShouldHaveSameTypeAs, tc.want)
+			})
+		}
+	})
+
+	Convey("comments between the entries of maps", t, func() {
+		p := New(nil, []byte("{ # c\n a: 1, # d\n b: 2 # e\n }"))
+		m := p.parseExpr().(*ast.MapExpr)
+		So(m.Keys, ShouldResemble, []ast.Expr{ast.StringValue{Value: "a"}, ast.StringValue{Value: "b"}})
+	})
+
+	Convey("break across an expression", t, func() {
+		p := New(nil, []byte("while true { let x = if true { break; } else { 1 }; }"))
+		So(func() { p.Parse() }, ShouldPanicWith, "<unknown>:1:32: break cannot jump out of an expression")
+	})
+}
+
+func TestParser_parseMatch(t *testing.T) {
+	Convey("patterns", t, func() {
+		p := New(nil, []byte(`match v {
+    -1 => a,
+    'a'..='z' => b,
+    [x, ...rest] if x > 0 => { c }
+    {x, y: 0} => d,
+    _ => e,
+}`))
+		me, ok := p.parseExpr().(*ast.MatchExpr)
+		So(ok, ShouldBeTrue)
+		So(me.Arms, ShouldHaveLength, 5)
+		So(me.Arms[0].Pattern.String(), ShouldEqual, "-1")
+		So(me.Arms[1].Pattern, ShouldHaveSameTypeAs, &ast.RangePattern{})
+		lp := me.Arms[2].Pattern.(*ast.ListPattern)
+		So(lp.HasRest, ShouldBeTrue)
+		So(lp.Rest, ShouldEqual, "rest")
+		So(me.Arms[2].Guard, ShouldNotBeNil)
+		So(me.Arms[3].Pattern.String(), ShouldEqual, "{x, y: 0}")
+		So(me.Arms[4].Pattern, ShouldHaveSameTypeAs, &ast.WildcardPattern{})
+	})
+
+	Convey("exhaustive booleans", t, func() {
+		p := New(nil, []byte("match c { true => 1, false => 0 }"))
+		So(func() { p.parseExpr() }, ShouldNotPanic)
+	})
+
+	Convey("invalid", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{
+				name: "arm after catch-all",
+				src:  "match v { x => 1, 2 => 2 }",
+				want: "unreachable match arm 2: pattern 2 is already covered by arm 1",
+			},
+			{
+				name: "duplicate literal",
+				src:  `match v { "a" => 1, "b" => 2, "a" => 3, _ => 4 }`,
+				want: `unreachable match arm 3: pattern "a" is already covered by arm 1`,
+			},
+			{
+				name: "literal in range",
+				src:  "match v { 0..10 => 1, 5 => 2, _ => 3 }",
+				want: "unreachable match arm 2: pattern 5 is already covered by arm 1",
+			},
+			{
+				name: "literals only",
+				src:  "match v { 1 => 1, 2..5 => 2 }",
+				want: "non-exhaustive match: add a `_` arm for the values no arm matches",
+			},
+			{
+				name: "guarded catch-all",
+				src:  "match v { 1 => 1, x if x > 1 => 2 }",
+				want: "non-exhaustive match: add a `_` arm for the values no arm matches",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				p := New(nil, []byte(tc.src))
+				So(func() { p.parseExpr() }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+}
+
+func TestParser_parseDestructuring(t *testing.T) {
+	Convey("let", t, func() {
+		p := New(nil, []byte("let [a, ...rest] = xs;"))
+		ds, ok := p.parseDeclStmt().(*ast.LetStmt)
+		So(ok, ShouldBeTrue)
+		So(ds.Pattern, ShouldHaveSameTypeAs, &ast.ListPattern{})
+		So(ds.Pattern.String(), ShouldEqual, "[a, ...rest]")
+	})
+
+	Convey("let without initializer", t, func() {
+		p := New(nil, []byte("let {x, y};"))
+		So(func() { p.parseDeclStmt() }, ShouldPanic)
+	})
+
+	Convey("parameters", t, func() {
+		p := New(nil, []byte("fn f(a, {x, y}, [b, c]) { }"))
+		fs, ok := p.parseStatement().(*ast.FnStmt)
+		So(ok, ShouldBeTrue)
+		So(fs.Params, ShouldHaveLength, 3)
+		So(fs.Params[0].Name, ShouldEqual, "a")
+		So(fs.Params[1].Pattern, ShouldHaveSameTypeAs, &ast.RecordPattern{})
+		So(fs.Params[2].Pattern, ShouldHaveSameTypeAs, &ast.ListPattern{})
+	})
+}
+
+func TestParser_parseParamList(t *testing.T) {
+	Convey("valid", t, func() {
+		p := New(nil, []byte("fn f(a, b = 10, ...rest) { }"))
+		fs := p.parseStatement().(*ast.FnStmt)
+		So(ast.ParamList(fs.Params).String(), ShouldEqual, "a, b = 10, ...rest")
+		So(fs.Params[1].Default, ShouldNotBeNil)
+		So(fs.Params[2].Variadic, ShouldBeTrue)
+	})
+
+	Convey("invalid", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{name: "required after default", src: "fn f(a = 1, b) { }", want: "parameter b without default follows parameter a = 1"},
+			{name: "variadic not last", src: "fn f(...a, b) { }", want: "variadic parameter ...a must be the last one"},
+			{name: "variadic default", src: "fn f(...a = 1) { }", want: "variadic parameter ...a cannot have a default"},
+			{name: "duplicate", src: "fn f(a, a) { }", want: "duplicate parameter a"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				p := New(nil, []byte(tc.src))
+				So(func() { p.parseStatement() }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+}
+
+func TestParser_parseArgList(t *testing.T) {
+	Convey("named", t, func() {
+		p := New(nil, []byte("f(1, c: 3, b: 2)"))
+		call := p.parseExpr().(*ast.CallExpr)
+		So(call.ArgNames, ShouldResemble, []string{"", "c", "b"})
+		So(call.String(), ShouldEqual, "f(1, c: 3, b: 2)")
+	})
+
+	Convey("positional only", t, func() {
+		p := New(nil, []byte("f(1, {a: 2})"))
+		call := p.parseExpr().(*ast.CallExpr)
+		So(call.ArgNames, ShouldBeNil)
+	})
+
+	Convey("positional after named", t, func() {
+		p := New(nil, []byte("f(a: 1, 2)"))
+		So(func() { p.parseExpr() }, ShouldPanicWith, "positional argument follows named argument")
+	})
+}
+
+func TestParser_parseImport(t *testing.T) {
+	Convey("valid", t, func() {
+		testCases := []struct {
+			src  string
+			path string
+			name string
+		}{
+			{src: `import "lib/strutil";`, path: "lib/strutil", name: "strutil"},
+			{src: `import "lib/str-util.nv" as su;`, path: "lib/str-util.nv", name: "su"},
+			{src: `import mathx as m;`, path: "mathx", name: "m"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				p := New(nil, []byte(tc.src))
+				is, ok := p.parseStatement().(*ast.ImportStmt)
+				So(ok, ShouldBeTrue)
+				So(is.Path, ShouldEqual, tc.path)
+				So(is.Name(), ShouldEqual, tc.name)
+			})
+		}
+	})
+
+	Convey("invalid", t, func() {
+		Convey("name is not an identifier", func() {
+			p := New(nil, []byte(`import "lib/str-util";`))
+			So(func() { p.parseStatement() }, ShouldPanic)
+		})
+
+		Convey("name imported twice", func() {
+			p := New(nil, []byte(`import {a, b as a} from m;`))
+			So(func() { p.Parse() }, ShouldPanicWith, "<unknown>:1:12: a is imported more than once")
+		})
+
+		Convey("not at top level", func() {
+			p := New(nil, []byte(`{ import m; }`))
+			So(func() { p.parseStatement() }, ShouldPanicWith, "import must be at the top level of a file")
+		})
+	})
+}
+
+func TestParser_parseImportNames(t *testing.T) {
+	Convey("selective import", t, func() {
+		p := New(token.NewFile("main.nv"), []byte("import {greet, shout as yell}\n  from \"lib/strutil\";"))
+		is := p.parseStatement().(*ast.ImportStmt)
+		So(is.Path, ShouldEqual, "lib/strutil")
+		So(is.String(), ShouldEqual, `import {greet, shout as yell} from "lib/strutil";`)
+		So(is.Names[1].Binding(), ShouldEqual, "yell")
+		So(is.Names[1].Loc.String(), ShouldEqual, "main.nv:1:16")
+	})
+}
+
+func TestParser_parseCall(t *testing.T) {
+	Convey("member calls", t, func() {
+		p := New(nil, []byte("m.make(1).name"))
+		me, ok := p.parseExpr().(*ast.MemberExpr)
+		So(ok, ShouldBeTrue)
+		So(me.Name, ShouldEqual, "name")
+		call, ok := me.X.(*ast.CallExpr)
+		So(ok, ShouldBeTrue)
+		So(ast.CalleeName(call.Callee), ShouldEqual, "m.make")
+	})
+}
+
+func TestParser_parseTry(t *testing.T) {
+	Convey("valid", t, func() {
+		p := New(nil, []byte(`try { f(); } catch e { g(e); } finally { h(); }`))
+		ts, ok := p.parseStatement().(*ast.TryStmt)
+		So(ok, ShouldBeTrue)
+		So(ts.Var, ShouldEqual, "e")
+		So(ts.Catch, ShouldNotBeNil)
+		So(ts.Finally, ShouldNotBeNil)
+
+		p = New(nil, []byte(`try { f(); } finally { h(); }`))
+		ts = p.parseStatement().(*ast.TryStmt)
+		So(ts.Catch, ShouldBeNil)
+
+		p = New(token.NewFile("a.nv"), []byte("\n  throw error(\"x\");"))
+		th := p.parseStatement().(*ast.ThrowStmt)
+		So(th.Loc.String(), ShouldEqual, "a.nv:2:3")
+	})
+
+	Convey("invalid", t, func() {
+		p := New(nil, []byte(`try { f(); } g();`))
+		So(func() { p.parseStatement() }, ShouldPanicWith, "try needs a catch or finally block, got IDENT")
+
+		p = New(nil, []byte(`catch e { }`))
+		So(func() { p.parseStatement() }, ShouldPanicWith, "catch without try")
+	})
+}
+
+func TestParser_parsePropagate(t *testing.T) {
+	Convey("postfix ?", t, func() {
+		testCases := []struct {
+			src  string
+			want string
+		}{
+			{src: "fn f(r) { r? }", want: "VAR r?"},
+			{src: "fn f(r) { g(r)?.x }", want: "g(VAR r)?.x"},
+			{src: "fn f(r) { empty?(r) }", want: "empty?(VAR r)"},
+			{src: "fn f(r) { r?? }", want: "VAR r??"},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				p := New(nil, []byte(tc.src))
+				fs := p.parseStatement().(*ast.FnStmt)
+				So(fs.Body.(*ast.Block).Value.String(), ShouldEqual, tc.want)
+			})
+		}
+
+		p := New(nil, []byte("fn f(r) { r? }"))
+		fs := p.parseStatement().(*ast.FnStmt)
+		pe, ok := fs.Body.(*ast.Block).Value.(*ast.PropagateExpr)
+		So(ok, ShouldBeTrue)
+		So(pe.X.(*ast.Variable).Ident, ShouldEqual, "r")
+	})
+
+	Convey("outside of a function", t, func() {
+		p := New(nil, []byte("let x = r?;"))
+		So(func() { p.parseStatement() }, ShouldPanicWith, "the ? operator can only be used inside a function")
 	})
 }
@@ -0,0 +1,573 @@
+// Package resolver checks the scoping rules of a Naive program before it
+// runs.
+package resolver
+
+import (
+	"fmt"
+	"sync"
+
+	"naive/ast"
+	"naive/token"
+)
+
+var _ ast.Visitor = (*Resolver)(nil)
+
+// Resolver walks a program the way the interpreter runs it, keeping track of
+// the names each scope declares. It sets the depth and slot of every variable
+// and assignment, and reports undefined variables, variables used before
+// their definitions and assignments to constants. It can also warn about
+// names declared twice in the same scope.
+//
+// A declaration takes effect after it, so `let x = x;` refers to an x from an
+// outer scope. Inside a function, a declaration takes effect from its start
+// instead, so `let f = fn(n) { f(n - 1) };` refers to the f being declared.
+// Functions may also refer to names declared after them in enclosing scopes,
+// which allows mutually recursive functions; using such a name before its
+// declaration has run is a runtime error.
+//
+// Every declaration gets a slot of its own, even if it redeclares a name, so
+// a function keeps referring to the binding it saw when it was defined. Blocks
+// that declare nothing get no scope, so that running them takes no
+// environment.
+//
+// The resolver also marks the calls in tail position: the value of a return
+// statement outside try statements, and the value of a function body.
+type Resolver struct {
+	// Redeclarations enables warnings about names declared more than once
+	// in the same scope.
+	Redeclarations bool
+	// Warnings holds the warnings reported so far.
+	Warnings []string
+
+	// isGlobal reports whether a name undeclared in the program is a builtin.
+	isGlobal func(name string) bool
+	scopes   []*scope
+	// tries is the number of try statements in the current function whose
+	// body or catch block encloses the statement being resolved. A return
+	// in them is not a tail call, since the try statement still has work to
+	// do after the call.
+	tries int
+	// funcs is the number of functions whose bodies enclose the statement
+	// being resolved. A return outside them, ending a file, is no tail call.
+	funcs int
+}
+
+// scope holds the declarations of a scope. The j-th declaration has slot j.
+type scope struct {
+	names  []string
+	locs   []token.Location
+	consts []bool
+	// active is the number of declarations in effect: a declaration takes
+	// effect once the resolver is past it.
+	active int
+	// defining is the number of declarations after the active ones made by
+	// the statement being resolved. They are in effect inside functions.
+	defining int
+	// params tells the scope of the parameters of a function, which is the
+	// outermost scope of its body.
+	params bool
+}
+
+// New returns a Resolver for a file, where isGlobal tells the builtins.
+func New(isGlobal func(name string) bool) *Resolver {
+	return &Resolver{
+		isGlobal: isGlobal,
+	}
+}
+
+// Resolve checks stmts, the top-level statements of a file, and panics at the
+// first error. It may be called several times, as by a REPL, in which case
+// the top-level declarations of earlier calls stay in effect.
+func (r *Resolver) Resolve(stmts []ast.Stmt) {
+	if len(r.scopes) == 0 {
+		r.begin()
+	}
+	top := r.scopes[0]
+	n := len(top.names)
+	defer func() {
+		if err := recover(); err != nil {
+			// Forget what the failed statements declared.
+			r.scopes, r.tries, r.funcs = r.scopes[:1], 0, 0
+			top.names, top.locs, top.consts = top.names[:n], top.locs[:n], top.consts[:n]
+			top.active, top.defining = n, 0
+			panic(err)
+		}
+	}()
+	r.statements(stmts)
+}
+
+// Declare declares name at the top level, taking effect at once, as if by a
+// statement resolved before the next ones. It returns the slot of name.
+func (r *Resolver) Declare(name string) int {
+	if len(r.scopes) == 0 {
+		r.begin()
+	}
+	return r.declare(name)
+}
+
+// Scope returns the slots of the top-level declarations in effect by their
+// names. A name declared more than once maps to its latest declaration.
+func (r *Resolver) Scope() map[string]int {
+	slots := make(map[string]int)
+	if len(r.scopes) > 0 {
+		top := r.scopes[0]
+		for j := 0; j < top.active; j++ {
+			slots[top.names[j]] = j
+		}
+	}
+	return slots
+}
+
+func (r *Resolver) begin() *scope {
+	sc := &scope{}
+	r.scopes = append(r.scopes, sc)
+	return sc
+}
+
+func (r *Resolver) end() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// statements resolves stmts, the statements of the current scope, after
+// adding the declarations they make to it.
+func (r *Resolver) statements(stmts []ast.Stmt) {
+	sc := r.scopes[len(r.scopes)-1]
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStmt:
+			slots := make([]int, 0, 1)
+			for _, name := range s.Names() {
+				slots = append(slots, sc.add(s.Loc, name, s.Const))
+			}
+			if s.Pattern == nil {
+				s.Slot = slots[0]
+			} else {
+				ast.SetSlots(s.Pattern, slots)
+			}
+		case *ast.FnStmt:
+			s.Slot = sc.add(s.Loc, s.Ident, false)
+		case *ast.ImportStmt:
+			if len(s.Names) == 0 {
+				s.Slot = sc.add(s.Loc, s.Name(), false)
+			}
+			for _, n := range s.Names {
+				n.Slot = sc.add(n.Loc, n.Binding(), false)
+			}
+		}
+	}
+	for _, stmt := range stmts {
+		stmt.Accept(r)
+	}
+}
+
+// add adds a declaration to sc, which takes effect when activated, and
+// returns its slot.
+func (sc *scope) add(loc token.Location, name string, isConst bool) int {
+	sc.names = append(sc.names, name)
+	sc.locs = append(sc.locs, loc)
+	sc.consts = append(sc.consts, isConst)
+	return len(sc.names) - 1
+}
+
+// activate puts the next n declarations of the current scope into effect.
+func (r *Resolver) activate(n int) {
+	sc := r.scopes[len(r.scopes)-1]
+	for ; n > 0; n-- {
+		name, loc := sc.names[sc.active], sc.locs[sc.active]
+		if prev := sc.visible(name, sc.active); prev >= 0 && r.Redeclarations {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s%s redeclared in this scope, previous declaration at %s",
+				position(loc), name, sc.locs[prev]))
+		}
+		sc.active++
+	}
+}
+
+// declare adds a declaration to the current scope that takes effect at once,
+// and returns its slot.
+func (r *Resolver) declare(name string) int {
+	slot := r.scopes[len(r.scopes)-1].add(token.Location{}, name, false)
+	r.activate(1)
+	return slot
+}
+
+// declarePattern declares the variables bound by p.
+func (r *Resolver) declarePattern(p ast.Pattern) {
+	names := ast.Names(p)
+	slots := make([]int, 0, len(names))
+	for _, name := range names {
+		slots = append(slots, r.declare(name))
+	}
+	ast.SetSlots(p, slots)
+}
+
+// visible returns the slot of the latest declaration of name among the first
+// n of sc, or -1 if there is none.
+func (sc *scope) visible(name string, n int) int {
+	for j := n - 1; j >= 0; j-- {
+		if sc.names[j] == name {
+			return j
+		}
+	}
+	return -1
+}
+
+// later returns the slot of the first declaration of name in sc that is not
+// in effect yet, or -1 if there is none.
+func (sc *scope) later(name string) int {
+	for j := sc.active; j < len(sc.names); j++ {
+		if sc.names[j] == name {
+			return j
+		}
+	}
+	return -1
+}
+
+// resolve returns the depth and slot of the binding name refers to at loc,
+// and whether the binding is a constant. depth is -1 for builtins.
+func (r *Resolver) resolve(loc token.Location, name string) (depth, slot int, isConst bool) {
+	// inFunc reports whether a function boundary lies between the reference
+	// and the scope being searched.
+	inFunc := false
+	var early *scope
+	earlySlot := -1
+	for depth = 0; depth < len(r.scopes); depth++ {
+		sc := r.scopes[len(r.scopes)-1-depth]
+		n := sc.active
+		if inFunc {
+			n += sc.defining
+		}
+		if slot = sc.visible(name, n); slot >= 0 {
+			return depth, slot, sc.consts[slot]
+		}
+		if slot = sc.later(name); slot >= 0 {
+			if inFunc {
+				return depth, slot, sc.consts[slot]
+			}
+			if early == nil {
+				early, earlySlot = sc, slot
+			}
+		}
+		inFunc = inFunc || sc.params
+	}
+	if r.isGlobal != nil && r.isGlobal(name) {
+		return -1, BuiltinSlot(name), false
+	}
+	if early != nil {
+		panic(fmt.Sprintf("%s%s is used before its definition at %s", position(loc), name, early.locs[earlySlot]))
+	}
+	panic(fmt.Sprintf("%sundefined variable %s", position(loc), name))
+}
+
+// builtinSlots numbers the names of builtins alike for every resolver, so
+// that an engine may keep the values of builtins by slot whichever resolver
+// set it.
+var builtinSlots = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// BuiltinSlot returns the slot of the variables that refer to the builtin
+// called name.
+func BuiltinSlot(name string) int {
+	builtinSlots.Lock()
+	defer builtinSlots.Unlock()
+	slot, ok := builtinSlots.m[name]
+	if !ok {
+		slot = len(builtinSlots.m)
+		builtinSlots.m[name] = slot
+	}
+	return slot
+}
+
+// position returns the prefix of messages about loc.
+func position(loc token.Location) string {
+	if loc.Line == 0 {
+		return ""
+	}
+	return loc.String() + ": "
+}
+
+func (r *Resolver) function(params []*ast.Param, body ast.Stmt) {
+	r.begin().params = true
+	tries := r.tries
+	r.tries = 0
+	r.funcs++
+	defer func() {
+		r.end()
+		r.tries = tries
+		r.funcs--
+	}()
+	for _, pm := range params {
+		if pm.Default != nil {
+			pm.Default.Accept(r)
+		}
+		if pm.Pattern != nil {
+			r.declarePattern(pm.Pattern)
+		} else {
+			pm.Slot = r.declare(pm.Name)
+		}
+	}
+	body.Accept(r)
+	markTail(body)
+}
+
+// markTail marks the calls in tail position in n, the body of a function or
+// part of its value.
+func markTail(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.CallExpr:
+		n.Tail = true
+	case *ast.GroupingExpr:
+		markTail(n.Expr)
+	case *ast.Block:
+		if n.Value != nil {
+			markTail(n.Value)
+		}
+	case *ast.IfElseStmt:
+		markTail(n.Then)
+		markTail(n.Else)
+	case *ast.MatchExpr:
+		for _, arm := range n.Arms {
+			markTail(arm.Body)
+		}
+	}
+}
+
+func (r *Resolver) exprs(es []ast.Expr) {
+	for _, e := range es {
+		e.Accept(r)
+	}
+}
+
+func (*Resolver) VisitIntegerValue(ast.IntegerValue) any {
+	return nil
+}
+
+func (*Resolver) VisitFloatValue(ast.FloatValue) any {
+	return nil
+}
+
+func (*Resolver) VisitStringValue(ast.StringValue) any {
+	return nil
+}
+
+func (*Resolver) VisitCharValue(ast.CharValue) any {
+	return nil
+}
+
+func (*Resolver) VisitTrue(ast.True) any {
+	return nil
+}
+
+func (*Resolver) VisitFalse(ast.False) any {
+	return nil
+}
+
+func (*Resolver) VisitNil(ast.Nil) any {
+	return nil
+}
+
+func (r *Resolver) VisitVariable(expr *ast.Variable) any {
+	expr.Depth, expr.Slot, _ = r.resolve(expr.Loc, expr.Ident)
+	return nil
+}
+
+func (r *Resolver) VisitBinaryExpr(expr *ast.BinaryExpr) any {
+	expr.Lhs.Accept(r)
+	expr.Rhs.Accept(r)
+	return nil
+}
+
+func (r *Resolver) VisitUnaryExpr(expr *ast.UnaryExpr) any {
+	return expr.X.Accept(r)
+}
+
+func (r *Resolver) VisitGroupingExpr(expr *ast.GroupingExpr) any {
+	return expr.Expr.Accept(r)
+}
+
+func (r *Resolver) VisitRangeExpr(expr *ast.RangeExpr) any {
+	expr.Start.Accept(r)
+	expr.End.Accept(r)
+	if expr.Step != nil {
+		expr.Step.Accept(r)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitListExpr(expr *ast.ListExpr) any {
+	r.exprs(expr.Elems)
+	return nil
+}
+
+func (r *Resolver) VisitMapExpr(expr *ast.MapExpr) any {
+	r.exprs(expr.Keys)
+	r.exprs(expr.Values)
+	return nil
+}
+
+func (r *Resolver) VisitMatchExpr(expr *ast.MatchExpr) any {
+	expr.Value.Accept(r)
+	for _, arm := range expr.Arms {
+		r.begin()
+		r.declarePattern(arm.Pattern)
+		if arm.Guard != nil {
+			arm.Guard.Accept(r)
+		}
+		arm.Body.Accept(r)
+		r.end()
+	}
+	return nil
+}
+
+func (r *Resolver) VisitCallExpr(expr *ast.CallExpr) any {
+	expr.Callee.Accept(r)
+	r.exprs(expr.Args)
+	return nil
+}
+
+func (r *Resolver) VisitMemberExpr(expr *ast.MemberExpr) any {
+	return expr.X.Accept(r)
+}
+
+func (r *Resolver) VisitPropagateExpr(expr *ast.PropagateExpr) any {
+	return expr.X.Accept(r)
+}
+
+func (r *Resolver) VisitLambda(expr *ast.Lambda) any {
+	r.function(expr.Params, expr.Body)
+	return nil
+}
+
+func (r *Resolver) VisitLetStmt(stmt *ast.LetStmt) any {
+	sc := r.scopes[len(r.scopes)-1]
+	sc.defining = len(stmt.Names())
+	stmt.Init.Accept(r)
+	sc.defining = 0
+	r.activate(len(stmt.Names()))
+	return nil
+}
+
+func (r *Resolver) VisitAssignStmt(stmt *ast.AssignStmt) any {
+	stmt.Expr.Accept(r)
+	depth, slot, isConst := r.resolve(stmt.Loc, stmt.Ident)
+	if depth < 0 {
+		panic(fmt.Sprintf("%scannot assign to builtin %s", position(stmt.Loc), stmt.Ident))
+	}
+	if isConst {
+		sc := r.scopes[len(r.scopes)-1-depth]
+		panic(fmt.Sprintf("%scannot assign to constant %s declared at %s",
+			position(stmt.Loc), stmt.Ident, sc.locs[slot]))
+	}
+	stmt.Depth, stmt.Slot = depth, slot
+	return nil
+}
+
+func (r *Resolver) VisitImportStmt(stmt *ast.ImportStmt) any {
+	if len(stmt.Names) == 0 {
+		r.activate(1)
+	}
+	r.activate(len(stmt.Names))
+	return nil
+}
+
+func (r *Resolver) VisitIfElseStmt(stmt *ast.IfElseStmt) any {
+	stmt.Cond.Accept(r)
+	stmt.Then.Accept(r)
+	return stmt.Else.Accept(r)
+}
+
+func (r *Resolver) VisitWhileStmt(stmt *ast.WhileStmt) any {
+	stmt.Cond.Accept(r)
+	return stmt.Body.Accept(r)
+}
+
+func (r *Resolver) VisitForStmt(stmt *ast.ForStmt) any {
+	stmt.Iter.Accept(r)
+	r.begin()
+	defer r.end()
+	r.declare(stmt.Var)
+	return stmt.Body.Accept(r)
+}
+
+func (*Resolver) VisitBreakStmt(*ast.BreakStmt) any {
+	return nil
+}
+
+func (*Resolver) VisitContinueStmt(*ast.ContinueStmt) any {
+	return nil
+}
+
+func (r *Resolver) VisitFnStmt(stmt *ast.FnStmt) any {
+	// The function takes effect before its body, so that it can call itself.
+	r.activate(1)
+	r.function(stmt.Params, stmt.Body)
+	return nil
+}
+
+func (r *Resolver) VisitReturnStmt(stmt *ast.ReturnStmt) any {
+	stmt.RetVal.Accept(r)
+	if r.funcs > 0 && r.tries == 0 {
+		markTail(stmt.RetVal)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	return stmt.Value.Accept(r)
+}
+
+func (r *Resolver) VisitTryStmt(stmt *ast.TryStmt) any {
+	r.tries++
+	stmt.Body.Accept(r)
+	if stmt.Finally == nil {
+		r.tries--
+	}
+	if stmt.Catch != nil {
+		r.begin()
+		if stmt.Var != "" {
+			r.declare(stmt.Var)
+		}
+		stmt.Catch.Accept(r)
+		r.end()
+	}
+	if stmt.Finally != nil {
+		r.tries--
+		stmt.Finally.Accept(r)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitExprStmt(stmt *ast.ExprStmt) any {
+	return stmt.Expr.Accept(r)
+}
+
+func (*Resolver) VisitEmptyStmt(*ast.EmptyStmt) any {
+	return nil
+}
+
+func (r *Resolver) VisitBlock(blk *ast.Block) any {
+	if declares(blk.Statements) {
+		sc := r.begin()
+		defer func() {
+			blk.Slots = len(sc.names)
+			r.end()
+		}()
+	}
+	r.statements(blk.Statements)
+	if blk.Value != nil {
+		blk.Value.Accept(r)
+	}
+	return nil
+}
+
+// declares reports whether stmts, the statements of a block, declare names.
+func declares(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch stmt.(type) {
+		case *ast.LetStmt, *ast.FnStmt, *ast.ImportStmt:
+			return true
+		}
+	}
+	return false
+}
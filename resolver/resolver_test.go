@@ -0,0 +1,256 @@
+package resolver
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/ast"
+	"naive/parser"
+	"naive/token"
+)
+
+func resolve(r *Resolver, src string) {
+	p := parser.New(token.NewFile("a.nv"), []byte(src))
+	p.Parse()
+	r.Resolve(p.Statements)
+}
+
+func TestResolver_VisitAssignStmt(t *testing.T) {
+	Convey("assignment to constants", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{
+				name: "same scope",
+				src:  "const x = 1;\nx = 2;",
+				want: "a.nv:2:1: cannot assign to constant x declared at a.nv:1:1",
+			},
+			{
+				name: "from a function",
+				src:  "const x = 1;\nfn f() {\n    x = 2;\n}",
+				want: "a.nv:3:5: cannot assign to constant x declared at a.nv:1:1",
+			},
+			{
+				name: "destructured",
+				src:  "const [a, b] = [1, 2];\nwhile true { b = 3; }",
+				want: "a.nv:2:14: cannot assign to constant b declared at a.nv:1:1",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				So(func() { resolve(New(nil), tc.src) }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+
+	Convey("shadowed constants", t, func() {
+		So(func() {
+			resolve(New(nil), `const x = 1;
+{ let x = 2; x = 3; }
+fn f(x) { x = 4; }
+for x in 0..3 { x = 5; }
+let y = match 1 { x => { x = 6; x } };`)
+		}, ShouldNotPanic)
+	})
+}
+
+func TestResolver_declare(t *testing.T) {
+	src := `let x = 1;
+fn f(a) {
+    let a = 2;
+    let b = 3;
+    let b = 4;
+}
+let x = 5;
+{ let x = 6; }
+fn f() {}`
+
+	Convey("off by default", t, func() {
+		r := New(nil)
+		resolve(r, src)
+		So(r.Warnings, ShouldBeEmpty)
+	})
+
+	Convey("redeclarations", t, func() {
+		r := New(nil)
+		r.Redeclarations = true
+		resolve(r, src)
+		So(r.Warnings, ShouldResemble, []string{
+			"a.nv:5:5: b redeclared in this scope, previous declaration at a.nv:4:5",
+			"a.nv:7:1: x redeclared in this scope, previous declaration at a.nv:1:1",
+			"a.nv:9:1: f redeclared in this scope, previous declaration at a.nv:2:1",
+		})
+	})
+}
+
+func TestResolver_VisitVariable(t *testing.T) {
+	Convey("errors", t, func() {
+		testCases := []struct {
+			name string
+			src  string
+			want string
+		}{
+			{
+				name: "undefined",
+				src:  "let x = 1;\nx + y;",
+				want: "a.nv:2:5: undefined variable y",
+			},
+			{
+				name: "used before its definition",
+				src:  "let x = y;\nlet y = 1;",
+				want: "a.nv:1:9: y is used before its definition at a.nv:2:1",
+			},
+			{
+				name: "in its own initializer",
+				src:  "{ let z = z + 1; }",
+				want: "a.nv:1:11: z is used before its definition at a.nv:1:3",
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				So(func() { resolve(New(nil), tc.src) }, ShouldPanicWith, tc.want)
+			})
+		}
+	})
+
+	Convey("depths and slots", t, func() {
+		p := parser.New(token.NewFile("a.nv"), []byte(`let a = 1;
+let b = 2;
+fn f(c) {
+    let d = a + c;
+    d + b + print
+}`))
+		p.Parse()
+		New(func(name string) bool { return name == "print" }).Resolve(p.Statements)
+
+		var got []string
+		collect := func(e ast.Expr) {
+			v := e.(*ast.Variable)
+			got = append(got, fmt.Sprintf("%s %d %d", v.Ident, v.Depth, v.Slot))
+		}
+		fs := p.Statements[2].(*ast.FnStmt)
+		body := fs.Body.(*ast.Block)
+		init := body.Statements[0].(*ast.LetStmt).Init.(*ast.BinaryExpr)
+		collect(init.Lhs)
+		collect(init.Rhs)
+		sum := body.Value.(*ast.BinaryExpr)
+		collect(sum.Lhs.(*ast.BinaryExpr).Lhs)
+		collect(sum.Lhs.(*ast.BinaryExpr).Rhs)
+		collect(sum.Rhs)
+		So(got, ShouldResemble, []string{"a 2 0", "c 1 0", "d 0 0", "b 2 1", fmt.Sprintf("print -1 %d", BuiltinSlot("print"))})
+	})
+
+	Convey("blocks that declare nothing have no scope", t, func() {
+		p := parser.New(token.NewFile("a.nv"), []byte(`fn f(a) {
+    { a }
+}
+{ let x = 1; fn g() {} }`))
+		p.Parse()
+		New(nil).Resolve(p.Statements)
+
+		body := p.Statements[0].(*ast.FnStmt).Body.(*ast.Block)
+		inner := body.Value.(*ast.Block)
+		So(body.Slots, ShouldEqual, 0)
+		So(inner.Slots, ShouldEqual, 0)
+		So(inner.Value.(*ast.Variable).Depth, ShouldEqual, 0)
+		So(p.Statements[1].(*ast.Block).Slots, ShouldEqual, 2)
+	})
+
+	Convey("functions may refer to later declarations", t, func() {
+		So(func() {
+			resolve(New(nil), `fn even(n) { if n == 0 { true } else { odd(n - 1) } }
+fn odd(n) { if n == 0 { false } else { even(n - 1) } }
+let fib = fn(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } };
+{
+    let g = fn() { h() };
+    fn h() { 1 }
+}`)
+		}, ShouldNotPanic)
+	})
+}
+
+func TestResolver_markTail(t *testing.T) {
+	Convey("tail calls", t, func() {
+		testCases := []struct {
+			src  string
+			want bool
+		}{
+			{src: "fn f() { g() }", want: true},
+			{src: "fn f() { return g(); }", want: true},
+			{src: "fn f() { if true { g() } else { 1 } }", want: true},
+			{src: "fn f() { match 1 { 1 => (g()), _ => 2 } }", want: true},
+			{src: "let f = fn() -> g();", want: true},
+			{src: "fn f() { 1 + g() }", want: false},
+			{src: "fn f() { g(); 1 }", want: false},
+			{src: "fn f() { try { return g(); } catch { 1 } }", want: false},
+			{src: "fn f() { try { 1 } catch { return g(); } }", want: true},
+			{src: "fn f() { try { 1 } catch { return g(); } finally { 2 } }", want: false},
+			{src: "g();", want: false},
+			{src: "return g();", want: false},
+			{src: "if true { return g(); }", want: false},
+		}
+		for _, tc := range testCases {
+			Convey(tc.src, func() {
+				p := parser.New(token.NewFile("a.nv"), []byte("fn g() {}\n"+tc.src))
+				p.Parse()
+				New(nil).Resolve(p.Statements)
+				So(findCall(p.Statements[1:]).Tail, ShouldEqual, tc.want)
+			})
+		}
+	})
+}
+
+// findCall returns the call of g in stmts.
+func findCall(stmts []ast.Stmt) (call *ast.CallExpr) {
+	var find func(n any)
+	find = func(n any) {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if v, ok := n.Callee.(*ast.Variable); ok && v.Ident == "g" {
+				call = n
+			}
+		case *ast.FnStmt:
+			find(n.Body)
+		case *ast.LetStmt:
+			find(n.Init)
+		case *ast.Lambda:
+			find(n.Body)
+		case *ast.ExprStmt:
+			find(n.Expr)
+		case *ast.ReturnStmt:
+			find(n.RetVal)
+		case *ast.BinaryExpr:
+			find(n.Lhs)
+			find(n.Rhs)
+		case *ast.GroupingExpr:
+			find(n.Expr)
+		case *ast.Block:
+			for _, s := range n.Statements {
+				find(s)
+			}
+			if n.Value != nil {
+				find(n.Value)
+			}
+		case *ast.IfElseStmt:
+			find(n.Then)
+			find(n.Else)
+		case *ast.MatchExpr:
+			for _, arm := range n.Arms {
+				find(arm.Body)
+			}
+		case *ast.TryStmt:
+			find(n.Body)
+			if n.Catch != nil {
+				find(n.Catch)
+			}
+		}
+	}
+	for _, s := range stmts {
+		find(s)
+	}
+	return
+}
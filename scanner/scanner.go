@@ -20,7 +20,8 @@ type Scanner struct {
 	ch         rune // current character
 	offset     int  // character offset
 	rdOffset   int  // reading offset (start position of next character)
-	lineOffset int
+	lineOffset int  // offset of the first character of the current line
+	line       int  // line of the current character, starting at 1
 
 	NumErrors int
 }
@@ -35,6 +36,7 @@ func New(file *token.File, src []byte) *Scanner {
 		offset:     0,
 		rdOffset:   0,
 		lineOffset: 0,
+		line:       1,
 	}
 
 	s.next()
@@ -47,6 +49,7 @@ func New(file *token.File, src []byte) *Scanner {
 
 func (s *Scanner) Scan() (loc token.Location, kind token.Kind, text string) {
 	s.skipWhitespace()
+	loc = s.location()
 
 	if ch := s.ch; canLeadIdent(ch) {
 		kind = token.KindIdent
@@ -64,6 +67,7 @@ func (s *Scanner) Scan() (loc token.Location, kind token.Kind, text string) {
 		kind, text = token.KindComment, s.scanComment()
 	} else {
 		// Operators do not need text
+		s.next()
 		switch ch {
 		case eof:
 			kind = token.KindEOF
@@ -95,6 +99,8 @@ func (s *Scanner) Scan() (loc token.Location, kind token.Kind, text string) {
 			kind = token.KindAssign
 			if s.expectNext('=') {
 				kind = token.KindEq
+			} else if s.expectNext('>') {
+				kind = token.KindFatArrow
 			}
 		case '>':
 			kind = token.KindGt
@@ -110,20 +116,39 @@ func (s *Scanner) Scan() (loc token.Location, kind token.Kind, text string) {
 			kind = token.KindSemicolon
 		case ',':
 			kind = token.KindComma
+		case ':':
+			kind = token.KindColon
+		case '?':
+			kind = token.KindQuestion
+		case '[':
+			kind = token.KindLBracket
+		case ']':
+			kind = token.KindRBracket
+		case '.':
+			if !s.expectNext('.') {
+				kind = token.KindDot
+				break
+			}
+			kind = token.KindRange
+			if s.expectNext('=') {
+				kind = token.KindRangeIncl
+			} else if s.expectNext('.') {
+				kind = token.KindEllipsis
+			}
 		default:
 			if ch != bom {
 				s.reportf("illegal character %#U", ch)
 			}
 			kind, text = token.KindInvalid, string(ch)
 		}
-		s.next()
 	}
 
 	return
 }
 
+// expectNext consumes the character following an operator's first one if it
+// is ch.
 func (s *Scanner) expectNext(ch rune) bool {
-	s.next()
 	if s.ch == ch {
 		s.next()
 		return true
@@ -176,6 +201,10 @@ func (s *Scanner) scanNumber() (kind token.Kind, text string) {
 	}
 
 	if ch := s.ch; ch == '.' {
+		if s.peek() == '.' {
+			// the start of a range such as 0..10
+			return kind, string(s.src[begin:s.offset])
+		}
 		s.next()
 		if n := s.scanDecimalDigits(); n == 0 {
 			s.report("invalid floating-point literal: no fraction")
@@ -272,6 +301,10 @@ func (s *Scanner) scanComment() string {
 }
 
 func (s *Scanner) next() rune {
+	if s.ch == '\n' {
+		s.line++
+		s.lineOffset = s.rdOffset
+	}
 	if s.rdOffset < len(s.src) {
 		s.offset = s.rdOffset
 
@@ -304,6 +337,27 @@ func (s *Scanner) next() rune {
 	return s.ch
 }
 
+// location returns the position of the current character. Columns count
+// bytes and start at 1.
+func (s *Scanner) location() token.Location {
+	loc := token.Location{
+		Line:   s.line,
+		Column: s.offset - s.lineOffset + 1,
+	}
+	if s.file != nil {
+		loc.FileName = s.file.Name()
+	}
+	return loc
+}
+
+// peek returns the byte following the current character without advancing.
+func (s *Scanner) peek() byte {
+	if s.rdOffset < len(s.src) {
+		return s.src[s.rdOffset]
+	}
+	return 0
+}
+
 func (s *Scanner) skipWhitespace() {
 	for s.ch == ' ' || s.ch == '\t' || s.ch == '\r' || s.ch == '\n' {
 		s.next()
@@ -282,3 +282,66 @@ func TestScanner_scanComment(t *testing.T) {
 		}
 	})
 }
+
+func TestScanner_Scan(t *testing.T) {
+	Convey("operators", t, func() {
+		testCases := []struct {
+			name string
+			arg  string
+			want []token.Kind
+		}{
+			{
+				name: "two-character operator followed by operand",
+				arg:  "a>=1-b",
+				want: []token.Kind{token.KindIdent, token.KindGe, token.KindInt, token.KindSub, token.KindIdent},
+			},
+			{
+				name: "exclusive range",
+				arg:  "0..n",
+				want: []token.Kind{token.KindInt, token.KindRange, token.KindIdent},
+			},
+			{
+				name: "inclusive range",
+				arg:  "[0..=10]",
+				want: []token.Kind{token.KindLBracket, token.KindInt, token.KindRangeIncl, token.KindInt, token.KindRBracket},
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				s := New(nil, []byte(tc.arg))
+				var kinds []token.Kind
+				for {
+					_, kind, _ := s.Scan()
+					if kind == token.KindEOF {
+						break
+					}
+					kinds = append(kinds, kind)
+				}
+				So(kinds, ShouldResemble, tc.want)
+			})
+		}
+	})
+}
+
+func TestScanner_location(t *testing.T) {
+	Convey("tokens carry their line and column", t, func() {
+		s := New(token.NewFile("a.nv"), []byte("let x = 1;\n# note\n  x = \"é\" + y;"))
+		want := map[string]string{
+			"let": "a.nv:1:1",
+			"1":   "a.nv:1:9",
+			`"é"`: "a.nv:3:7",
+			"y":   "a.nv:3:14",
+		}
+		got := make(map[string]string)
+		for {
+			loc, kind, text := s.Scan()
+			if kind == token.KindEOF {
+				break
+			}
+			if _, ok := want[text]; ok {
+				got[text] = loc.String()
+			}
+		}
+		So(got, ShouldResemble, want)
+	})
+}
@@ -5,16 +5,29 @@ import "fmt"
 var kindsByText = map[string]Kind{
 	"true":  KindTrue,
 	"false": KindFalse,
+	"nil":   KindNil,
 	"and":   KindAnd,
 	"or":    KindOr,
 	"not":   KindNot,
 
-	"let":    KindLet,
-	"if":     KindIf,
-	"else":   KindElse,
-	"while":  KindWhile,
-	"fn":     KindFn,
-	"return": KindReturn,
+	"let":      KindLet,
+	"const":    KindConst,
+	"if":       KindIf,
+	"else":     KindElse,
+	"while":    KindWhile,
+	"for":      KindFor,
+	"match":    KindMatch,
+	"in":       KindIn,
+	"fn":       KindFn,
+	"return":   KindReturn,
+	"break":    KindBreak,
+	"continue": KindContinue,
+	"import":   KindImport,
+	"as":       KindAs,
+	"throw":    KindThrow,
+	"try":      KindTry,
+	"catch":    KindCatch,
+	"finally":  KindFinally,
 }
 
 func Lookup(ident string) Kind {
@@ -49,10 +62,12 @@ const (
 	KindDiv // /
 	KindMod // %
 
-	KindLParen // (
-	KindRParen // )
-	KindLBrace // {
-	KindRBrace // }
+	KindLParen   // (
+	KindRParen   // )
+	KindLBrace   // {
+	KindRBrace   // }
+	KindLBracket // [
+	KindRBracket // ]
 
 	KindEq // ==
 	KindNe // /=
@@ -65,24 +80,45 @@ const (
 
 	KindLtRArrow // ->
 
+	KindRange     // ..
+	KindRangeIncl // ..=
+	KindEllipsis  // ...
+	KindFatArrow  // =>
+	KindQuestion  // ?
+
 	KindSemicolon // ;
 	KindComma     // ,
+	KindColon     // :
+	KindDot       // .
 	operator_end
 
 	keyword_begin
 	// Keywords
 	KindTrue  // true
 	KindFalse // false
+	KindNil   // nil
 	KindAnd   // and
 	KindOr    // or
 	KindNot   // not
 
-	KindLet    // let
-	KindIf     // if
-	KindElse   // else
-	KindWhile  // while
-	KindFn     // fn
-	KindReturn // return
+	KindLet      // let
+	KindConst    // const
+	KindIf       // if
+	KindElse     // else
+	KindWhile    // while
+	KindFor      // for
+	KindIn       // in
+	KindMatch    // match
+	KindFn       // fn
+	KindReturn   // return
+	KindBreak    // break
+	KindContinue // continue
+	KindImport   // import
+	KindAs       // as
+	KindThrow    // throw
+	KindTry      // try
+	KindCatch    // catch
+	KindFinally  // finally
 
 	keyword_end
 )
@@ -126,6 +162,10 @@ func (kind Kind) String() string {
 		return "LBRACE"
 	case KindRBrace:
 		return "RBRACE"
+	case KindLBracket:
+		return "LBRACKET"
+	case KindRBracket:
+		return "RBRACKET"
 
 	case KindEq:
 		return "EQ"
@@ -140,15 +180,38 @@ func (kind Kind) String() string {
 	case KindLe:
 		return "LE"
 
+	case KindAssign:
+		return "ASSIGN"
+
+	case KindLtRArrow:
+		return "ARROW"
+
+	case KindRange:
+		return "RANGE"
+	case KindRangeIncl:
+		return "RANGE_INCL"
+	case KindEllipsis:
+		return "ELLIPSIS"
+	case KindFatArrow:
+		return "FAT_ARROW"
+	case KindQuestion:
+		return "QUESTION"
+
 	case KindSemicolon:
 		return "SEMICOLON"
 	case KindComma:
 		return "COMMA"
+	case KindColon:
+		return "COLON"
+	case KindDot:
+		return "DOT"
 
 	case KindTrue:
 		return "TRUE"
 	case KindFalse:
 		return "FALSE"
+	case KindNil:
+		return "NIL"
 	case KindAnd:
 		return "AND"
 	case KindOr:
@@ -158,16 +221,40 @@ func (kind Kind) String() string {
 
 	case KindLet:
 		return "LET"
+	case KindConst:
+		return "CONST"
 	case KindIf:
 		return "IF"
 	case KindElse:
 		return "ELSE"
 	case KindWhile:
 		return "WHILE"
+	case KindFor:
+		return "FOR"
+	case KindIn:
+		return "IN"
+	case KindMatch:
+		return "MATCH"
 	case KindFn:
 		return "FN"
 	case KindReturn:
 		return "RETURN"
+	case KindBreak:
+		return "BREAK"
+	case KindContinue:
+		return "CONTINUE"
+	case KindImport:
+		return "IMPORT"
+	case KindAs:
+		return "AS"
+	case KindThrow:
+		return "THROW"
+	case KindTry:
+		return "TRY"
+	case KindCatch:
+		return "CATCH"
+	case KindFinally:
+		return "FINALLY"
 
 	default:
 		panic(fmt.Sprint("unknown token kind value: ", int(kind)))
@@ -0,0 +1,58 @@
+package vm
+
+import (
+	"testing"
+
+	"naive/interpreter"
+)
+
+// benchmarks are CPU-heavy scripts to compare the engines on.
+var benchmarks = []struct {
+	name string
+	src  string
+}{
+	{name: "fib", src: `fn fib(n) { if n < 2 { n } else { fib(n - 1) + fib(n - 2) } }
+fib(20);`},
+	{name: "loop", src: `let i = 0;
+let sum = 0;
+while i < 10000 {
+    let sq = i * i;
+    sum = sum + sq % 7;
+    i = i + 1;
+}`},
+	{name: "nested for", src: `let sum = 0;
+for i in 0..100 {
+    for j in 0..100 {
+        if (i + j) % 3 == 0 { sum = sum + 1; }
+    }
+}`},
+	{name: "calls", src: `fn add(a, b) { a + b }
+fn twice(f, x) { f(f(x)) }
+let inc = fn(x) -> add(x, 1);
+let n = 0;
+for i in 0..2000 { n = twice(inc, n); }`},
+	{name: "closures", src: `fn counter() {
+    let n = 0;
+    return fn () { n = n + 1; n };
+}
+let c = counter();
+let total = 0;
+for i in 0..5000 { total = total + c(); }`},
+}
+
+// BenchmarkEngines runs every script of benchmarks with the interpreter and
+// with the VM.
+func BenchmarkEngines(b *testing.B) {
+	for _, bm := range benchmarks {
+		b.Run(bm.name+"/tree", func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				interpreter.New("bench.nv", []byte(bm.src)).Interpret()
+			}
+		})
+		b.Run(bm.name+"/vm", func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				New("bench.nv", []byte(bm.src)).Run()
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"naive/compiler"
+	"naive/interpreter"
+	"naive/parser"
+	"naive/resolver"
+	"naive/token"
+)
+
+// CacheExt is the extension of the files of compiled code in a cache.
+const CacheExt = ".nvc"
+
+// cachePath returns the path of the compiled code of the file at path in the
+// cache dir. It is named after the absolute path of the file.
+func cachePath(dir, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	name := strings.TrimSuffix(filepath.Base(path), interpreter.ModuleExt)
+	return filepath.Join(dir, name+"-"+hex.EncodeToString(sum[:8])+CacheExt)
+}
+
+// readCache returns the compiled code of the file at path from the cache dir,
+// or nil if it is missing or out of date with src, the source of the file. It
+// removes the file of compiled code if it cannot be read.
+func readCache(dir, path string, src []byte) *compiler.Unit {
+	f, err := os.Open(cachePath(dir, path))
+	if err != nil {
+		return nil
+	}
+	u, err := compiler.Decode(f, path)
+	f.Close()
+	if err != nil {
+		os.Remove(cachePath(dir, path))
+		return nil
+	}
+	if u.Checksum != compiler.Checksum(src) {
+		return nil
+	}
+	return u
+}
+
+// writeCache stores u, the compiled code of the file at path, in the cache
+// dir.
+func writeCache(dir, path string, u *compiler.Unit) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := compiler.Encode(&b, u); err != nil {
+		return err
+	}
+	// Readers see either the old file or the new one, never a partial one.
+	f, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cachePath(dir, path))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// compile returns the compiled code of the file at path, whose source is
+// src, parsing it with p and resolving it with r. It takes the code from
+// Cache if it is up to date there, and stores it there otherwise. err tells
+// why storing failed. Code compiled with Rewrite and without are told apart,
+// but not code rewritten by different functions. The warnings about the file
+// are reported either way.
+func (vm *VM) compile(path string, src []byte, p *parser.Parser, r *resolver.Resolver) (u *compiler.Unit, err error) {
+	cached := vm.Cache != "" && path != ""
+	if cached {
+		u := readCache(vm.Cache, path, src)
+		if u != nil && u.Rewritten == (vm.Rewrite != nil) && u.Redeclarations == vm.Redeclarations {
+			vm.warn(u.Warnings)
+			return u, nil
+		}
+	}
+	p.Parse()
+	if vm.Rewrite != nil {
+		p.Statements = vm.Rewrite(p.Statements)
+	}
+	u = &compiler.Unit{
+		Checksum:       compiler.Checksum(src),
+		Rewritten:      vm.Rewrite != nil,
+		Exports:        interpreter.Exports(p.Statements),
+		Warnings:       vm.check(r, p.Statements),
+		Redeclarations: vm.Redeclarations,
+		Main:           compiler.Compile(path, p.Statements),
+	}
+	vm.warn(u.Warnings)
+	if cached {
+		err = writeCache(vm.Cache, path, u)
+	}
+	return u, err
+}
+
+// unit is as compile, but reports the failures to store the code as
+// warnings.
+func (vm *VM) unit(path string, src []byte, p *parser.Parser, r *resolver.Resolver) *compiler.Unit {
+	u, err := vm.compile(path, src, p, r)
+	if err != nil {
+		fmt.Fprintln(vm.stderr(), "warning: cannot cache compiled code: "+err.Error())
+	}
+	return u
+}
+
+// Build compiles the source held by P and the modules it imports, directly or
+// not, into Cache, ahead of running them. It returns the paths of the files
+// compiled, and panics if one cannot be.
+func (vm *VM) Build() []string {
+	if vm.Cache == "" {
+		panic("no cache directory to build into")
+	}
+	u, err := vm.compile(vm.file, vm.src, vm.P, vm.resolver)
+	if err != nil {
+		panic(fmt.Sprintf("cannot build %s: %v", vm.file, err))
+	}
+	built := []string{vm.file}
+	seen := make(map[string]bool)
+	if abs, err := filepath.Abs(vm.file); err == nil {
+		seen[abs] = true
+	}
+
+	type work struct {
+		file string
+		u    *compiler.Unit
+	}
+	queue := []work{{vm.file, u}}
+	for len(queue) > 0 {
+		w := queue[0]
+		queue = queue[1:]
+		for _, imp := range imports(w.u.Main) {
+			path := interpreter.FindModule(imp.loc, w.file, vm.SearchPath, imp.path)
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			src, err := os.ReadFile(path)
+			if err != nil {
+				panic(fmt.Sprintf("cannot load module %s: %v", path, err))
+			}
+			u, err := vm.compile(path, src, parser.New(token.NewFile(path), src), vm.newResolver())
+			if err != nil {
+				panic(fmt.Sprintf("cannot build %s: %v", path, err))
+			}
+			built = append(built, path)
+			queue = append(queue, work{path, u})
+		}
+	}
+	return built
+}
+
+type importSite struct {
+	loc  token.Location
+	path string
+}
+
+// imports returns the imports made by p, the code of a file. Imports are
+// only allowed at the top level of files.
+func imports(p *compiler.Proto) []importSite {
+	var sites []importSite
+	for pc := 0; pc < len(p.Code); pc += 1 + 2*compiler.Infos[p.Code[pc]].Operands {
+		if compiler.Op(p.Code[pc]) == compiler.OpImport {
+			sites = append(sites, importSite{p.Loc(pc), p.Consts[p.Operand(pc, 0)].(string)})
+		}
+	}
+	return sites
+}
@@ -0,0 +1,155 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/compiler"
+	"naive/parser"
+	"naive/token"
+)
+
+func TestVM_Cache(t *testing.T) {
+	Convey("Compiled code is cached", t, func() {
+		dir := t.TempDir()
+		cache := filepath.Join(dir, "cache")
+		write := func(name, src string) string {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}
+		lib := write("lib.nv", `fn twice(x) { x * 2 }`)
+		main := write("main.nv", `import lib; println(lib.twice(21));`)
+		run := func() string {
+			src, _ := os.ReadFile(main)
+			out, failure := capture(func(out io.Writer) {
+				m := New(main, src)
+				m.Cache, m.Stdout = cache, out
+				m.Run()
+			})
+			So(failure, ShouldBeEmpty)
+			return out
+		}
+
+		So(run(), ShouldEqual, "42\n")
+		for _, path := range []string{lib, main} {
+			_, err := os.Stat(cachePath(cache, path))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("and reused while the source is unchanged", func() {
+			// Replace the cached code of lib with that of other source, to see
+			// which one runs.
+			src, _ := os.ReadFile(lib)
+			p := parser.New(token.NewFile(lib), []byte(`fn twice(x) { x * 3 }`))
+			p.Parse()
+			u, err := Default().compile("", src, p, Default().newResolver())
+			So(err, ShouldBeNil)
+			So(writeCache(cache, lib, u), ShouldBeNil)
+			So(run(), ShouldEqual, "63\n")
+		})
+
+		Convey("and compiled again once the source changes", func() {
+			write("lib.nv", `fn twice(x) { x + x + 1 }`)
+			So(run(), ShouldEqual, "43\n")
+		})
+
+		Convey("and compiled again if it is unreadable", func() {
+			So(os.WriteFile(cachePath(cache, lib), []byte(compiler.Magic), 0644), ShouldBeNil)
+			So(run(), ShouldEqual, "42\n")
+			f, _ := os.Open(cachePath(cache, lib))
+			defer f.Close()
+			_, err := compiler.Decode(f, lib)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("and compiled again if it is corrupt", func() {
+			data, _ := os.ReadFile(cachePath(cache, lib))
+			data[len(data)/2] ^= 0xff
+			So(os.WriteFile(cachePath(cache, lib), data, 0644), ShouldBeNil)
+			So(readCache(cache, lib, []byte(`fn twice(x) { x * 2 }`)), ShouldBeNil)
+			_, err := os.Stat(cachePath(cache, lib))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(run(), ShouldEqual, "42\n")
+		})
+	})
+
+	Convey("Warnings are kept with the cached code", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "main.nv")
+		src := []byte("let x = 1;\nlet x = 2;")
+		var stderr strings.Builder
+		compile := func(redeclarations bool) *compiler.Unit {
+			m := New(path, src)
+			m.Cache, m.Redeclarations, m.Stderr = filepath.Join(dir, "cache"), redeclarations, &stderr
+			u, err := m.compile(path, src, m.P, m.resolver)
+			So(err, ShouldBeNil)
+			return u
+		}
+		want := []string{path + ":2:1: x redeclared in this scope, previous declaration at " + path + ":1:1"}
+		So(compile(true).Warnings, ShouldResemble, want)
+		So(compile(true).Warnings, ShouldResemble, want)
+		So(compile(false).Warnings, ShouldBeEmpty)
+		So(stderr.String(), ShouldEqual, strings.Repeat("warning: "+want[0]+"\n", 2))
+	})
+
+	Convey("The compiled code of every script checks out", t, func() {
+		// compile returns the compiled code of src, or nil if it fails to
+		// compile.
+		compile := func(src string) (u *compiler.Unit) {
+			defer func() {
+				if recover() != nil {
+					u = nil
+				}
+			}()
+			m := New("main.nv", []byte(src))
+			u, _ = m.compile("main.nv", []byte(src), m.P, m.resolver)
+			return u
+		}
+		for _, src := range scripts() {
+			u := compile(src)
+			if u == nil {
+				continue
+			}
+			var b bytes.Buffer
+			So(compiler.Encode(&b, u), ShouldBeNil)
+			_, err := compiler.Decode(&b, "main.nv")
+			So(err, ShouldBeNil)
+		}
+	})
+
+	Convey("Build compiles a script and the modules it imports", t, func() {
+		dir := t.TempDir()
+		cache := filepath.Join(dir, "cache")
+		files := map[string]string{
+			"main.nv":     `import a; import "sub/b.nv" as c;`,
+			"a.nv":        `import "sub/b"; let y = b.x;`,
+			"sub/b.nv":    `let x = 1;`,
+			"unused.nv":   `let z = 2;`,
+			"sub/main.nv": `let w = 3;`,
+		}
+		for name, src := range files {
+			path := filepath.Join(dir, name)
+			So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+			So(os.WriteFile(path, []byte(src), 0644), ShouldBeNil)
+		}
+		main := filepath.Join(dir, "main.nv")
+		m := New(main, []byte(files["main.nv"]))
+		m.Cache = cache
+		So(m.Build(), ShouldResemble, []string{main, filepath.Join(dir, "a.nv"), filepath.Join(dir, "sub", "b.nv")})
+		entries, _ := os.ReadDir(cache)
+		So(entries, ShouldHaveLength, 3)
+
+		Convey("but not without a cache", func() {
+			So(func() { New(main, nil).Build() }, ShouldPanicWith, "no cache directory to build into")
+		})
+	})
+}
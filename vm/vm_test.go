@@ -0,0 +1,465 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"naive/interpreter"
+	"naive/parser"
+	"naive/token"
+)
+
+// capture runs f, which runs code printing to out, and returns what the code
+// prints, and how it fails if it does.
+func capture(f func(out io.Writer)) (out, failure string) {
+	var b strings.Builder
+	func() {
+		defer func() {
+			switch r := recover().(type) {
+			case nil:
+			case *interpreter.Error:
+				failure = r.Trace()
+			default:
+				failure = fmt.Sprint(r)
+			}
+		}()
+		f(&b)
+	}()
+	return b.String(), failure
+}
+
+// limits stop the scripts that run until a limit stops them, as those of the
+// tests of limits do.
+var limits = interpreter.Limits{MaxSteps: 1 << 20, MaxAlloc: 1 << 23}
+
+// compare runs src with both the interpreter and the VM, and returns what
+// each prints and how it fails. Errors raised are turned into errors with
+// stack traces, so that where they are raised is compared.
+func compare(src string) (tree, vm [2]string) {
+	tree[0], tree[1] = capture(func(out io.Writer) {
+		interp := interpreter.New("main.nv", []byte(src))
+		interp.Stdin, interp.Stdout, interp.Stderr = strings.NewReader(""), out, out
+		interp.Limits = limits
+		raise(interp.Try(func() any { interp.Interpret(); return nil }))
+	})
+	vm[0], vm[1] = capture(func(out io.Writer) {
+		m := New("main.nv", []byte(src))
+		m.Stdin, m.Stdout, m.Stderr = strings.NewReader(""), out, out
+		m.Limits = limits
+		raise(m.Try(func() any { m.Run(); return nil }))
+	})
+	return
+}
+
+// raise panics with caught, if it is not nil.
+func raise(_ any, caught *interpreter.Error) {
+	if caught != nil {
+		panic(caught)
+	}
+}
+
+// scripts returns the string literals of the tests of package interpreter
+// that parse as Naive code.
+func scripts() []string {
+	files, _ := filepath.Glob("../interpreter/*_test.go")
+	var srcs []string
+	fset := gotoken.NewFileSet()
+	for _, file := range files {
+		f, err := goparser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			panic(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != gotoken.STRING {
+				return true
+			}
+			if s, err := strconv.Unquote(lit.Value); err == nil && parses(s) {
+				srcs = append(srcs, s)
+			}
+			return true
+		})
+	}
+	return srcs
+}
+
+func parses(src string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	p := parser.New(token.NewFile("main.nv"), []byte(src))
+	p.Parse()
+	return len(p.Statements) > 0
+}
+
+func TestVM_interpreterTests(t *testing.T) {
+	Convey("The VM runs the scripts of the interpreter tests as the interpreter does", t, func() {
+		srcs := scripts()
+		So(len(srcs), ShouldBeGreaterThan, 50)
+		for _, src := range srcs {
+			tree, vm := compare(src)
+			So(vm, ShouldResemble, tree)
+		}
+	})
+}
+
+func TestVM_Run(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "closures over loop variables",
+			src: `let fs = {};
+for i in 0..3 { fs = {a: fs, f: fn() -> i}; }
+println(fs.f(), fs.a.f(), fs.a.a.f());`,
+		},
+		{
+			name: "closures in nested functions",
+			src: `fn outer() {
+    let x = 1;
+    fn middle() { fn() { x = x + 1; x } }
+    let f = middle();
+    f(); f();
+    x
+}
+println(outer());`,
+		},
+		{
+			name: "labelled break and continue",
+			src: `outer: for i in 0..5 {
+    for j in 0..5 {
+        if j == 3 { continue outer; }
+        if i == 3 { break outer; }
+        print(i, j, "");
+    }
+}
+println();
+let n = 0;
+while true { n = n + 1; if n > 10 { break; } }
+println(n);`,
+		},
+		{
+			name: "finally on break, continue and return",
+			src: `fn f() {
+    for i in 0..3 {
+        try {
+            if i == 0 { continue; }
+            if i == 1 { break; }
+        } finally {
+            println("finally", i);
+        }
+    }
+    try { return 1; } finally { println("returning"); }
+}
+println(f());`,
+		},
+		{
+			name: "errors through frames",
+			src: `fn g(x) { throw error(format("bad {}", x)); }
+fn f(x) { g(x) }
+try { f(1); } catch e { println(e.message); }
+try { 1 / 0; } catch e { println(e.message); }
+f(2);`,
+		},
+		{
+			name: "match",
+			src: `fn describe(v) {
+    match v {
+        0 => "zero",
+        1..=9 => "digit",
+        [a, b] => format("pair {}", a + b),
+        {x, y} => "point",
+        n if n > 100 => "big",
+        _ => "other",
+    }
+}
+println(describe(0), describe(5), describe([1, 2]), describe({x: 1, y: 2}), describe(200), describe(50));`,
+		},
+		{
+			name: "arguments",
+			src: `fn f(a, b = a * 2, ...rest) { [a, b, rest] }
+println(f(1), f(1, 5), f(1, 2, 3, 4), f(b: 3, a: 1));
+fn g([x, y], {z}) { x + y + z }
+println(g([1, 2], {z: 3}));
+f();`,
+		},
+		{
+			name: "tail calls",
+			src: `fn loop(n, acc) { if n == 0 { acc } else { loop(n - 1, acc + n) } }
+println(loop(100000, 0));`,
+		},
+		{
+			name: "calls returned at the top level",
+			src: `fn main() { println("main ran"); 0 }
+return main();`,
+		},
+		{
+			name: "stack overflow",
+			src: `fn f(n) { 1 + f(n + 1) }
+f(0);`,
+		},
+		{
+			name: "results",
+			src: `fn half(n) { if n % 2 == 0 { ok(n / 2) } else { err("odd") } }
+fn quarter(n) { ok(half(half(n)?)?) }
+println(quarter(8), quarter(6), unwrap_or(quarter(3), 0));
+unwrap(quarter(6));`,
+		},
+		{
+			name: "integers beyond 64 bits",
+			src: `let max = 9223372036854775807;
+let min = -max - 1;
+println(max + 1, min - 1, max - -1, min + -1, max * 2, min * -1, -1 * min, 3037000500 * 3037000500);
+println(min % -1, -7 % 3, 7 % -3, -7 % -3, (max + 1) % 10, min % max);`,
+		},
+		{
+			name: "ranges and maps",
+			src: `for i in 10..0 step -3 { print(i, ""); }
+println();
+let m = {a: 1, b: [2, 3]};
+for k in m { print(k, ""); }
+println(m.a, m.b);`,
+		},
+		{
+			name: "user-defined iterators",
+			src: `fn countdown(n) { fn() { if n == 0 { nil } else { n = n - 1; n + 1 } } }
+for i in countdown(3) { print(i, ""); }
+println();`,
+		},
+	}
+
+	Convey("The VM behaves as the interpreter does", t, func() {
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				tree, vm := compare(tc.src)
+				So(vm, ShouldResemble, tree)
+			})
+		}
+	})
+}
+
+func TestVM_stdio(t *testing.T) {
+	Convey("builtins read Stdin and write Stdout", t, func() {
+		var out strings.Builder
+		m := New("main.nv", []byte(`let a = getline(); let b = getline();
+println(a, b, getline(), getline() == "");`))
+		m.Stdin = strings.NewReader("a\nb\r\nc")
+		m.Stdout = &out
+		m.Run()
+		So(out.String(), ShouldEqual, "a b c true\n")
+	})
+
+	Convey("warnings go to Stderr", t, func() {
+		var out, errs strings.Builder
+		m := New("main.nv", []byte("let x = 1; let x = 2; print(x);"))
+		m.Redeclarations = true
+		m.Stdout, m.Stderr = &out, &errs
+		m.Run()
+		So(out.String(), ShouldEqual, "2")
+		So(errs.String(), ShouldEqual, "warning: main.nv:1:12: x redeclared in this scope, previous declaration at main.nv:1:1\n")
+	})
+}
+
+func TestVM_imports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib/strutil.nv": `fn greet(name) { format("hello, {}", name) }`,
+		"path/mathx.nv":  `fn sq(x) { x * x } let cfg = {name: "mathx"}; fn _h() { 1 }`,
+		"failing.nv":     `fn f() { throw error("from f"); } f();`,
+		"a.nv":           "import b;",
+		"b.nv":           "import a;",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		src  string
+	}{
+		{name: "relative path", src: `import "lib/strutil"; println(strutil.greet("naive"));`},
+		{name: "search path and alias", src: `import mathx as m; println(m.sq(4), m.cfg.name);`},
+		{name: "names", src: `import {sq, cfg as c} from mathx; import "lib/strutil.nv" as s; println(sq(3), c, s.greet("x"));`},
+		{name: "private names", src: `import {sq, _h} from mathx;`},
+		{name: "private members", src: `import mathx; mathx._h;`},
+		{name: "missing members", src: `import mathx; mathx.cube(2);`},
+		{name: "errors in modules", src: `import failing;`},
+		{name: "cycles", src: `import a;`},
+	}
+
+	Convey("The VM imports modules as the interpreter does", t, func() {
+		main := filepath.Join(dir, "main.nv")
+		path := []string{filepath.Join(dir, "path")}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				var tree, vm [2]string
+				tree[0], tree[1] = capture(func(out io.Writer) {
+					interp := interpreter.New(main, []byte(tc.src))
+					interp.SearchPath, interp.Stdout = path, out
+					interp.Interpret()
+				})
+				vm[0], vm[1] = capture(func(out io.Writer) {
+					m := New(main, []byte(tc.src))
+					m.SearchPath, m.Stdout = path, out
+					m.Run()
+				})
+				So(vm, ShouldResemble, tree)
+			})
+		}
+	})
+}
+
+func TestVM_capabilities(t *testing.T) {
+	Convey("The VM denies builtins as the interpreter does", t, func() {
+		src := `println(format("{}", 1)); try { print("x"); } catch e { println("caught"); } getline();`
+		var tree, vm [2]string
+		tree[0], tree[1] = capture(func(out io.Writer) {
+			interp := interpreter.NewWithCapabilities("main.nv", []byte(src), interpreter.CapNone)
+			interp.Stdout = out
+			interp.Interpret()
+		})
+		vm[0], vm[1] = capture(func(out io.Writer) {
+			m := NewWithCapabilities("main.nv", []byte(src), interpreter.CapNone)
+			m.Stdout = out
+			m.Run()
+		})
+		So(tree[1], ShouldContainSubstring, "permission denied: function println needs the stdio capability")
+		So(vm, ShouldResemble, tree)
+	})
+
+	Convey("The VM denies imports as the interpreter does", t, func() {
+		dir := t.TempDir()
+		So(os.WriteFile(filepath.Join(dir, "lib.nv"), []byte("let secret = 1;"), 0644), ShouldBeNil)
+		main := filepath.Join(dir, "main.nv")
+		src := "import lib; println(lib.secret);"
+		var tree, vm [2]string
+		tree[0], tree[1] = capture(func(out io.Writer) {
+			interp := interpreter.NewWithCapabilities(main, []byte(src), interpreter.CapStdio)
+			interp.Stdout = out
+			interp.Interpret()
+		})
+		vm[0], vm[1] = capture(func(out io.Writer) {
+			m := NewWithCapabilities(main, []byte(src), interpreter.CapStdio)
+			m.Stdout = out
+			m.Run()
+		})
+		So(tree[1], ShouldEndWith, "permission denied: import of module lib needs the fs-read capability")
+		So(vm, ShouldResemble, tree)
+	})
+}
+
+func TestVM_limits(t *testing.T) {
+	// run runs src with the VM under l, and returns the LimitError it raises,
+	// if any.
+	run := func(src string, l interpreter.Limits) (err *interpreter.LimitError) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(*interpreter.LimitError)
+			}
+		}()
+		m := New("main.nv", []byte(src))
+		m.Limits = l
+		m.Run()
+		return nil
+	}
+
+	Convey("The VM stops code that exceeds its limits", t, func() {
+		testCases := []struct {
+			name   string
+			src    string
+			limits func() interpreter.Limits
+			want   interpreter.Limit
+		}{
+			{
+				name:   "steps in loops",
+				src:    "while true { }",
+				limits: func() interpreter.Limits { return interpreter.Limits{MaxSteps: 100} },
+				want:   interpreter.StepLimit,
+			},
+			{
+				name:   "steps in tail calls",
+				src:    "fn f() { f() } f();",
+				limits: func() interpreter.Limits { return interpreter.Limits{MaxSteps: 100} },
+				want:   interpreter.StepLimit,
+			},
+			{
+				name:   "allocations",
+				src:    "let x = 2; while true { x = x * x; }",
+				limits: func() interpreter.Limits { return interpreter.Limits{MaxAlloc: 1000} },
+				want:   interpreter.AllocLimit,
+			},
+			{
+				name: "deadline",
+				src:  "for x in 0..1000000000 { }",
+				limits: func() interpreter.Limits {
+					return interpreter.Limits{Deadline: time.Now().Add(10 * time.Millisecond)}
+				},
+				want: interpreter.TimeLimit,
+			},
+			{
+				name: "context",
+				src:  "fn f() { } while true { f(); }",
+				limits: func() interpreter.Limits {
+					ctx, cancel := context.WithCancel(context.Background())
+					time.AfterFunc(10*time.Millisecond, cancel)
+					return interpreter.Limits{Context: ctx}
+				},
+				want: interpreter.Cancellation,
+			},
+			{
+				name: "try statements",
+				src: `fn f() { try { while true { } } catch e { } finally { return 1; } }
+while true { f(); }`,
+				limits: func() interpreter.Limits { return interpreter.Limits{MaxSteps: 100} },
+				want:   interpreter.StepLimit,
+			},
+		}
+		for _, tc := range testCases {
+			Convey(tc.name, func() {
+				err := run(tc.src, tc.limits())
+				So(err, ShouldNotBeNil)
+				So(err.Limit, ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("The VM counts steps and records stacks as the interpreter does", t, func() {
+		src := "fn spin(n) { for k in 0..n { } }\nspin(5); spin(1000);"
+		l := interpreter.Limits{MaxSteps: 100}
+		err := run(src, l)
+		So(err, ShouldNotBeNil)
+
+		var want *interpreter.LimitError
+		func() {
+			defer func() {
+				want = recover().(*interpreter.LimitError)
+			}()
+			interp := interpreter.New("main.nv", []byte(src))
+			interp.Limits = l
+			interp.Interpret()
+		}()
+		So(err, ShouldResemble, want)
+		So(err.Stack[1].String(), ShouldEqual, "<main> (main.nv:2:10)")
+	})
+}